@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// putPriceAlert opts the caller in to price-drop alerts for a favorite. An
+// optional target_price only alerts once the price reaches it; otherwise any
+// drop below the current price alerts. Alerts are only delivered to customers
+// who granted marketing consent; setting one does not grant it.
+func putPriceAlert(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		favoriteID, err := strconv.Atoi(mux.Vars(r)["favoriteId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidFavoriteID)
+			return
+		}
+		var data struct {
+			TargetPrice *Money `json:"target_price"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if data.TargetPrice != nil && (data.TargetPrice.Amount <= 0 || data.TargetPrice.Currency != shopCurrency) {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidTargetPrice)
+			return
+		}
+
+		var alert struct {
+			ItemID         int    `json:"item_id"`
+			TargetPrice    *Money `json:"target_price"`
+			ReferencePrice Money  `json:"reference_price"`
+		}
+		err = db.QueryRow(`
+        INSERT INTO price_alerts (owner, item_id, target_price, reference_price)
+        SELECT $1, s.id, $3, s.price
+        FROM favorite f
+        INNER JOIN sneakers s ON s.id = f.item_id
+        WHERE f.id = $2 AND f.owner = $1 AND `+itemVisible("s")+`
+        ON CONFLICT (owner, item_id) DO UPDATE
+            SET target_price = EXCLUDED.target_price, reference_price = EXCLUDED.reference_price
+        RETURNING item_id, target_price, reference_price`, owner, favoriteID, data.TargetPrice).
+			Scan(&alert.ItemID, &alert.TargetPrice, &alert.ReferencePrice)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeFavoriteNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(alert)
+	}
+}
+
+// deletePriceAlert opts the caller out of price-drop alerts for a favorite.
+func deletePriceAlert(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		favoriteID, err := strconv.Atoi(mux.Vars(r)["favoriteId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidFavoriteID)
+			return
+		}
+
+		_, err = db.Exec(`
+        DELETE FROM price_alerts
+        WHERE owner = $1 AND item_id = (SELECT item_id FROM favorite WHERE id = $2 AND owner = $1)`, owner, favoriteID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// checkPriceDrops notifies subscribers whose sneaker is now cheaper than the
+// price they were last told about and, if they set one, at or below their
+// target. The drop is kept for GET /me/alerts, and the reference price then
+// moves down so each drop alerts once.
+func checkPriceDrops(db *sql.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `
+        SELECT a.id, a.owner, s.id, s.title, s.price, a.reference_price
+        FROM price_alerts a
+        INNER JOIN sneakers s ON s.id = a.item_id
+        WHERE `+itemVisible("s")+` AND s.price < a.reference_price
+          AND (a.target_price IS NULL OR s.price <= a.target_price)`)
+		if err != nil {
+			return err
+		}
+		type drop struct {
+			alertID, itemID, price, was int
+			owner, title                string
+		}
+		var drops []drop
+		for rows.Next() {
+			var d drop
+			if err := rows.Scan(&d.alertID, &d.owner, &d.itemID, &d.title, &d.price, &d.was); err != nil {
+				rows.Close()
+				return err
+			}
+			drops = append(drops, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, d := range drops {
+			err := notify.Notify(ctx, notification{
+				Owner:  d.owner,
+				Kind:   "price_drop",
+				Title:  fmt.Sprintf("Price drop: %s", d.title),
+				Body:   fmt.Sprintf("%s is now %s (was %s).", d.title, money(d.price), money(d.was)),
+				ItemID: &d.itemID,
+			})
+			if err != nil {
+				return err
+			}
+			_, err = db.ExecContext(ctx, `
+            WITH dropped AS (
+                INSERT INTO price_drops (owner, item_id, previous_price, price) VALUES ($2, $3, $4, $5)
+            )
+            UPDATE price_alerts SET reference_price = $5 WHERE id = $1`, d.alertID, d.owner, d.itemID, d.was, d.price)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// getAlerts lists the price drops the caller was alerted to in the last 30
+// days, most recent first, with the sneaker and, while it is still
+// favorited, its favorite.
+func getAlerts(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		limit, ok := queryLimit(r, 50, 200)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT d.id, f.id, s.id, s.title, s.price, s.imageUrl, d.previous_price, d.price, d.dropped_at
+        FROM price_drops d
+        INNER JOIN sneakers s ON s.id = d.item_id
+        LEFT JOIN favorite f ON f.item_id = d.item_id AND f.owner = d.owner
+        WHERE d.owner = $1 AND d.dropped_at > now() - interval '30 days' AND `+itemVisible("s")+`
+        ORDER BY d.dropped_at DESC, d.id DESC
+        LIMIT $2`, owner, limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type alert struct {
+			ID            int            `json:"id"`
+			FavoriteID    *int           `json:"favorite_id"`
+			Item          sneakerSummary `json:"item"`
+			PreviousPrice Money          `json:"previous_price"`
+			Price         Money          `json:"price"`
+			DroppedAt     time.Time      `json:"dropped_at"`
+		}
+		alerts := []alert{}
+		for rows.Next() {
+			var a alert
+			err := rows.Scan(&a.ID, &a.FavoriteID, &a.Item.ID, &a.Item.Title, &a.Item.Price, &a.Item.ImageURL, &a.PreviousPrice, &a.Price, &a.DroppedAt)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			alerts = append(alerts, a)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(alerts)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// adminToken is the bearer token required by admin endpoints. When it is not
+// configured every admin request is rejected.
+var adminToken = os.Getenv("ADMIN_TOKEN")
+
+// callerID identifies the customer making the request: a signed-in user by the
+// X-User-ID header, otherwise an anonymous app install by X-Device-ID. The two
+// are prefixed so they can never collide. It returns "" for unidentified callers.
+func callerID(r *http.Request) string {
+	if id := strings.TrimSpace(r.Header.Get("X-User-ID")); id != "" {
+		return "user:" + id
+	}
+	if id := strings.TrimSpace(r.Header.Get("X-Device-ID")); id != "" {
+		return "device:" + id
+	}
+	return ""
+}
+
+// requireCaller answers 401 and returns "" when the request does not identify
+// the caller.
+func requireCaller(w http.ResponseWriter, r *http.Request) string {
+	id := callerID(r)
+	if id == "" {
+		writeError(w, r, http.StatusUnauthorized, errCodeIdentityRequired)
+	}
+	return id
+}
+
+// requireAdmin rejects requests that do not carry the admin bearer token.
+// Requests authenticated with a client certificate on the mTLS listener need
+// no token; with ADMIN_REQUIRE_MTLS, they are the only ones accepted.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hasClientCert(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if adminRequiresMTLS {
+			writeError(w, r, http.StatusForbidden, errCodeClientCertRequired)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, r, http.StatusUnauthorized, errCodeUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// validGTIN reports whether code is a well-formed EAN-8, UPC-A, EAN-13 or
+// GTIN-14 barcode, including its check digit.
+func validGTIN(code string) bool {
+	switch len(code) {
+	case 8, 12, 13, 14:
+	default:
+		return false
+	}
+	sum := 0
+	for n := len(code) - 1; n >= 0; n-- {
+		d := int(code[n] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		// Weights alternate 3, 1 from the digit left of the check digit
+		if n != len(code)-1 && (len(code)-1-n)%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// normalizeCode trims an optional SKU or barcode, treating blank as absent.
+func normalizeCode(code *string) *string {
+	if code == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*code)
+	if trimmed == "" {
+		return nil
+	}
+	return &trimmed
+}
+
+// uniqueCode maps a unique violation on a SKU, barcode or slug column to its
+// error code.
+func uniqueCode(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && strings.Contains(pqErr.Constraint, "barcode") {
+		return errCodeBarcodeExists
+	}
+	if errors.As(err, &pqErr) && strings.Contains(pqErr.Constraint, "slug") {
+		return errCodeSlugExists
+	}
+	return errCodeSKUExists
+}
+
+// lookupItem resolves a scanned barcode or a SKU, given as ?barcode= or ?sku=,
+// to a sneaker. Codes of individual sizes resolve to the sneaker too, and the
+// matched size is returned alongside it.
+func lookupItem(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		column, code := "barcode", r.URL.Query().Get("barcode")
+		if code == "" {
+			column, code = "sku", r.URL.Query().Get("sku")
+		}
+		code = strings.TrimSpace(code)
+		if code == "" {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLookup)
+			return
+		}
+
+		var result struct {
+			Item    item     `json:"item"`
+			Variant *variant `json:"variant"`
+		}
+		market := requestMarket(w, r)
+		var err error
+		result.Item, err = scanItem(db.QueryRow("SELECT "+itemColumns+" FROM sneakers WHERE "+column+" = $1 AND "+itemVisible("")+" AND "+itemInMarket("", "$2"), code, market))
+		if errors.Is(err, sql.ErrNoRows) {
+			var v variant
+			v, err = scanVariant(db.QueryRow("SELECT "+variantColumns+" FROM item_variants WHERE "+column+" = $1", code))
+			if err == nil {
+				result.Variant = &v
+				result.Item, err = scanItem(db.QueryRow("SELECT "+itemColumns+" FROM sneakers WHERE id = $1 AND "+itemVisible("")+" AND "+itemInMarket("", "$2"), v.ItemID, market))
+			}
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// brand is a sneaker manufacturer such as "Nike" or "New Balance".
+type brand struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int    `json:"count"`
+}
+
+// getBrands lists all brands alphabetically with the number of sneakers of
+// each sold in the caller's market.
+func getBrands(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query(`
+        SELECT b.id, b.name, b.slug, count(s.id)
+        FROM brands b
+        LEFT JOIN sneakers s ON s.brand_id = b.id AND `+itemVisible("s")+` AND `+itemInMarket("s", "$1")+`
+        GROUP BY b.id
+        ORDER BY b.name`, requestMarket(w, r))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		brands := []brand{}
+		for rows.Next() {
+			var b brand
+			if err := rows.Scan(&b.ID, &b.Name, &b.Slug, &b.Count); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			brands = append(brands, b)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(brands)
+	}
+}
+
+func getBrand(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var b brand
+		err := db.QueryRow(`
+        SELECT b.id, b.name, b.slug, (SELECT count(*) FROM sneakers WHERE brand_id = b.id AND `+itemVisible("")+` AND `+itemInMarket("", "$2")+`)
+        FROM brands b
+        WHERE b.slug = $1`, mux.Vars(r)["slug"], requestMarket(w, r)).Scan(&b.ID, &b.Name, &b.Slug, &b.Count)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeBrandNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b)
+	}
+}
+
+// createBrand adds a brand and assigns it to the existing sneakers without a
+// brand whose title starts with the brand name, which is where the brand used
+// to be recorded.
+func createBrand(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		b := brand{Name: strings.TrimSpace(data.Name), Slug: slugify(data.Name)}
+		if b.Slug == "" {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBrandName)
+			return
+		}
+
+		err := withTx(r.Context(), db, nil, func(tx *sql.Tx) error {
+			err := tx.QueryRow("INSERT INTO brands (name, slug) VALUES ($1, $2) RETURNING id", b.Name, b.Slug).Scan(&b.ID)
+			if isUniqueViolation(err) {
+				return &apiError{http.StatusConflict, errCodeBrandExists}
+			}
+			if err != nil {
+				return err
+			}
+
+			res, err := tx.Exec(`
+            UPDATE sneakers SET brand_id = $1
+            WHERE brand_id IS NULL AND (lower(title) = lower($2) OR lower(title) LIKE lower($2) || ' %')`, b.ID, b.Name)
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			b.Count = int(n)
+			return nil
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/brands/%s", b.Slug))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(b)
+	}
+}
+
+func renameBrand(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		brandID, err := strconv.Atoi(mux.Vars(r)["brandId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBrandID)
+			return
+		}
+
+		var data struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		b := brand{ID: brandID, Name: strings.TrimSpace(data.Name), Slug: slugify(data.Name)}
+		if b.Slug == "" {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBrandName)
+			return
+		}
+
+		err = db.QueryRow(`
+        UPDATE brands SET name = $2, slug = $3 WHERE id = $1
+        RETURNING (SELECT count(*) FROM sneakers WHERE brand_id = $1 AND `+itemVisible("")+`)`, b.ID, b.Name, b.Slug).Scan(&b.Count)
+		if isUniqueViolation(err) {
+			writeError(w, r, http.StatusConflict, errCodeBrandExists)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeBrandNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b)
+	}
+}
+
+// deleteBrand removes a brand; its sneakers are kept without a brand.
+func deleteBrand(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		brandID, err := strconv.Atoi(mux.Vars(r)["brandId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBrandID)
+			return
+		}
+
+		res, err := db.Exec("DELETE FROM brands WHERE id = $1", brandID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeBrandNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cachePolicy is the Cache-Control value sent for a class of endpoints.
+type cachePolicy string
+
+const (
+	// cachePublic lets browsers and CDNs cache shared catalog data briefly and
+	// serve it stale while revalidating. The TTLs come from the runtime config.
+	cachePublic cachePolicy = "public"
+	// cachePrivate is for per-customer data such as favorites, cart and orders,
+	// which must never be stored by shared caches.
+	cachePrivate cachePolicy = "private, no-store"
+	// cachePolling is for state clients poll every second or two, such as drop
+	// countdowns: a CDN can absorb the burst without serving stale phases.
+	cachePolling cachePolicy = "public, max-age=1, s-maxage=1"
+	// cacheNoStore is the default for anything not explicitly classified.
+	cacheNoStore cachePolicy = "no-store"
+)
+
+// cachePolicies classifies endpoints by path prefix. The first match wins.
+var cachePolicies = []struct {
+	prefix string
+	policy cachePolicy
+}{
+	{"/items", cachePublic},
+	{"/tags", cachePublic},
+	{"/brands", cachePublic},
+	{"/models", cachePublic},
+	{"/categories", cachePublic},
+	{"/releases", cachePublic},
+	{"/feeds", cachePublic},
+	{"/sitemap.xml", cachePublic},
+	{"/sitemaps", cachePublic},
+	{"/media", cachePublic},
+	{"/favorites", cachePrivate},
+	{"/cart", cachePrivate},
+	{"/orders", cachePrivate},
+	{"/me", cachePrivate},
+}
+
+func policyFor(path string) cachePolicy {
+	for _, p := range cachePolicies {
+		if path == p.prefix || strings.HasPrefix(path, p.prefix+"/") {
+			return p.policy
+		}
+	}
+	return cacheNoStore
+}
+
+// header returns the Cache-Control value of the policy.
+func (p cachePolicy) header() string {
+	if p == cachePublic {
+		c := currentConfig()
+		return fmt.Sprintf("public, max-age=%d, stale-while-revalidate=%d", c.CacheMaxAge, c.CacheStaleWhileRevalidate)
+	}
+	return string(p)
+}
+
+// cacheControl sets Cache-Control from the central policy. Only safe methods
+// may be cached; everything else is always no-store.
+func cacheControl(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := cacheNoStore
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			policy = policyFor(r.URL.Path)
+		}
+		w.Header().Set("Cache-Control", policy.header())
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notModified sets Last-Modified and reports whether the request's
+// If-Modified-Since allows answering with 304, in which case the response has
+// already been written.
+func notModified(w http.ResponseWriter, r *http.Request, lastModified time.Time) bool {
+	if lastModified.IsZero() {
+		return false
+	}
+	lastModified = lastModified.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// maxCartLines is the largest number of lines in a cart.
+const maxCartLines = 50
+
+// cartLine is a line of a cart as sent by clients. Prices are never taken
+// from clients; they come from the catalog.
+type cartLine struct {
+	ItemID    int  `json:"item_id"`
+	VariantID *int `json:"variant_id"`
+	Quantity  int  `json:"quantity"`
+}
+
+// previewCart quotes a cart without creating an order: the itemized lines,
+// discount, tax, the shipping options to the delivery country with the
+// chosen one (standard unless shipping_option is set), the import duties of
+// cross-border orders and the grand total, computed by calculateOrder
+// exactly as checkout will, and the estimated delivery dates of each
+// shipping option. Members get free standard shipping. A "discount_code"
+// takes its discount off the subtotal; an unknown or expired one is
+// rejected. With "store_credit": true the signed-in caller's store credit is
+// applied to the total.
+func previewCart(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data struct {
+			Lines          []cartLine `json:"lines"`
+			ShippingOption string     `json:"shipping_option"`
+			Country        string     `json:"country"`
+			DiscountCode   string     `json:"discount_code"`
+			StoreCredit    bool       `json:"store_credit"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if len(data.Lines) == 0 || len(data.Lines) > maxCartLines {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidCart)
+			return
+		}
+		itemIDs := make([]int, len(data.Lines))
+		var variantIDs []int
+		for n, l := range data.Lines {
+			if l.ItemID <= 0 || l.Quantity < 1 || l.Quantity > 10 {
+				writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidCart)
+				return
+			}
+			itemIDs[n] = l.ItemID
+			if l.VariantID != nil {
+				variantIDs = append(variantIDs, *l.VariantID)
+			}
+		}
+
+		perks, err := memberPerks(r.Context(), db, r)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		lines, code, err := cartOrderLines(db, data.Lines, itemIDs, variantIDs, perks.EarlyAccess, requestMarket(w, r))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if code != "" {
+			writeError(w, r, http.StatusUnprocessableEntity, code)
+			return
+		}
+
+		rules, ok, err := pricingRulesFor(r.Context(), db, data.Country)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if !ok {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeShippingUnavailable)
+			return
+		}
+		rules.FreeShipping = perks.FreeShipping
+
+		var discount *orderDiscount
+		if data.DiscountCode != "" {
+			if discount, ok, err = discountFor(r.Context(), db, data.DiscountCode); err != nil {
+				serverError(w, r, err)
+				return
+			} else if !ok {
+				writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidDiscountCode)
+				return
+			}
+		}
+
+		quote, ok := calculateOrder(lines, discount, data.ShippingOption, rules)
+		if !ok {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidShippingOption)
+			return
+		}
+		quote.estimateDelivery(rules, time.Now())
+		if data.StoreCredit {
+			owner := requireUser(w, r)
+			if owner == "" {
+				return
+			}
+			balance, err := NewStore(db).WalletBalance(r.Context(), owner)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			quote.applyStoreCredit(balance)
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(quote)
+	}
+}
+
+// cartOrderLines prices the lines of a cart from the catalog. It returns the
+// error code of the first line referring to a sneaker or size that does not
+// exist, is not sold in market, or is not on sale yet; member lets members
+// buy the sneakers they have early access to, as itemOnSale.
+func cartOrderLines(db *sql.DB, cart []cartLine, itemIDs, variantIDs []int, member bool, market string) ([]orderLine, string, error) {
+	type catalogItem struct {
+		title    string
+		price    int
+		released bool
+	}
+	items := map[int]catalogItem{}
+	rows, err := db.Query("SELECT id, title, price, "+itemOnSale("", member)+" FROM sneakers WHERE id = ANY($1) AND "+itemVisibleTo("", member)+" AND "+itemInMarket("", "$2"),
+		pq.Array(itemIDs), market)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var i catalogItem
+		if err := rows.Scan(&id, &i.title, &i.price, &i.released); err != nil {
+			return nil, "", err
+		}
+		items[id] = i
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	// The sneaker each requested size belongs to
+	variantItems := map[int]int{}
+	if len(variantIDs) > 0 {
+		rows, err := db.Query("SELECT id, item_id FROM item_variants WHERE id = ANY($1)", pq.Array(variantIDs))
+		if err != nil {
+			return nil, "", err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id, itemID int
+			if err := rows.Scan(&id, &itemID); err != nil {
+				return nil, "", err
+			}
+			variantItems[id] = itemID
+		}
+		if err := rows.Err(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	lines := make([]orderLine, len(cart))
+	for n, l := range cart {
+		i, ok := items[l.ItemID]
+		if !ok {
+			return nil, errCodeItemNotFound, nil
+		}
+		if !i.released {
+			return nil, errCodeItemNotReleased, nil
+		}
+		if l.VariantID != nil && variantItems[*l.VariantID] != l.ItemID {
+			return nil, errCodeVariantNotFound, nil
+		}
+		lines[n] = orderLine{ItemID: l.ItemID, VariantID: l.VariantID, Title: i.title, Quantity: l.Quantity, UnitPrice: money(i.price)}
+	}
+	return lines, "", nil
+}
+
+// favoriteCartLine is a favorite checked for the cart: the line to add to
+// it, priced from the catalog.
+type favoriteCartLine struct {
+	FavoriteID int `json:"favorite_id"`
+	cartLine
+	Title     string `json:"title"`
+	UnitPrice Money  `json:"unit_price"`
+}
+
+// favoriteCartLines checks the caller's favorites for the cart, all of them
+// or only favoriteID when it is not 0, in the favorites order. A favorite is
+// a cart line when its sneaker is on sale, its size is picked (variantID, or
+// the size that was favorited) for sneakers sold in sizes, and quantity is in
+// stock; otherwise the error code says why it is not. Favorites of sneakers
+// no longer in the catalog, or not sold in market, are left out.
+func favoriteCartLines(r *http.Request, db *sql.DB, owner, market string, favoriteID int, variantID *int, quantity int) ([]favoriteCartLine, []string, error) {
+	perks, err := memberPerks(r.Context(), db, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := db.QueryContext(r.Context(), `
+    SELECT f.id, f.item_id, coalesce($3, f.variant_id), s.title, s.price, s.stock,
+           `+itemOnSale("s", perks.EarlyAccess)+`,
+           EXISTS (SELECT 1 FROM item_variants v WHERE v.item_id = s.id),
+           (SELECT v.stock FROM item_variants v WHERE v.id = coalesce($3, f.variant_id) AND v.item_id = s.id)
+    FROM favorite f
+    INNER JOIN sneakers s ON s.id = f.item_id
+    WHERE f.owner = $1 AND ($2 = 0 OR f.id = $2) AND `+itemVisibleTo("s", perks.EarlyAccess)+` AND `+itemInMarket("s", "$4")+`
+    ORDER BY f.position, f.id`, owner, favoriteID, variantID, market)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var lines []favoriteCartLine
+	var codes []string
+	for rows.Next() {
+		l := favoriteCartLine{cartLine: cartLine{Quantity: quantity}}
+		var price, stock int
+		var released, sized bool
+		var sizeStock *int
+		if err := rows.Scan(&l.FavoriteID, &l.ItemID, &l.VariantID, &l.Title, &price, &stock, &released, &sized, &sizeStock); err != nil {
+			return nil, nil, err
+		}
+		l.UnitPrice = money(price)
+		code := ""
+		switch {
+		case !released:
+			code = errCodeItemNotReleased
+		case l.VariantID != nil && sizeStock == nil:
+			code = errCodeVariantNotFound
+		case sized && l.VariantID == nil:
+			code = errCodeSizeRequired
+		case sizeStock != nil && *sizeStock < quantity, sizeStock == nil && stock < quantity:
+			code = errCodeOutOfStock
+		}
+		lines = append(lines, l)
+		codes = append(codes, code)
+	}
+	return lines, codes, rows.Err()
+}
+
+// addFavoriteToCart checks a favorite for the cart and returns the line to
+// add, in one call instead of a favorite lookup and a cart preview. The body
+// can pick a size and a quantity, e.g. {"variant_id": 41, "quantity": 2};
+// by default the favorited size is taken, once. Carts are kept by clients,
+// so the line is not stored.
+func addFavoriteToCart(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		favoriteID, err := strconv.Atoi(mux.Vars(r)["favoriteId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidFavoriteID)
+			return
+		}
+		data := struct {
+			VariantID *int `json:"variant_id"`
+			Quantity  int  `json:"quantity"`
+		}{Quantity: 1}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if data.Quantity < 1 || data.Quantity > 10 {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidCart)
+			return
+		}
+
+		lines, codes, err := favoriteCartLines(r, db, owner, requestMarket(w, r), favoriteID, data.VariantID, data.Quantity)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if len(lines) == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeFavoriteNotFound)
+			return
+		}
+		if codes[0] != "" {
+			writeError(w, r, http.StatusUnprocessableEntity, codes[0])
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lines[0])
+	}
+}
+
+// addFavoritesToCart checks all the caller's favorites for the cart and
+// returns the lines of those available, one of each in the favorited size,
+// with the others skipped and why, e.g. size_required or out_of_stock.
+func addFavoritesToCart(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		lines, codes, err := favoriteCartLines(r, db, owner, requestMarket(w, r), 0, nil, 1)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		type skipped struct {
+			FavoriteID int    `json:"favorite_id"`
+			ItemID     int    `json:"item_id"`
+			Code       string `json:"code"`
+			Message    string `json:"message"`
+		}
+		result := struct {
+			Lines   []favoriteCartLine `json:"lines"`
+			Skipped []skipped          `json:"skipped"`
+		}{Lines: []favoriteCartLine{}, Skipped: []skipped{}}
+		lang := negotiateLanguage(r)
+		for n, l := range lines {
+			switch {
+			case codes[n] != "":
+				result.Skipped = append(result.Skipped, skipped{l.FavoriteID, l.ItemID, codes[n], localize(lang, codes[n])})
+			case len(result.Lines) == maxCartLines:
+				result.Skipped = append(result.Skipped, skipped{l.FavoriteID, l.ItemID, errCodeInvalidCart, localize(lang, errCodeInvalidCart)})
+			default:
+				result.Lines = append(result.Lines, l)
+			}
+		}
+
+		w.Header().Set("Content-Language", lang)
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
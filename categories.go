@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// category is a node of the catalog hierarchy, e.g. Trail under Running.
+type category struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID *int   `json:"parent_id"`
+}
+
+// categoryDescendants selects the ID of the category identified by the %s
+// placeholders (an ID or a slug, passed twice) and of all its descendants.
+const categoryDescendants = `WITH RECURSIVE sub AS (
+		SELECT id FROM categories WHERE id::text = %s OR slug = %s
+		UNION ALL
+		SELECT c.id FROM categories c JOIN sub ON c.parent_id = sub.id
+	) SELECT id FROM sub`
+
+// getCategories lists all categories ordered by name. Clients build the tree
+// from parent_id.
+func getCategories(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query("SELECT id, name, slug, parent_id FROM categories ORDER BY name")
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		categories := []category{}
+		for rows.Next() {
+			var c category
+			if err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.ParentID); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			categories = append(categories, c)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(categories)
+	}
+}
+
+// getCategory returns a category identified by ID or slug along with its path
+// from the root, for breadcrumbs, and its direct children.
+func getCategory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["category"]
+
+		var data struct {
+			category
+			Path     []category `json:"path"`
+			Children []category `json:"children"`
+		}
+		err := db.QueryRow("SELECT id, name, slug, parent_id FROM categories WHERE id::text = $1 OR slug = $1", key).
+			Scan(&data.ID, &data.Name, &data.Slug, &data.ParentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeCategoryNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		data.Path, err = queryCategories(db, `
+        WITH RECURSIVE path AS (
+            SELECT id, name, slug, parent_id, 0 AS depth FROM categories WHERE id = $1
+            UNION ALL
+            SELECT c.id, c.name, c.slug, c.parent_id, path.depth + 1
+            FROM categories c JOIN path ON c.id = path.parent_id
+        )
+        SELECT id, name, slug, parent_id FROM path ORDER BY depth DESC`, data.ID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		data.Children, err = queryCategories(db, "SELECT id, name, slug, parent_id FROM categories WHERE parent_id = $1 ORDER BY name", data.ID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+	}
+}
+
+func queryCategories(db *sql.DB, query string, args ...any) ([]category, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []category{}
+	for rows.Next() {
+		var c category
+		if err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.ParentID); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+// decodeCategory reads and validates the name and parent of a category.
+func decodeCategory(r *http.Request) (category, string) {
+	var data struct {
+		Name     string `json:"name"`
+		ParentID *int   `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return category{}, errCodeInvalidBody
+	}
+	c := category{Name: strings.TrimSpace(data.Name), Slug: slugify(data.Name), ParentID: data.ParentID}
+	if c.Slug == "" {
+		return category{}, errCodeInvalidCategoryName
+	}
+	return c, ""
+}
+
+func createCategory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, code := decodeCategory(r)
+		if code != "" {
+			writeError(w, r, http.StatusBadRequest, code)
+			return
+		}
+
+		err := db.QueryRow("INSERT INTO categories (name, slug, parent_id) VALUES ($1, $2, $3) RETURNING id", c.Name, c.Slug, c.ParentID).Scan(&c.ID)
+		if isUniqueViolation(err) {
+			writeError(w, r, http.StatusConflict, errCodeCategoryExists)
+			return
+		}
+		if isForeignKeyViolation(err) {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidCategoryParent)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/categories/%s", c.Slug))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// updateCategory renames and/or moves a category. A category cannot be moved
+// below itself or one of its descendants.
+func updateCategory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		categoryID, err := strconv.Atoi(mux.Vars(r)["categoryId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidCategoryID)
+			return
+		}
+		c, code := decodeCategory(r)
+		if code != "" {
+			writeError(w, r, http.StatusBadRequest, code)
+			return
+		}
+		c.ID = categoryID
+
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			if c.ParentID != nil {
+				var cycle bool
+				err := tx.q.QueryRowContext(r.Context(), "SELECT $2 IN ("+fmt.Sprintf(categoryDescendants, "$1", "$1")+")",
+					strconv.Itoa(c.ID), *c.ParentID).Scan(&cycle)
+				if err != nil {
+					return err
+				}
+				if cycle {
+					return &apiError{http.StatusUnprocessableEntity, errCodeInvalidCategoryParent}
+				}
+			}
+
+			res, err := tx.q.ExecContext(r.Context(), "UPDATE categories SET name = $2, slug = $3, parent_id = $4 WHERE id = $1", c.ID, c.Name, c.Slug, c.ParentID)
+			switch {
+			case isUniqueViolation(err):
+				return &apiError{http.StatusConflict, errCodeCategoryExists}
+			case isForeignKeyViolation(err):
+				return &apiError{http.StatusUnprocessableEntity, errCodeInvalidCategoryParent}
+			case err != nil:
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return &apiError{http.StatusNotFound, errCodeCategoryNotFound}
+			}
+			return nil
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// deleteCategory removes a category without subcategories. Its sneakers are
+// kept without a category.
+func deleteCategory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		categoryID, err := strconv.Atoi(mux.Vars(r)["categoryId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidCategoryID)
+			return
+		}
+
+		res, err := db.Exec("DELETE FROM categories WHERE id = $1", categoryID)
+		if isForeignKeyViolation(err) {
+			writeError(w, r, http.StatusConflict, errCodeCategoryHasChildren)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeCategoryNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
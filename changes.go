@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/lib/pq"
+)
+
+// changeCursor is a position in the catalog change log. Changes are read in
+// the order of their transaction ID, then of their ID, and only from
+// transactions older than any still running, so a change committed later can
+// never sort before a cursor already handed out.
+type changeCursor struct {
+	txid, id int64
+}
+
+func (c changeCursor) String() string {
+	return fmt.Sprintf("%d-%d", c.txid, c.id)
+}
+
+func parseChangeCursor(s string) (changeCursor, bool) {
+	var c changeCursor
+	if s == "" {
+		return c, true
+	}
+	n, err := fmt.Sscanf(s, "%d-%d", &c.txid, &c.id)
+	return c, err == nil && n == 2 && c.String() == s
+}
+
+// getItemChanges returns the sneakers created, updated or deleted since
+// ?since=<cursor>, so POS terminals can sync the catalog incrementally; the
+// first sync omits since. Each sneaker is listed once, with its current
+// state; sneakers that left the public catalog, by deletion or otherwise,
+// are listed as deleted without item. The response carries the cursor to
+// pass next time and whether more changes are waiting:
+//
+//	{"changes": [{"item_id": 12, "change": "updated", "item": {...}}],
+//	 "cursor": "7411-893", "has_more": false}
+func getItemChanges(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cursor, ok := parseChangeCursor(r.URL.Query().Get("since"))
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidCursor)
+			return
+		}
+		limit, ok := queryLimit(r, 500, 5000)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), `
+        SELECT txid, id, item_id, change FROM item_changes
+        WHERE (txid, id) > ($1, $2) AND txid < txid_snapshot_xmin(txid_current_snapshot())
+        ORDER BY txid, id
+        LIMIT $3`, cursor.txid, cursor.id, limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type change struct {
+			ItemID int    `json:"item_id"`
+			Change string `json:"change"`
+			Item   *item  `json:"item,omitempty"`
+		}
+		var changes []*change
+		byItem := map[int]*change{}
+		count := 0
+		for rows.Next() {
+			var c change
+			if err := rows.Scan(&cursor.txid, &cursor.id, &c.ItemID, &c.Change); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			count++
+			// The sneaker moves to its latest change; it counts as created
+			// if that happened since the cursor
+			if prev, ok := byItem[c.ItemID]; ok {
+				if prev.Change == "created" && c.Change != "deleted" {
+					c.Change = "created"
+				}
+				changes = slices.DeleteFunc(changes, func(other *change) bool { return other == prev })
+			}
+			byItem[c.ItemID] = &c
+			changes = append(changes, &c)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		ids := make([]int, 0, len(changes))
+		for _, c := range changes {
+			ids = append(ids, c.ItemID)
+		}
+		itemRows, err := db.QueryContext(r.Context(), "SELECT "+itemColumns+" FROM sneakers WHERE id = ANY($1) AND "+itemVisible(""), pq.Array(ids))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer itemRows.Close()
+		for itemRows.Next() {
+			i, err := scanItem(itemRows)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			byItem[i.ID].Item = &i
+		}
+		if err := itemRows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		result := struct {
+			Changes []*change `json:"changes"`
+			Cursor  string    `json:"cursor"`
+			HasMore bool      `json:"has_more"`
+		}{Changes: []*change{}, Cursor: cursor.String(), HasMore: count == limit}
+		for _, c := range changes {
+			if c.Item == nil {
+				c.Change = "deleted"
+			}
+			result.Changes = append(result.Changes, c)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// logScheduledChanges logs sneakers whose scheduled publication has passed
+// as updated, since no write happens when they appear in the catalog.
+func logScheduledChanges(db *sql.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, `
+        INSERT INTO item_changes (item_id, change)
+        SELECT s.id, 'updated' FROM sneakers s
+        WHERE s.publish_at <= now()
+          AND NOT EXISTS (SELECT 1 FROM item_changes c WHERE c.item_id = s.id AND c.changed_at >= s.publish_at)`)
+		return err
+	}
+}
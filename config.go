@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// getenv returns the environment variable key, or def when it is unset or empty.
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// getenvDuration parses the environment variable key as a time.Duration
+// ("15m", "24h"), falling back to def when it is unset or invalid.
+func getenvDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+// getenvInt parses the environment variable key as a non-negative integer,
+// falling back to def when it is unset or invalid.
+func getenvInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n >= 0 {
+		return n
+	}
+	return def
+}
+
+// storefrontURL is the public base URL of the web storefront, used to build
+// links to product pages in sitemaps and feeds.
+var storefrontURL = getenv("STOREFRONT_URL", "http://localhost:3000")
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Consent purposes a customer can grant or withdraw.
+const (
+	consentMarketing = "marketing" // promotional messages such as price drops
+	consentTracking  = "tracking"  // anonymized product analytics
+)
+
+// consentDefaults holds whether each purpose is granted while the customer
+// has not decided: marketing is opt-in, anonymized analytics opt-out.
+var consentDefaults = map[string]bool{
+	consentMarketing: false,
+	consentTracking:  true,
+}
+
+// notificationConsent is the purpose each kind of notification needs.
+// Notifications of other kinds, such as reminders the customer set, are
+// always delivered. Setting a price or restock alert does not grant
+// marketing: customers opt in with PUT /me/consents/marketing.
+var notificationConsent = map[string]string{
+	"price_drop":    consentMarketing,
+	"back_in_stock": consentMarketing,
+}
+
+// consent is a customer's decision on a purpose. Decisions are never updated
+// in place: every change is a new event, so the history is an audit trail.
+type consent struct {
+	Purpose    string    `json:"purpose"`
+	Granted    bool      `json:"granted"`
+	Source     string    `json:"source"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// RecordConsent stores a consent decision of owner.
+func (s Store) RecordConsent(ctx context.Context, owner string, c consent) (consent, error) {
+	err := s.q.QueryRowContext(ctx, `
+    INSERT INTO consent_events (owner, purpose, granted, source) VALUES ($1, $2, $3, $4)
+    RETURNING recorded_at`, owner, c.Purpose, c.Granted, c.Source).Scan(&c.RecordedAt)
+	return c, err
+}
+
+// HasConsent reports whether owner currently grants purpose, falling back to
+// the purpose's default when they never decided.
+func (s Store) HasConsent(ctx context.Context, owner, purpose string) (bool, error) {
+	var granted bool
+	err := s.q.QueryRowContext(ctx, `
+    SELECT granted FROM consent_events
+    WHERE owner = $1 AND purpose = $2
+    ORDER BY recorded_at DESC, id DESC
+    LIMIT 1`, owner, purpose).Scan(&granted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return consentDefaults[purpose], nil
+	}
+	return granted, err
+}
+
+// consentChecker reports whether a customer grants a purpose; Store
+// implements it.
+type consentChecker interface {
+	HasConsent(ctx context.Context, owner, purpose string) (bool, error)
+}
+
+// consentNotifier drops notifications the recipient has not consented to
+// before handing the rest to next.
+type consentNotifier struct {
+	store consentChecker
+	next  notifier
+}
+
+func (n consentNotifier) Notify(ctx context.Context, msg notification) error {
+	if purpose, ok := notificationConsent[msg.Kind]; ok {
+		granted, err := n.store.HasConsent(ctx, msg.Owner, purpose)
+		if err != nil {
+			return err
+		}
+		if !granted {
+			logAt(levelDebug, "notify: %s to %s skipped without %s consent", msg.Kind, msg.Owner, purpose)
+			return nil
+		}
+	}
+	return n.next.Notify(ctx, msg)
+}
+
+// getConsents returns the caller's current decision on every purpose and the
+// full history of their decisions, newest first. Purposes without a decision
+// are reported with their default and no source.
+func getConsents(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT purpose, granted, source, recorded_at FROM consent_events
+        WHERE owner = $1
+        ORDER BY recorded_at DESC, id DESC`, owner)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		data := struct {
+			Consents map[string]consent `json:"consents"`
+			History  []consent          `json:"history"`
+		}{Consents: map[string]consent{}, History: []consent{}}
+		for rows.Next() {
+			var c consent
+			if err := rows.Scan(&c.Purpose, &c.Granted, &c.Source, &c.RecordedAt); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			if _, ok := data.Consents[c.Purpose]; !ok {
+				data.Consents[c.Purpose] = c
+			}
+			data.History = append(data.History, c)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		for purpose, granted := range consentDefaults {
+			if _, ok := data.Consents[purpose]; !ok {
+				data.Consents[purpose] = consent{Purpose: purpose, Granted: granted}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+	}
+}
+
+// putConsent records the caller granting or withdrawing a purpose, along with
+// where they did it, e.g. {"granted": true, "source": "newsletter_signup"}.
+func putConsent(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		purpose := mux.Vars(r)["purpose"]
+		if _, ok := consentDefaults[purpose]; !ok {
+			writeError(w, r, http.StatusNotFound, errCodeInvalidConsentPurpose)
+			return
+		}
+
+		var data struct {
+			Granted *bool  `json:"granted"`
+			Source  string `json:"source"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		data.Source = strings.TrimSpace(data.Source)
+		if data.Granted == nil || data.Source == "" || len(data.Source) > 64 {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidConsent)
+			return
+		}
+
+		c, err := NewStore(db).RecordConsent(r.Context(), owner, consent{Purpose: purpose, Granted: *data.Granted, Source: data.Source})
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
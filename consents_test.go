@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// grants is a consentChecker answering from a fixed set of owner/purpose
+// decisions, falling back to the purpose defaults like Store.
+type grants map[[2]string]bool
+
+func (g grants) HasConsent(ctx context.Context, owner, purpose string) (bool, error) {
+	if granted, ok := g[[2]string{owner, purpose}]; ok {
+		return granted, nil
+	}
+	return consentDefaults[purpose], nil
+}
+
+// sentNotifications records the notifications that reach it.
+type sentNotifications []notification
+
+func (s *sentNotifications) Notify(ctx context.Context, n notification) error {
+	*s = append(*s, n)
+	return nil
+}
+
+func TestConsentNotifier(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   string
+		grants grants
+		sent   bool
+	}{
+		{"marketing without a decision", "price_drop", grants{}, false},
+		{"marketing withdrawn", "back_in_stock", grants{{"u1", consentMarketing}: false}, false},
+		{"marketing granted", "price_drop", grants{{"u1", consentMarketing}: true}, true},
+		{"another customer's grant", "price_drop", grants{{"u2", consentMarketing}: true}, false},
+		{"transactional", "release_reminder", grants{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent sentNotifications
+			n := consentNotifier{store: tt.grants, next: &sent}
+			if err := n.Notify(context.Background(), notification{Owner: "u1", Kind: tt.kind, Title: "Test"}); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(sent) == 1; got != tt.sent {
+				t.Errorf("%s delivered: %t, want %t", tt.kind, got, tt.sent)
+			}
+		})
+	}
+}
+
+// TestMarketingNotificationKinds guards against alerts that only go out with
+// marketing consent being delivered without it.
+func TestMarketingNotificationKinds(t *testing.T) {
+	for _, kind := range []string{"price_drop", "back_in_stock"} {
+		if notificationConsent[kind] != consentMarketing {
+			t.Errorf("%s notifications need %q consent, got %q", kind, consentMarketing, notificationConsent[kind])
+		}
+	}
+}
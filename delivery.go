@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// deliveryDays is the range of business days a carrier takes to deliver a
+// parcel once it has left the warehouse.
+type deliveryDays struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+func (d deliveryDays) valid() bool {
+	return d.Min >= 0 && d.Max >= d.Min && d.Max <= 60
+}
+
+// deliveryEstimate is the range of dates an order is expected to arrive on,
+// as YYYY-MM-DD dates in the warehouse's time zone.
+type deliveryEstimate struct {
+	Earliest string `json:"earliest"`
+	Latest   string `json:"latest"`
+}
+
+// warehouseLocation is the time zone of the warehouse, from WAREHOUSE_TZ
+// (e.g. "Europe/Paris"); the cutoff and dispatch days are in its local time.
+var warehouseLocation = loadWarehouseLocation()
+
+func loadWarehouseLocation() *time.Location {
+	loc, err := time.LoadLocation(getenv("WAREHOUSE_TZ", "UTC"))
+	if err != nil {
+		log.Printf("WAREHOUSE_TZ: %v; using UTC", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// orderCutoff is the local time of day, from ORDER_CUTOFF ("14:00"), after
+// which orders are only dispatched the next business day.
+var orderCutoff = getenv("ORDER_CUTOFF", "14:00")
+
+// dispatchDate returns the day an order placed at now leaves the warehouse:
+// the same day on business days before the cutoff, otherwise the next
+// business day. Public holidays are not taken into account.
+func dispatchDate(now time.Time) time.Time {
+	local := now.In(warehouseLocation)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, warehouseLocation)
+	cutoff, err := time.ParseInLocation("15:04", orderCutoff, warehouseLocation)
+	if err != nil {
+		cutoff = time.Date(0, 1, 1, 14, 0, 0, 0, warehouseLocation)
+	}
+	if !businessDay(day) || local.Hour()*60+local.Minute() >= cutoff.Hour()*60+cutoff.Minute() {
+		day = addBusinessDays(day, 1)
+	}
+	return day
+}
+
+func businessDay(t time.Time) bool {
+	return t.Weekday() != time.Saturday && t.Weekday() != time.Sunday
+}
+
+// addBusinessDays returns the date n business days after t.
+func addBusinessDays(t time.Time, n int) time.Time {
+	for n > 0 {
+		t = t.AddDate(0, 0, 1)
+		if businessDay(t) {
+			n--
+		}
+	}
+	return t
+}
+
+// estimateDelivery returns the delivery dates of an order placed at now and
+// sent with a carrier taking days.
+func estimateDelivery(now time.Time, days deliveryDays) *deliveryEstimate {
+	dispatch := dispatchDate(now)
+	return &deliveryEstimate{
+		Earliest: addBusinessDays(dispatch, days.Min).Format(time.DateOnly),
+		Latest:   addBusinessDays(dispatch, days.Max).Format(time.DateOnly),
+	}
+}
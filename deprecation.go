@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// deprecation describes a route, or a query parameter of a route, that clients
+// should stop using.
+type deprecation struct {
+	method string // HTTP method of the route
+	route  string // mux path template, e.g. "/favorites/{favoriteId}"
+	param  string // optional query parameter; empty deprecates the whole route
+	// anonymous deprecates only the calls that identify no caller with
+	// X-User-ID or X-Device-ID.
+	anonymous bool
+
+	since  time.Time // when the deprecation was announced
+	sunset time.Time // optional date after which the route may be removed
+	link   string    // optional URL documenting the replacement
+}
+
+// deprecations lists everything currently deprecated. Routes are removed from
+// here together with the route itself once the sunset date has passed.
+var deprecations = favoritesDeprecations()
+
+// favoritesDeprecations deprecates reading the legacy favorites list shared
+// by callers that do not identify themselves; see favoritesOwner. Changing
+// it is no longer possible.
+func favoritesDeprecations() []deprecation {
+	since := time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, time.January, 15, 0, 0, 0, 0, time.UTC)
+	var ds []deprecation
+	for _, route := range []struct{ method, route string }{
+		{http.MethodGet, "/favorites"},
+		{http.MethodHead, "/favorites"},
+		{http.MethodGet, "/favorites/export"},
+	} {
+		ds = append(ds, deprecation{method: route.method, route: route.route, anonymous: true, since: since, sunset: sunset})
+	}
+	return ds
+}
+
+// deprecationLogInterval limits how often the same host is logged for the same
+// deprecated route, so a chatty client does not flood the logs.
+const deprecationLogInterval = time.Hour
+
+// deprecationUse is when a host was last logged for a deprecated route, and
+// how many of its calls were not logged since.
+type deprecationUse struct {
+	logged time.Time
+	calls  int
+}
+
+// deprecationLog holds the uses of each deprecated route by each host.
+// Entries older than deprecationLogInterval are swept as often, so it only
+// grows with the hosts of the last interval.
+var deprecationLog = struct {
+	sync.Mutex
+	uses  map[string]*deprecationUse
+	swept time.Time
+}{uses: map[string]*deprecationUse{}}
+
+// deprecationSignals is router middleware that emits Deprecation, Sunset and
+// Link headers (RFC 9745, RFC 8594) for deprecated routes and parameters, and
+// logs which callers still use them.
+func deprecationSignals(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, _ := route.GetPathTemplate()
+
+		for _, d := range deprecations {
+			if d.method != r.Method || d.route != template {
+				continue
+			}
+			if d.param != "" && !r.URL.Query().Has(d.param) {
+				continue
+			}
+			if d.anonymous && callerID(r) != "" {
+				continue
+			}
+
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", d.since.Unix()))
+			if !d.sunset.IsZero() {
+				w.Header().Set("Sunset", d.sunset.UTC().Format(http.TimeFormat))
+			}
+			if d.link != "" {
+				w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"deprecation\"", d.link))
+			}
+			logDeprecatedUse(r, d)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logDeprecatedUse logs a call to d, once per interval for each remote address,
+// with the number of its calls since the last one logged. Hosts are told
+// apart by their remote address only: caller IDs and headers such as
+// X-Forwarded-For or User-Agent are set by clients and would let one grow the
+// log without bound.
+func logDeprecatedUse(r *http.Request, d deprecation) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	key := fmt.Sprintf("%s %s %s %t|%s", d.method, d.route, d.param, d.anonymous, host)
+
+	now := time.Now()
+	deprecationLog.Lock()
+	if now.Sub(deprecationLog.swept) >= deprecationLogInterval {
+		for k, use := range deprecationLog.uses {
+			if now.Sub(use.logged) >= deprecationLogInterval {
+				delete(deprecationLog.uses, k)
+			}
+		}
+		deprecationLog.swept = now
+	}
+	use, ok := deprecationLog.uses[key]
+	if ok && now.Sub(use.logged) < deprecationLogInterval {
+		use.calls++
+		deprecationLog.Unlock()
+		return
+	}
+	if !ok {
+		use = &deprecationUse{}
+		deprecationLog.uses[key] = use
+	}
+	unlogged := use.calls
+	use.logged, use.calls = now, 0
+	deprecationLog.Unlock()
+
+	caller := callerID(r)
+	if caller == "" {
+		caller = "anonymous"
+	}
+
+	what := d.method + " " + d.route
+	if d.param != "" {
+		what += " ?" + d.param
+	}
+	if d.anonymous {
+		what += " without caller identity"
+	}
+	var since string
+	if unlogged > 0 {
+		since = fmt.Sprintf(", %d more calls from there since the last report", unlogged)
+	}
+	logAt(levelWarn, "deprecated: %s used by %s from %s (%q)%s", what, caller, host, r.UserAgent(), since)
+}
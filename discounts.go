@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// discountCode is a code customers enter at checkout for an orderDiscount,
+// either a percentage (basis_points) or a fixed amount, valid between
+// StartsAt and EndsAt when set.
+type discountCode struct {
+	Code        string     `json:"code"`
+	BasisPoints int        `json:"basis_points,omitempty"`
+	Amount      *Money     `json:"amount,omitempty"`
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+}
+
+const discountCodeColumns = "code, basis_points, amount, starts_at, ends_at"
+
+func scanDiscountCode(row rowScanner) (discountCode, error) {
+	var d discountCode
+	var basisPoints sql.NullInt32
+	err := row.Scan(&d.Code, &basisPoints, &d.Amount, &d.StartsAt, &d.EndsAt)
+	d.BasisPoints = int(basisPoints.Int32)
+	return d, err
+}
+
+var discountCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// normalizeDiscountCode returns code as stored: codes are matched regardless
+// of case and surrounding spaces.
+func normalizeDiscountCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// validate returns the error code of the first invalid field of the
+// discount, or "" when it is valid.
+func (d *discountCode) validate() string {
+	if !discountCodePattern.MatchString(d.Code) {
+		return errCodeInvalidDiscount
+	}
+	if (d.BasisPoints != 0) == (d.Amount != nil) {
+		return errCodeInvalidDiscount
+	}
+	if d.BasisPoints < 0 || d.BasisPoints > 10000 {
+		return errCodeInvalidDiscount
+	}
+	if d.Amount != nil && (d.Amount.Amount <= 0 || d.Amount.Currency != shopCurrency) {
+		return errCodeInvalidDiscount
+	}
+	if d.StartsAt != nil && d.EndsAt != nil && !d.EndsAt.After(*d.StartsAt) {
+		return errCodeInvalidDiscount
+	}
+	return ""
+}
+
+// discountFor returns the discount of code, as entered by a customer. It
+// reports false when the code does not exist or is not valid at the moment.
+func discountFor(ctx context.Context, db *sql.DB, code string) (*orderDiscount, bool, error) {
+	d, err := scanDiscountCode(db.QueryRowContext(ctx, `
+    SELECT `+discountCodeColumns+` FROM discount_codes
+    WHERE code = $1 AND (starts_at IS NULL OR starts_at <= now()) AND (ends_at IS NULL OR ends_at > now())`,
+		normalizeDiscountCode(code)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &orderDiscount{Code: d.Code, BasisPoints: d.BasisPoints, Amount: d.Amount}, true, nil
+}
+
+func getDiscountCodes(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query("SELECT " + discountCodeColumns + " FROM discount_codes ORDER BY code")
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		codes := []discountCode{}
+		for rows.Next() {
+			d, err := scanDiscountCode(rows)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			codes = append(codes, d)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(codes)
+	}
+}
+
+// putDiscountCode creates or replaces a discount code, e.g. PUT
+// /admin/discounts/SPRING15 with {"basis_points": 1500, "ends_at":
+// "2026-06-01T00:00:00Z"}, or {"amount": {"amount": 1000, "currency":
+// "EUR"}} for a fixed amount off.
+func putDiscountCode(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var d discountCode
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		d.Code = normalizeDiscountCode(mux.Vars(r)["code"])
+		if code := d.validate(); code != "" {
+			writeError(w, r, http.StatusUnprocessableEntity, code)
+			return
+		}
+
+		var basisPoints *int
+		if d.BasisPoints != 0 {
+			basisPoints = &d.BasisPoints
+		}
+		d, err := scanDiscountCode(db.QueryRow(`
+        INSERT INTO discount_codes (code, basis_points, amount, starts_at, ends_at) VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (code) DO UPDATE
+            SET basis_points = EXCLUDED.basis_points, amount = EXCLUDED.amount,
+                starts_at = EXCLUDED.starts_at, ends_at = EXCLUDED.ends_at
+        RETURNING `+discountCodeColumns, d.Code, basisPoints, d.Amount, d.StartsAt, d.EndsAt))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+func deleteDiscountCode(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := db.Exec("DELETE FROM discount_codes WHERE code = $1", normalizeDiscountCode(mux.Vars(r)["code"]))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeDiscountNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// normalizedTitle returns the SQL expression of a title compared for
+// duplicates: lower case, without spaces and punctuation, so "Dunk Low
+// 'Panda'" matches "dunk low panda".
+func normalizedTitle(column string) string {
+	return "regexp_replace(lower(" + column + "), '[^[:alnum:]]+', '', 'g')"
+}
+
+// normalizedCode returns the SQL expression of a SKU or style code compared
+// for duplicates: upper case, letters and digits only, so "dd1391 100"
+// matches "DD1391-100".
+func normalizedCode(column string) string {
+	return "regexp_replace(upper(" + column + "), '[^A-Z0-9]+', '', 'g')"
+}
+
+// duplicateMatch is a sneaker of the catalog that another likely duplicates,
+// with the reason: "sku", "style_code" or "title".
+type duplicateMatch struct {
+	ItemID int    `json:"item_id"`
+	Title  string `json:"title"`
+	Reason string `json:"reason"`
+}
+
+// likelyDuplicateError rejects a new sneaker that matches others in the
+// catalog, unless the caller insists.
+type likelyDuplicateError struct {
+	matches []duplicateMatch
+}
+
+func (e *likelyDuplicateError) Error() string { return errCodeLikelyDuplicate }
+
+// findDuplicates returns the sneakers in the catalog that itemID likely
+// duplicates: the same SKU or style code written differently, or the same
+// brand and a near-identical title.
+func findDuplicates(ctx context.Context, q querier, itemID int) ([]duplicateMatch, error) {
+	skuMatch := normalizedCode("o.sku") + " = " + normalizedCode("n.sku")
+	styleMatch := normalizedCode("o.style_code") + " = " + normalizedCode("n.style_code")
+	rows, err := q.QueryContext(ctx, `
+    SELECT o.id, o.title, CASE WHEN `+skuMatch+` THEN 'sku' WHEN `+styleMatch+` THEN 'style_code' ELSE 'title' END,
+           `+normalizedTitle("o.title")+`, `+normalizedTitle("n.title")+`
+    FROM sneakers n
+    INNER JOIN sneakers o ON o.id <> n.id AND o.deleted_at IS NULL
+    WHERE n.id = $1 AND (`+skuMatch+` OR `+styleMatch+`
+        OR (o.brand_id IS NOT DISTINCT FROM n.brand_id
+            AND abs(length(`+normalizedTitle("o.title")+`) - length(`+normalizedTitle("n.title")+`)) <= $2))
+    ORDER BY o.id`, itemID, maxTitleDistance)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []duplicateMatch
+	for rows.Next() {
+		var m duplicateMatch
+		var title, newTitle string
+		if err := rows.Scan(&m.ItemID, &m.Title, &m.Reason, &title, &newTitle); err != nil {
+			return nil, err
+		}
+		if m.Reason == "title" && !similarTitles(title, newTitle) {
+			continue
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// maxTitleDistance is how many characters normalized titles may differ by
+// and still be taken for the same sneaker, e.g. "dunklowpanda" and
+// "dunklowpandas".
+const maxTitleDistance = 2
+
+// similarTitles reports whether two normalized titles are near-identical.
+// Their digits must be the same: "Jordan 1 Low" and "Jordan 4 Low" are
+// different sneakers. Short titles must match exactly.
+func similarTitles(a, b string) bool {
+	digits := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, s)
+	}
+	if digits(a) != digits(b) {
+		return false
+	}
+	if len([]rune(a)) < 10 {
+		return a == b
+	}
+	return editDistance(a, b) <= maxTitleDistance
+}
+
+// flagDuplicates records the sneakers in the catalog that itemID likely
+// duplicates for review, and returns them.
+func flagDuplicates(ctx context.Context, q querier, itemID int) ([]duplicateMatch, error) {
+	matches, err := findDuplicates(ctx, q, itemID)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range matches {
+		_, err := q.ExecContext(ctx, `
+        INSERT INTO item_duplicates (item_id, duplicate_of, reason) VALUES ($1, $2, $3)
+        ON CONFLICT (item_id, duplicate_of) DO NOTHING`, itemID, m.ItemID, m.Reason)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return matches, nil
+}
+
+// MergeItem folds the sneaker dupID into keepID: favorites, price alerts and
+// drops, restock alerts, recently viewed entries and releases move to keepID unless its owner
+// already has one there, and the old slug redirects to keepID. dupID is then
+// deleted. Stock is not moved; it is adjusted on keepID if needed.
+func (s Store) MergeItem(ctx context.Context, dupID, keepID int) error {
+	stmts := []string{
+		`UPDATE favorite f SET item_id = $2, variant_id = NULL
+        WHERE item_id = $1 AND NOT EXISTS (SELECT 1 FROM favorite WHERE owner IS NOT DISTINCT FROM f.owner AND item_id = $2)`,
+		`DELETE FROM favorite WHERE item_id = $1`,
+		`UPDATE price_alerts a SET item_id = $2
+        WHERE item_id = $1 AND NOT EXISTS (SELECT 1 FROM price_alerts WHERE owner = a.owner AND item_id = $2)`,
+		`DELETE FROM price_alerts WHERE item_id = $1`,
+		`UPDATE price_drops SET item_id = $2 WHERE item_id = $1`,
+		`UPDATE restock_alerts a SET item_id = $2
+        WHERE item_id = $1 AND variant_id IS NULL AND NOT EXISTS (SELECT 1 FROM restock_alerts WHERE owner = a.owner AND item_id = $2 AND variant_id IS NULL)`,
+		`DELETE FROM restock_alerts WHERE item_id = $1`,
+		`UPDATE recently_viewed v SET item_id = $2
+        WHERE item_id = $1 AND NOT EXISTS (SELECT 1 FROM recently_viewed WHERE owner = v.owner AND item_id = $2)`,
+		`DELETE FROM recently_viewed WHERE item_id = $1`,
+		`UPDATE releases SET item_id = $2 WHERE item_id = $1`,
+		`UPDATE item_slug_history SET item_id = $2 WHERE item_id = $1`,
+		`INSERT INTO item_slug_history (slug, item_id) SELECT slug, $2 FROM sneakers WHERE id = $1 AND slug IS NOT NULL
+        ON CONFLICT (slug) DO UPDATE SET item_id = EXCLUDED.item_id`,
+		`UPDATE sneakers SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL`,
+	}
+	for _, stmt := range stmts {
+		if _, err := s.q.ExecContext(ctx, stmt, dupID, keepID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// duplicateStatuses are the states of a possible duplicate: open until an
+// admin merges the sneakers or dismisses the match.
+var duplicateStatuses = map[string]bool{"open": true, "dismissed": true, "merged": true}
+
+// getDuplicates lists possible duplicates for review, newest first, with
+// ?status=open (the default), dismissed or merged.
+func getDuplicates(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := r.URL.Query().Get("status")
+		if status == "" {
+			status = "open"
+		}
+		if !duplicateStatuses[status] {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidDuplicateStatus)
+			return
+		}
+		limit, ok := queryLimit(r, 50, 200)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT d.id, d.reason, d.status, d.created_at,
+               n.id, n.title, coalesce(n.sku, ''), n.brand_id, n.deleted_at IS NOT NULL,
+               o.id, o.title, coalesce(o.sku, ''), o.brand_id, o.deleted_at IS NOT NULL
+        FROM item_duplicates d
+        INNER JOIN sneakers n ON n.id = d.item_id
+        INNER JOIN sneakers o ON o.id = d.duplicate_of
+        WHERE d.status = $1
+        ORDER BY d.created_at DESC, d.id DESC
+        LIMIT $2`, status, limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type summary struct {
+			ID      int    `json:"id"`
+			Title   string `json:"title"`
+			SKU     string `json:"sku"`
+			BrandID *int   `json:"brand_id"`
+			Deleted bool   `json:"deleted"`
+		}
+		type duplicate struct {
+			ID          int       `json:"id"`
+			Reason      string    `json:"reason"`
+			Status      string    `json:"status"`
+			CreatedAt   time.Time `json:"created_at"`
+			Item        summary   `json:"item"`
+			DuplicateOf summary   `json:"duplicate_of"`
+		}
+		duplicates := []duplicate{}
+		for rows.Next() {
+			var d duplicate
+			if err := rows.Scan(&d.ID, &d.Reason, &d.Status, &d.CreatedAt,
+				&d.Item.ID, &d.Item.Title, &d.Item.SKU, &d.Item.BrandID, &d.Item.Deleted,
+				&d.DuplicateOf.ID, &d.DuplicateOf.Title, &d.DuplicateOf.SKU, &d.DuplicateOf.BrandID, &d.DuplicateOf.Deleted); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			duplicates = append(duplicates, d)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(duplicates)
+	}
+}
+
+// resolveDuplicate closes an open possible duplicate. With merge, the newer
+// sneaker is merged into the one it duplicates; otherwise the match is
+// dismissed and both are kept.
+func resolveDuplicate(db *sql.DB, merge bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		duplicateID, err := strconv.Atoi(mux.Vars(r)["duplicateId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidDuplicateID)
+			return
+		}
+		status := "dismissed"
+		if merge {
+			status = "merged"
+		}
+
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			var itemID, duplicateOf int
+			var current string
+			err := tx.q.QueryRowContext(r.Context(), "SELECT item_id, duplicate_of, status FROM item_duplicates WHERE id = $1 FOR UPDATE", duplicateID).
+				Scan(&itemID, &duplicateOf, &current)
+			if errors.Is(err, sql.ErrNoRows) {
+				return &apiError{http.StatusNotFound, errCodeDuplicateNotFound}
+			}
+			if err != nil {
+				return err
+			}
+			if current != "open" {
+				return &apiError{http.StatusConflict, errCodeDuplicateResolved}
+			}
+
+			if merge {
+				if err := tx.MergeItem(r.Context(), itemID, duplicateOf); err != nil {
+					return err
+				}
+			}
+			_, err = tx.q.ExecContext(r.Context(), "UPDATE item_duplicates SET status = $2, resolved_at = now() WHERE id = $1", duplicateID, status)
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Error codes returned to clients. The human readable message for each code is
+// looked up in the message catalog matching the caller's Accept-Language.
+const (
+	errCodeInternal                = "internal_error"
+	errCodeNotFound                = "not_found"
+	errCodeMethodNotAllowed        = "method_not_allowed"
+	errCodeInvalidBody             = "invalid_body"
+	errCodeInvalidFavoriteID       = "invalid_favorite_id"
+	errCodeInvalidItemID           = "invalid_item_id"
+	errCodeItemNotFound            = "item_not_found"
+	errCodeInvalidAttributeFilter  = "invalid_attribute_filter"
+	errCodeUnauthorized            = "unauthorized"
+	errCodeInvalidTagName          = "invalid_tag_name"
+	errCodeInvalidTagID            = "invalid_tag_id"
+	errCodeTagNotFound             = "tag_not_found"
+	errCodeTagExists               = "tag_exists"
+	errCodeInvalidLimit            = "invalid_limit"
+	errCodeInvalidOffset           = "invalid_offset"
+	errCodeFavoriteNotFound        = "favorite_not_found"
+	errCodeInvalidFavoriteOrder    = "invalid_favorite_order"
+	errCodeUnsupportedExportFormat = "unsupported_export_format"
+	errCodeInvalidDate             = "invalid_date"
+	errCodeInvalidEventBatch       = "invalid_event_batch"
+	errCodeInvalidSearchID         = "invalid_search_id"
+	errCodeSearchNotFound          = "search_not_found"
+	errCodeInvalidDays             = "invalid_days"
+	errCodeInvalidSynonym          = "invalid_synonym"
+	errCodeSynonymNotFound         = "synonym_not_found"
+	errCodeIdentityRequired        = "identity_required"
+	errCodeInvalidSearchHistoryID  = "invalid_search_history_id"
+	errCodeInvalidTargetPrice      = "invalid_target_price"
+	errCodeInvalidReleaseID        = "invalid_release_id"
+	errCodeReleaseNotFound         = "release_not_found"
+	errCodeInvalidMonth            = "invalid_month"
+	errCodeInvalidRelease          = "invalid_release"
+	errCodeItemTitleRequired       = "item_title_required"
+	errCodeInvalidPrice            = "invalid_price"
+	errCodeItemImageRequired       = "item_image_required"
+	errCodeInvalidReleaseYear      = "invalid_release_year"
+	errCodeInvalidWeight           = "invalid_weight"
+	errCodeInvalidAttributes       = "invalid_attributes"
+	errCodeInvalidStock            = "invalid_stock"
+	errCodeInvalidVariantID        = "invalid_variant_id"
+	errCodeVariantNotFound         = "variant_not_found"
+	errCodeInvalidVariant          = "invalid_variant"
+	errCodeVariantExists           = "variant_exists"
+	errCodeInvalidBrandName        = "invalid_brand_name"
+	errCodeInvalidBrandID          = "invalid_brand_id"
+	errCodeBrandNotFound           = "brand_not_found"
+	errCodeBrandExists             = "brand_exists"
+	errCodeInvalidCategoryName     = "invalid_category_name"
+	errCodeInvalidCategoryID       = "invalid_category_id"
+	errCodeCategoryNotFound        = "category_not_found"
+	errCodeCategoryExists          = "category_exists"
+	errCodeInvalidCategoryParent   = "invalid_category_parent"
+	errCodeCategoryHasChildren     = "category_has_children"
+	errCodeInvalidConfig           = "invalid_config"
+	errCodeInvalidImageID          = "invalid_image_id"
+	errCodeImageNotFound           = "image_not_found"
+	errCodeInvalidImagePosition    = "invalid_image_position"
+	errCodeLastImage               = "last_image"
+	errCodeImageTooLarge           = "image_too_large"
+	errCodeUnsupportedImageType    = "unsupported_image_type"
+	errCodeClientCertRequired      = "client_cert_required"
+	errCodeInvalidBarcode          = "invalid_barcode"
+	errCodeBarcodeExists           = "barcode_exists"
+	errCodeSKUExists               = "sku_exists"
+	errCodeInvalidLookup           = "invalid_lookup"
+	errCodeSlugExists              = "slug_exists"
+	errCodeInvalidConsentPurpose   = "invalid_consent_purpose"
+	errCodeInvalidConsent          = "invalid_consent"
+	errCodeInvalidCart             = "invalid_cart"
+	errCodeInvalidShippingOption   = "invalid_shipping_option"
+	errCodeInvalidDiscountCode     = "invalid_discount_code"
+	errCodeInvalidDiscount         = "invalid_discount"
+	errCodeDiscountNotFound        = "discount_not_found"
+	errCodeOutOfStock              = "out_of_stock"
+	errCodeSignInRequired          = "sign_in_required"
+	errCodeInvalidCredit           = "invalid_credit"
+	errCodeInvalidMembership       = "invalid_membership"
+	errCodeInvalidItemStatus       = "invalid_item_status"
+	errCodeInvalidMemberAccess     = "invalid_member_access"
+	errCodeItemNotReleased         = "item_not_released"
+	errCodeInvalidModelID          = "invalid_model_id"
+	errCodeModelNotFound           = "model_not_found"
+	errCodeModelExists             = "model_exists"
+	errCodeInvalidModel            = "invalid_model"
+	errCodeMarketPriceNotFound     = "market_price_not_found"
+	errCodeInvalidShippingZoneID   = "invalid_shipping_zone_id"
+	errCodeShippingZoneNotFound    = "shipping_zone_not_found"
+	errCodeInvalidShippingZone     = "invalid_shipping_zone"
+	errCodeShippingZoneConflict    = "shipping_zone_conflict"
+	errCodeShippingUnavailable     = "shipping_unavailable"
+	errCodeInvalidImportFile       = "invalid_import_file"
+	errCodeImportTooLarge          = "import_too_large"
+	errCodeSKURequired             = "sku_required"
+	errCodeInvalidItemBatch        = "invalid_item_batch"
+	errCodePhoneNotFound           = "phone_not_found"
+	errCodeInvalidPhone            = "invalid_phone"
+	errCodePhoneCodeThrottled      = "phone_code_throttled"
+	errCodePhoneDailyLimit         = "phone_daily_limit"
+	errCodeInvalidPhoneCode        = "invalid_phone_code"
+	errCodeSMSUnavailable          = "sms_unavailable"
+	errCodeInvalidAltText          = "invalid_alt_text"
+	errCodeInvalidDuplicateID      = "invalid_duplicate_id"
+	errCodeDuplicateNotFound       = "duplicate_not_found"
+	errCodeDuplicateResolved       = "duplicate_resolved"
+	errCodeInvalidDuplicateStatus  = "invalid_duplicate_status"
+	errCodeLikelyDuplicate         = "likely_duplicate"
+	errCodeInvalidFavoriteBatch    = "invalid_favorite_batch"
+	errCodeInvalidCursor           = "invalid_cursor"
+	errCodeInvalidWishlistID       = "invalid_wishlist_id"
+	errCodeWishlistNotFound        = "wishlist_not_found"
+	errCodeInvalidWishlistName     = "invalid_wishlist_name"
+	errCodeWishlistNameTaken       = "wishlist_name_taken"
+	errCodeInvalidMarkets          = "invalid_markets"
+	errCodeSizeRequired            = "size_required"
+	errCodeInvalidSort             = "invalid_sort"
+	errCodeInvalidRestockAlertID   = "invalid_restock_alert_id"
+)
+
+const defaultLanguage = "en"
+
+// messageCatalogs maps a language to the messages for every error code.
+// Every catalog must contain all codes; English is used as the fallback.
+var messageCatalogs = map[string]map[string]string{
+	"en": {
+		errCodeInternal:                "Something went wrong on our side. Please try again later.",
+		errCodeNotFound:                "The requested resource was not found.",
+		errCodeMethodNotAllowed:        "This method is not allowed for the requested resource.",
+		errCodeInvalidBody:             "The request body is not valid JSON.",
+		errCodeInvalidFavoriteID:       "Invalid favorite ID.",
+		errCodeInvalidItemID:           "Invalid item ID.",
+		errCodeItemNotFound:            "The requested sneaker does not exist.",
+		errCodeInvalidAttributeFilter:  "Attribute filters must have the form key:value.",
+		errCodeUnauthorized:            "Authentication is required to access this resource.",
+		errCodeInvalidTagName:          "Tag names must contain at least one letter or digit.",
+		errCodeInvalidTagID:            "Invalid tag ID.",
+		errCodeTagNotFound:             "The requested tag does not exist.",
+		errCodeTagExists:               "A tag with this name already exists.",
+		errCodeInvalidLimit:            "The limit must be a positive number.",
+		errCodeInvalidOffset:           "The offset must be zero or a positive number.",
+		errCodeFavoriteNotFound:        "The requested favorite does not exist.",
+		errCodeInvalidFavoriteOrder:    "Provide either a list of favorite IDs without duplicates or a favorite ID with a position of at least 1.",
+		errCodeUnsupportedExportFormat: "The file is not a supported favorites export.",
+		errCodeInvalidDate:             "Dates must use the YYYY-MM-DD format.",
+		errCodeInvalidEventBatch:       "Send between 1 and 100 events per batch.",
+		errCodeInvalidSearchID:         "Invalid search ID.",
+		errCodeSearchNotFound:          "The search or sneaker does not exist.",
+		errCodeInvalidDays:             "The number of days must be between 1 and 365.",
+		errCodeInvalidSynonym:          "Both the term and its replacement must contain at least one letter or digit.",
+		errCodeSynonymNotFound:         "The synonym or stop word does not exist.",
+		errCodeIdentityRequired:        "Send an X-User-ID or X-Device-ID header to identify yourself.",
+		errCodeInvalidSearchHistoryID:  "Invalid search history entry ID.",
+		errCodeInvalidTargetPrice:      "The target price must be a positive number.",
+		errCodeInvalidReleaseID:        "Invalid release ID.",
+		errCodeReleaseNotFound:         "The requested release does not exist.",
+		errCodeInvalidMonth:            "Months must use the YYYY-MM format.",
+		errCodeInvalidRelease:          "A release needs a title and a release date.",
+		errCodeItemTitleRequired:       "The title is required.",
+		errCodeInvalidPrice:            "The price must be zero or a positive number.",
+		errCodeItemImageRequired:       "The image URL is required.",
+		errCodeInvalidReleaseYear:      "The release year must be between 1900 and 2100.",
+		errCodeInvalidWeight:           "The weight must be a positive number of grams.",
+		errCodeInvalidAttributes:       "Attributes must be a JSON object.",
+		errCodeInvalidStock:            "Stock must be zero or a positive number.",
+		errCodeInvalidVariantID:        "Invalid variant ID.",
+		errCodeVariantNotFound:         "The requested size does not exist for this sneaker.",
+		errCodeInvalidVariant:          "A size needs a US size, an EU size and a SKU.",
+		errCodeVariantExists:           "This size or SKU already exists.",
+		errCodeInvalidBrandName:        "Brand names must contain at least one letter or digit.",
+		errCodeInvalidBrandID:          "Invalid brand ID.",
+		errCodeBrandNotFound:           "The requested brand does not exist.",
+		errCodeBrandExists:             "A brand with this name already exists.",
+		errCodeInvalidCategoryName:     "Category names must contain at least one letter or digit.",
+		errCodeInvalidCategoryID:       "Invalid category ID.",
+		errCodeCategoryNotFound:        "The requested category does not exist.",
+		errCodeCategoryExists:          "A category with this name already exists.",
+		errCodeInvalidCategoryParent:   "The parent category does not exist or is the category itself or one of its subcategories.",
+		errCodeCategoryHasChildren:     "Move or delete the subcategories before deleting this category.",
+		errCodeInvalidConfig:           "The configuration is invalid: check the log level, cache TTLs and event sample rate.",
+		errCodeInvalidImageID:          "Invalid image ID.",
+		errCodeImageNotFound:           "The requested image does not exist for this sneaker.",
+		errCodeInvalidImagePosition:    "The image position must be zero or a positive number.",
+		errCodeLastImage:               "A sneaker needs at least one image; add another before removing this one.",
+		errCodeImageTooLarge:           "Images must not be larger than 10 MB.",
+		errCodeUnsupportedImageType:    "Only JPEG, PNG and WebP images are accepted.",
+		errCodeClientCertRequired:      "Admin access requires a client certificate.",
+		errCodeInvalidBarcode:          "The barcode must be a valid EAN-8, UPC-A, EAN-13 or GTIN-14 code.",
+		errCodeBarcodeExists:           "This barcode is already assigned to another product.",
+		errCodeSKUExists:               "This SKU is already assigned to another product.",
+		errCodeInvalidLookup:           "Provide a barcode or a SKU to look up.",
+		errCodeSlugExists:              "Another sneaker took this URL slug at the same time, please try again.",
+		errCodeInvalidConsentPurpose:   "Unknown consent purpose; use marketing or tracking.",
+		errCodeInvalidConsent:          "Send granted as true or false and a source of at most 64 characters.",
+		errCodeInvalidCart:             "Send between 1 and 50 lines, each with an item_id and a quantity from 1 to 10.",
+		errCodeInvalidShippingOption:   "This shipping option is not available for the order.",
+		errCodeInvalidDiscountCode:     "This discount code does not exist or is no longer valid.",
+		errCodeInvalidDiscount:         "A discount needs a code of 3 to 32 letters, digits, dashes or underscores, and either a percentage in basis points or a positive amount in the shop currency, ending after it starts.",
+		errCodeDiscountNotFound:        "The requested discount code does not exist.",
+		errCodeOutOfStock:              "Not enough stock is left for one of the sneakers.",
+		errCodeSignInRequired:          "Sign in to your account to use this.",
+		errCodeInvalidCredit:           "Send a positive amount in the shop currency and a reason of goodwill or refund.",
+		errCodeInvalidMembership:       "Send a status of active, past_due or canceled and the end of the current billing period.",
+		errCodeInvalidItemStatus:       "The status must be draft, published or archived.",
+		errCodeInvalidMemberAccess:     "member_access_at must be before publish_at.",
+		errCodeItemNotReleased:         "This sneaker is not on sale yet.",
+		errCodeInvalidModelID:          "The model ID must be a number.",
+		errCodeModelNotFound:           "The requested sneaker model does not exist.",
+		errCodeModelExists:             "A sneaker model with this style code already exists.",
+		errCodeInvalidModel:            "A model needs a silhouette, and its release date must be YYYY-MM-DD.",
+		errCodeMarketPriceNotFound:     "There is no market price for this sneaker model yet.",
+		errCodeInvalidShippingZoneID:   "The shipping zone ID must be a number.",
+		errCodeShippingZoneNotFound:    "The requested shipping zone does not exist.",
+		errCodeInvalidShippingZone:     "A shipping zone needs a name, at least one two-letter country code and non-negative fees in the shop currency.",
+		errCodeShippingZoneConflict:    "Another shipping zone already has this name or one of these countries.",
+		errCodeShippingUnavailable:     "We do not ship to this country.",
+		errCodeInvalidImportFile:       "The file is not a catalog CSV or XLSX file with a sku column and known columns.",
+		errCodeImportTooLarge:          "The file is too large to import at once; split it into smaller files.",
+		errCodeSKURequired:             "A SKU is required.",
+		errCodeInvalidItemBatch:        "The batch must contain between 1 and 500 updates.",
+		errCodePhoneNotFound:           "No phone number is registered.",
+		errCodeInvalidPhone:            "The phone number must be an international number, e.g. +33612345678.",
+		errCodePhoneCodeThrottled:      "A code was sent recently; wait a minute before requesting another.",
+		errCodePhoneDailyLimit:         "Too many verification codes or attempts today; try again tomorrow.",
+		errCodeInvalidPhoneCode:        "The code is wrong or has expired.",
+		errCodeSMSUnavailable:          "Text messages are not available.",
+		errCodeInvalidAltText:          "Images need an alt text of at most 250 characters describing them.",
+		errCodeInvalidDuplicateID:      "The duplicate ID must be a number.",
+		errCodeDuplicateNotFound:       "The duplicate was not found.",
+		errCodeDuplicateResolved:       "The duplicate has already been merged or dismissed.",
+		errCodeInvalidDuplicateStatus:  "The status must be open, dismissed or merged.",
+		errCodeLikelyDuplicate:         "The sneaker looks like one already in the catalog; repeat with ?force=true to create it anyway.",
+		errCodeInvalidFavoriteBatch:    "The batch must list between 1 and 500 sneaker IDs.",
+		errCodeInvalidCursor:           "The since cursor is invalid; use the cursor of a previous response.",
+		errCodeInvalidWishlistID:       "Invalid wishlist ID.",
+		errCodeWishlistNotFound:        "The requested wishlist does not exist.",
+		errCodeInvalidWishlistName:     "The wishlist name must be between 1 and 60 characters.",
+		errCodeWishlistNameTaken:       "You already have a wishlist with this name.",
+		errCodeInvalidMarkets:          "Markets must be ISO 3166-1 alpha-2 country codes, e.g. \"US\".",
+		errCodeSizeRequired:            "Pick a size for this sneaker before adding it to the cart.",
+		errCodeInvalidSort:             "sortBy must be one of title, -title, price, -price, newest or popularity.",
+		errCodeInvalidRestockAlertID:   "Invalid restock alert ID.",
+	},
+	"fr": {
+		errCodeInternal:                "Une erreur s'est produite de notre côté. Veuillez réessayer plus tard.",
+		errCodeNotFound:                "La ressource demandée est introuvable.",
+		errCodeMethodNotAllowed:        "Cette méthode n'est pas autorisée pour la ressource demandée.",
+		errCodeInvalidBody:             "Le corps de la requête n'est pas un JSON valide.",
+		errCodeInvalidFavoriteID:       "Identifiant de favori invalide.",
+		errCodeInvalidItemID:           "Identifiant d'article invalide.",
+		errCodeItemNotFound:            "La sneaker demandée n'existe pas.",
+		errCodeInvalidAttributeFilter:  "Les filtres d'attribut doivent avoir la forme clé:valeur.",
+		errCodeUnauthorized:            "Une authentification est requise pour accéder à cette ressource.",
+		errCodeInvalidTagName:          "Les noms d'étiquette doivent contenir au moins une lettre ou un chiffre.",
+		errCodeInvalidTagID:            "Identifiant d'étiquette invalide.",
+		errCodeTagNotFound:             "L'étiquette demandée n'existe pas.",
+		errCodeTagExists:               "Une étiquette portant ce nom existe déjà.",
+		errCodeInvalidLimit:            "La limite doit être un nombre positif.",
+		errCodeInvalidOffset:           "Le décalage doit être zéro ou un nombre positif.",
+		errCodeFavoriteNotFound:        "Le favori demandé n'existe pas.",
+		errCodeInvalidFavoriteOrder:    "Fournissez soit une liste d'identifiants de favoris sans doublons, soit un identifiant de favori avec une position d'au moins 1.",
+		errCodeUnsupportedExportFormat: "Le fichier n'est pas un export de favoris pris en charge.",
+		errCodeInvalidDate:             "Les dates doivent utiliser le format AAAA-MM-JJ.",
+		errCodeInvalidEventBatch:       "Envoyez entre 1 et 100 événements par lot.",
+		errCodeInvalidSearchID:         "Identifiant de recherche invalide.",
+		errCodeSearchNotFound:          "La recherche ou la sneaker n'existe pas.",
+		errCodeInvalidDays:             "Le nombre de jours doit être compris entre 1 et 365.",
+		errCodeInvalidSynonym:          "Le terme et son remplacement doivent contenir au moins une lettre ou un chiffre.",
+		errCodeSynonymNotFound:         "Le synonyme ou le mot vide n'existe pas.",
+		errCodeIdentityRequired:        "Envoyez un en-tête X-User-ID ou X-Device-ID pour vous identifier.",
+		errCodeInvalidSearchHistoryID:  "Identifiant d'entrée d'historique de recherche invalide.",
+		errCodeInvalidTargetPrice:      "Le prix cible doit être un nombre positif.",
+		errCodeInvalidReleaseID:        "Identifiant de sortie invalide.",
+		errCodeReleaseNotFound:         "La sortie demandée n'existe pas.",
+		errCodeInvalidMonth:            "Les mois doivent utiliser le format AAAA-MM.",
+		errCodeInvalidRelease:          "Une sortie nécessite un titre et une date de sortie.",
+		errCodeItemTitleRequired:       "Le titre est obligatoire.",
+		errCodeInvalidPrice:            "Le prix doit être nul ou positif.",
+		errCodeItemImageRequired:       "L'URL de l'image est obligatoire.",
+		errCodeInvalidReleaseYear:      "L'année de sortie doit être comprise entre 1900 et 2100.",
+		errCodeInvalidWeight:           "Le poids doit être un nombre positif de grammes.",
+		errCodeInvalidAttributes:       "Les attributs doivent être un objet JSON.",
+		errCodeInvalidStock:            "Le stock doit être nul ou positif.",
+		errCodeInvalidVariantID:        "Identifiant de taille invalide.",
+		errCodeVariantNotFound:         "La taille demandée n'existe pas pour cette sneaker.",
+		errCodeInvalidVariant:          "Une taille nécessite une pointure US, une pointure EU et un SKU.",
+		errCodeVariantExists:           "Cette taille ou ce SKU existe déjà.",
+		errCodeInvalidBrandName:        "Le nom d'une marque doit contenir au moins une lettre ou un chiffre.",
+		errCodeInvalidBrandID:          "Identifiant de marque invalide.",
+		errCodeBrandNotFound:           "La marque demandée n'existe pas.",
+		errCodeBrandExists:             "Une marque portant ce nom existe déjà.",
+		errCodeInvalidCategoryName:     "Le nom d'une catégorie doit contenir au moins une lettre ou un chiffre.",
+		errCodeInvalidCategoryID:       "Identifiant de catégorie invalide.",
+		errCodeCategoryNotFound:        "La catégorie demandée n'existe pas.",
+		errCodeCategoryExists:          "Une catégorie portant ce nom existe déjà.",
+		errCodeInvalidCategoryParent:   "La catégorie parente n'existe pas ou est la catégorie elle-même ou l'une de ses sous-catégories.",
+		errCodeCategoryHasChildren:     "Déplacez ou supprimez les sous-catégories avant de supprimer cette catégorie.",
+		errCodeInvalidConfig:           "La configuration est invalide : vérifiez le niveau de journalisation, les durées de cache et le taux d'échantillonnage des événements.",
+		errCodeInvalidImageID:          "Identifiant d'image invalide.",
+		errCodeImageNotFound:           "L'image demandée n'existe pas pour cette sneaker.",
+		errCodeInvalidImagePosition:    "La position de l'image doit être nulle ou positive.",
+		errCodeLastImage:               "Une sneaker doit avoir au moins une image ; ajoutez-en une autre avant de supprimer celle-ci.",
+		errCodeImageTooLarge:           "Les images ne doivent pas dépasser 10 Mo.",
+		errCodeUnsupportedImageType:    "Seules les images JPEG, PNG et WebP sont acceptées.",
+		errCodeClientCertRequired:      "L'accès administrateur nécessite un certificat client.",
+		errCodeInvalidBarcode:          "Le code-barres doit être un code EAN-8, UPC-A, EAN-13 ou GTIN-14 valide.",
+		errCodeBarcodeExists:           "Ce code-barres est déjà attribué à un autre produit.",
+		errCodeSKUExists:               "Ce SKU est déjà attribué à un autre produit.",
+		errCodeInvalidLookup:           "Indiquez un code-barres ou un SKU à rechercher.",
+		errCodeSlugExists:              "Une autre sneaker a pris ce slug d'URL au même moment, veuillez réessayer.",
+		errCodeInvalidConsentPurpose:   "Finalité de consentement inconnue ; utilisez marketing ou tracking.",
+		errCodeInvalidConsent:          "Envoyez granted à true ou false et une source de 64 caractères au plus.",
+		errCodeInvalidCart:             "Envoyez entre 1 et 50 lignes, chacune avec un item_id et une quantité de 1 à 10.",
+		errCodeInvalidShippingOption:   "Cette option de livraison n'est pas disponible pour la commande.",
+		errCodeInvalidDiscountCode:     "Ce code de réduction n'existe pas ou n'est plus valable.",
+		errCodeInvalidDiscount:         "Une réduction doit avoir un code de 3 à 32 lettres, chiffres, tirets ou tirets bas, et soit un pourcentage en points de base, soit un montant positif dans la devise de la boutique, se terminant après son début.",
+		errCodeDiscountNotFound:        "Le code de réduction demandé n'existe pas.",
+		errCodeOutOfStock:              "Il ne reste pas assez de stock pour l'une des sneakers.",
+		errCodeSignInRequired:          "Connectez-vous à votre compte pour utiliser cette fonction.",
+		errCodeInvalidCredit:           "Envoyez un montant positif dans la devise de la boutique et un motif goodwill ou refund.",
+		errCodeInvalidMembership:       "Envoyez un statut active, past_due ou canceled et la fin de la période de facturation en cours.",
+		errCodeInvalidItemStatus:       "Le statut doit être draft, published ou archived.",
+		errCodeInvalidMemberAccess:     "member_access_at doit précéder publish_at.",
+		errCodeItemNotReleased:         "Cette sneaker n'est pas encore en vente.",
+		errCodeInvalidModelID:          "L’identifiant du modèle doit être un nombre.",
+		errCodeModelNotFound:           "Le modèle de sneaker demandé n'existe pas.",
+		errCodeModelExists:             "Un modèle de sneaker avec ce code de style existe déjà.",
+		errCodeInvalidModel:            "Un modèle doit avoir une silhouette et sa date de sortie doit être au format AAAA-MM-JJ.",
+		errCodeMarketPriceNotFound:     "Il n'y a pas encore de prix de marché pour ce modèle de sneaker.",
+		errCodeInvalidShippingZoneID:   "L’identifiant de la zone de livraison doit être un nombre.",
+		errCodeShippingZoneNotFound:    "La zone de livraison demandée n'existe pas.",
+		errCodeInvalidShippingZone:     "Une zone de livraison doit avoir un nom, au moins un code pays à deux lettres et des frais positifs ou nuls dans la devise de la boutique.",
+		errCodeShippingZoneConflict:    "Une autre zone de livraison porte déjà ce nom ou contient déjà un de ces pays.",
+		errCodeShippingUnavailable:     "Nous ne livrons pas dans ce pays.",
+		errCodeInvalidImportFile:       "Le fichier n'est pas un fichier CSV ou XLSX de catalogue avec une colonne sku et des colonnes connues.",
+		errCodeImportTooLarge:          "Le fichier est trop volumineux pour être importé en une fois ; divisez-le en fichiers plus petits.",
+		errCodeSKURequired:             "Un SKU est obligatoire.",
+		errCodeInvalidItemBatch:        "Le lot doit contenir entre 1 et 500 modifications.",
+		errCodePhoneNotFound:           "Aucun numéro de téléphone n'est enregistré.",
+		errCodeInvalidPhone:            "Le numéro de téléphone doit être un numéro international, par ex. +33612345678.",
+		errCodePhoneCodeThrottled:      "Un code a été envoyé récemment ; attendez une minute avant d'en demander un autre.",
+		errCodePhoneDailyLimit:         "Trop de codes ou de tentatives de vérification aujourd'hui ; réessayez demain.",
+		errCodeInvalidPhoneCode:        "Le code est erroné ou a expiré.",
+		errCodeSMSUnavailable:          "Les SMS ne sont pas disponibles.",
+		errCodeInvalidAltText:          "Les images nécessitent un texte alternatif de 250 caractères au plus qui les décrit.",
+		errCodeInvalidDuplicateID:      "L'identifiant du doublon doit être un nombre.",
+		errCodeDuplicateNotFound:       "Le doublon est introuvable.",
+		errCodeDuplicateResolved:       "Le doublon a déjà été fusionné ou écarté.",
+		errCodeInvalidDuplicateStatus:  "Le statut doit être open, dismissed ou merged.",
+		errCodeLikelyDuplicate:         "La sneaker ressemble à une autre déjà au catalogue ; réessayez avec ?force=true pour la créer quand même.",
+		errCodeInvalidFavoriteBatch:    "Le lot doit lister entre 1 et 500 identifiants de sneakers.",
+		errCodeInvalidCursor:           "Le curseur since est invalide ; utilisez le curseur d'une réponse précédente.",
+		errCodeInvalidWishlistID:       "Identifiant de liste d'envies invalide.",
+		errCodeWishlistNotFound:        "La liste d'envies demandée n'existe pas.",
+		errCodeInvalidWishlistName:     "Le nom de la liste d'envies doit comporter entre 1 et 60 caractères.",
+		errCodeWishlistNameTaken:       "Vous avez déjà une liste d'envies portant ce nom.",
+		errCodeInvalidMarkets:          "Les marchés doivent être des codes pays ISO 3166-1 alpha-2, par exemple \"US\".",
+		errCodeSizeRequired:            "Choisissez une taille pour cette sneaker avant de l'ajouter au panier.",
+		errCodeInvalidSort:             "sortBy doit valoir title, -title, price, -price, newest ou popularity.",
+		errCodeInvalidRestockAlertID:   "ID d'alerte de réassort invalide.",
+	},
+	"de": {
+		errCodeInternal:                "Bei uns ist ein Fehler aufgetreten. Bitte versuchen Sie es später erneut.",
+		errCodeNotFound:                "Die angeforderte Ressource wurde nicht gefunden.",
+		errCodeMethodNotAllowed:        "Diese Methode ist für die angeforderte Ressource nicht erlaubt.",
+		errCodeInvalidBody:             "Der Anfragetext ist kein gültiges JSON.",
+		errCodeInvalidFavoriteID:       "Ungültige Favoriten-ID.",
+		errCodeInvalidItemID:           "Ungültige Artikel-ID.",
+		errCodeItemNotFound:            "Der angeforderte Sneaker existiert nicht.",
+		errCodeInvalidAttributeFilter:  "Attributfilter müssen die Form Schlüssel:Wert haben.",
+		errCodeUnauthorized:            "Für den Zugriff auf diese Ressource ist eine Anmeldung erforderlich.",
+		errCodeInvalidTagName:          "Tag-Namen müssen mindestens einen Buchstaben oder eine Ziffer enthalten.",
+		errCodeInvalidTagID:            "Ungültige Tag-ID.",
+		errCodeTagNotFound:             "Der angeforderte Tag existiert nicht.",
+		errCodeTagExists:               "Ein Tag mit diesem Namen existiert bereits.",
+		errCodeInvalidLimit:            "Das Limit muss eine positive Zahl sein.",
+		errCodeInvalidOffset:           "Der Offset muss null oder eine positive Zahl sein.",
+		errCodeFavoriteNotFound:        "Der angeforderte Favorit existiert nicht.",
+		errCodeInvalidFavoriteOrder:    "Geben Sie entweder eine Liste von Favoriten-IDs ohne Duplikate oder eine Favoriten-ID mit einer Position von mindestens 1 an.",
+		errCodeUnsupportedExportFormat: "Die Datei ist kein unterstützter Favoriten-Export.",
+		errCodeInvalidDate:             "Datumsangaben müssen das Format JJJJ-MM-TT verwenden.",
+		errCodeInvalidEventBatch:       "Senden Sie zwischen 1 und 100 Ereignisse pro Stapel.",
+		errCodeInvalidSearchID:         "Ungültige Such-ID.",
+		errCodeSearchNotFound:          "Die Suche oder der Sneaker existiert nicht.",
+		errCodeInvalidDays:             "Die Anzahl der Tage muss zwischen 1 und 365 liegen.",
+		errCodeInvalidSynonym:          "Sowohl der Begriff als auch sein Ersatz müssen mindestens einen Buchstaben oder eine Ziffer enthalten.",
+		errCodeSynonymNotFound:         "Das Synonym oder Stoppwort existiert nicht.",
+		errCodeIdentityRequired:        "Senden Sie einen X-User-ID- oder X-Device-ID-Header, um sich zu identifizieren.",
+		errCodeInvalidSearchHistoryID:  "Ungültige ID des Suchverlaufseintrags.",
+		errCodeInvalidTargetPrice:      "Der Zielpreis muss eine positive Zahl sein.",
+		errCodeInvalidReleaseID:        "Ungültige Release-ID.",
+		errCodeReleaseNotFound:         "Das angeforderte Release existiert nicht.",
+		errCodeInvalidMonth:            "Monate müssen das Format JJJJ-MM verwenden.",
+		errCodeInvalidRelease:          "Ein Release benötigt einen Titel und ein Veröffentlichungsdatum.",
+		errCodeItemTitleRequired:       "Der Titel ist erforderlich.",
+		errCodeInvalidPrice:            "Der Preis muss null oder eine positive Zahl sein.",
+		errCodeItemImageRequired:       "Die Bild-URL ist erforderlich.",
+		errCodeInvalidReleaseYear:      "Das Erscheinungsjahr muss zwischen 1900 und 2100 liegen.",
+		errCodeInvalidWeight:           "Das Gewicht muss eine positive Anzahl Gramm sein.",
+		errCodeInvalidAttributes:       "Attribute müssen ein JSON-Objekt sein.",
+		errCodeInvalidStock:            "Der Bestand muss null oder eine positive Zahl sein.",
+		errCodeInvalidVariantID:        "Ungültige Größen-ID.",
+		errCodeVariantNotFound:         "Die angeforderte Größe existiert für diesen Sneaker nicht.",
+		errCodeInvalidVariant:          "Eine Größe benötigt eine US-Größe, eine EU-Größe und eine SKU.",
+		errCodeVariantExists:           "Diese Größe oder SKU existiert bereits.",
+		errCodeInvalidBrandName:        "Markennamen müssen mindestens einen Buchstaben oder eine Ziffer enthalten.",
+		errCodeInvalidBrandID:          "Ungültige Marken-ID.",
+		errCodeBrandNotFound:           "Die angeforderte Marke existiert nicht.",
+		errCodeBrandExists:             "Eine Marke mit diesem Namen existiert bereits.",
+		errCodeInvalidCategoryName:     "Kategorienamen müssen mindestens einen Buchstaben oder eine Ziffer enthalten.",
+		errCodeInvalidCategoryID:       "Ungültige Kategorie-ID.",
+		errCodeCategoryNotFound:        "Die angeforderte Kategorie existiert nicht.",
+		errCodeCategoryExists:          "Eine Kategorie mit diesem Namen existiert bereits.",
+		errCodeInvalidCategoryParent:   "Die übergeordnete Kategorie existiert nicht oder ist die Kategorie selbst oder eine ihrer Unterkategorien.",
+		errCodeCategoryHasChildren:     "Verschieben oder löschen Sie die Unterkategorien, bevor Sie diese Kategorie löschen.",
+		errCodeInvalidConfig:           "Die Konfiguration ist ungültig: Prüfen Sie Log-Level, Cache-Laufzeiten und Ereignis-Stichprobenrate.",
+		errCodeInvalidImageID:          "Ungültige Bild-ID.",
+		errCodeImageNotFound:           "Das angeforderte Bild existiert für diesen Sneaker nicht.",
+		errCodeInvalidImagePosition:    "Die Bildposition muss null oder eine positive Zahl sein.",
+		errCodeLastImage:               "Ein Sneaker benötigt mindestens ein Bild; fügen Sie ein weiteres hinzu, bevor Sie dieses entfernen.",
+		errCodeImageTooLarge:           "Bilder dürfen nicht größer als 10 MB sein.",
+		errCodeUnsupportedImageType:    "Es werden nur JPEG-, PNG- und WebP-Bilder akzeptiert.",
+		errCodeClientCertRequired:      "Der Admin-Zugang erfordert ein Client-Zertifikat.",
+		errCodeInvalidBarcode:          "Der Barcode muss ein gültiger EAN-8-, UPC-A-, EAN-13- oder GTIN-14-Code sein.",
+		errCodeBarcodeExists:           "Dieser Barcode ist bereits einem anderen Produkt zugeordnet.",
+		errCodeSKUExists:               "Diese SKU ist bereits einem anderen Produkt zugeordnet.",
+		errCodeInvalidLookup:           "Geben Sie einen Barcode oder eine SKU für die Suche an.",
+		errCodeSlugExists:              "Ein anderer Sneaker hat diesen URL-Slug gleichzeitig belegt, bitte versuchen Sie es erneut.",
+		errCodeInvalidConsentPurpose:   "Unbekannter Einwilligungszweck; verwenden Sie marketing oder tracking.",
+		errCodeInvalidConsent:          "Senden Sie granted als true oder false und eine Quelle mit höchstens 64 Zeichen.",
+		errCodeInvalidCart:             "Senden Sie zwischen 1 und 50 Positionen, jeweils mit item_id und einer Menge von 1 bis 10.",
+		errCodeInvalidShippingOption:   "Diese Versandart ist für die Bestellung nicht verfügbar.",
+		errCodeInvalidDiscountCode:     "Dieser Rabattcode existiert nicht oder ist nicht mehr gültig.",
+		errCodeInvalidDiscount:         "Ein Rabatt braucht einen Code aus 3 bis 32 Buchstaben, Ziffern, Binde- oder Unterstrichen und entweder einen Prozentsatz in Basispunkten oder einen positiven Betrag in der Shopwährung, der nach seinem Beginn endet.",
+		errCodeDiscountNotFound:        "Der angeforderte Rabattcode existiert nicht.",
+		errCodeOutOfStock:              "Für einen der Sneaker ist nicht genügend Bestand vorhanden.",
+		errCodeSignInRequired:          "Melden Sie sich bei Ihrem Konto an, um diese Funktion zu nutzen.",
+		errCodeInvalidCredit:           "Senden Sie einen positiven Betrag in der Shopwährung und als Grund goodwill oder refund.",
+		errCodeInvalidMembership:       "Senden Sie einen Status active, past_due oder canceled und das Ende des aktuellen Abrechnungszeitraums.",
+		errCodeInvalidItemStatus:       "Der Status muss draft, published oder archived sein.",
+		errCodeInvalidMemberAccess:     "member_access_at muss vor publish_at liegen.",
+		errCodeItemNotReleased:         "Dieser Sneaker ist noch nicht im Verkauf.",
+		errCodeInvalidModelID:          "Die Modell-ID muss eine Zahl sein.",
+		errCodeModelNotFound:           "Das angeforderte Sneaker-Modell existiert nicht.",
+		errCodeModelExists:             "Ein Sneaker-Modell mit diesem Style-Code existiert bereits.",
+		errCodeInvalidModel:            "Ein Modell braucht eine Silhouette, und sein Erscheinungsdatum muss JJJJ-MM-TT sein.",
+		errCodeMarketPriceNotFound:     "Für dieses Sneaker-Modell gibt es noch keinen Marktpreis.",
+		errCodeInvalidShippingZoneID:   "Die Versandzonen-ID muss eine Zahl sein.",
+		errCodeShippingZoneNotFound:    "Die angeforderte Versandzone existiert nicht.",
+		errCodeInvalidShippingZone:     "Eine Versandzone braucht einen Namen, mindestens einen zweistelligen Ländercode und nicht negative Gebühren in der Shopwährung.",
+		errCodeShippingZoneConflict:    "Eine andere Versandzone hat bereits diesen Namen oder eines dieser Länder.",
+		errCodeShippingUnavailable:     "Wir liefern nicht in dieses Land.",
+		errCodeInvalidImportFile:       "Die Datei ist keine Katalogdatei im CSV- oder XLSX-Format mit einer Spalte sku und bekannten Spalten.",
+		errCodeImportTooLarge:          "Die Datei ist zu groß für einen einzelnen Import; teilen Sie sie in kleinere Dateien auf.",
+		errCodeSKURequired:             "Eine SKU ist erforderlich.",
+		errCodeInvalidItemBatch:        "Der Stapel muss zwischen 1 und 500 Änderungen enthalten.",
+		errCodePhoneNotFound:           "Es ist keine Telefonnummer hinterlegt.",
+		errCodeInvalidPhone:            "Die Telefonnummer muss eine internationale Nummer sein, z. B. +33612345678.",
+		errCodePhoneCodeThrottled:      "Es wurde kürzlich ein Code gesendet; warten Sie eine Minute, bevor Sie einen neuen anfordern.",
+		errCodePhoneDailyLimit:         "Heute wurden zu viele Bestätigungscodes angefordert oder Versuche unternommen; versuchen Sie es morgen erneut.",
+		errCodeInvalidPhoneCode:        "Der Code ist falsch oder abgelaufen.",
+		errCodeSMSUnavailable:          "SMS sind nicht verfügbar.",
+		errCodeInvalidAltText:          "Bilder benötigen einen Alternativtext von höchstens 250 Zeichen, der sie beschreibt.",
+		errCodeInvalidDuplicateID:      "Die Dubletten-ID muss eine Zahl sein.",
+		errCodeDuplicateNotFound:       "Die Dublette wurde nicht gefunden.",
+		errCodeDuplicateResolved:       "Die Dublette wurde bereits zusammengeführt oder verworfen.",
+		errCodeInvalidDuplicateStatus:  "Der Status muss open, dismissed oder merged sein.",
+		errCodeLikelyDuplicate:         "Der Sneaker ähnelt einem bereits im Katalog; wiederholen Sie die Anfrage mit ?force=true, um ihn trotzdem anzulegen.",
+		errCodeInvalidFavoriteBatch:    "Der Stapel muss zwischen 1 und 500 Sneaker-IDs enthalten.",
+		errCodeInvalidCursor:           "Der since-Cursor ist ungültig; verwenden Sie den Cursor einer vorherigen Antwort.",
+		errCodeInvalidWishlistID:       "Ungültige Wunschlisten-ID.",
+		errCodeWishlistNotFound:        "Die angeforderte Wunschliste existiert nicht.",
+		errCodeInvalidWishlistName:     "Der Name der Wunschliste muss zwischen 1 und 60 Zeichen lang sein.",
+		errCodeWishlistNameTaken:       "Sie haben bereits eine Wunschliste mit diesem Namen.",
+		errCodeInvalidMarkets:          "Märkte müssen Ländercodes nach ISO 3166-1 alpha-2 sein, z. B. \"US\".",
+		errCodeSizeRequired:            "Wählen Sie eine Größe für diesen Sneaker, bevor Sie ihn in den Warenkorb legen.",
+		errCodeInvalidSort:             "sortBy muss title, -title, price, -price, newest oder popularity sein.",
+		errCodeInvalidRestockAlertID:   "Ungültige ID des Wiederverfügbarkeitsalarms.",
+	},
+}
+
+// negotiateLanguage picks the best supported language from the Accept-Language
+// header, honouring q-values. Region subtags (fr-CH) fall back to the base language.
+func negotiateLanguage(r *http.Request) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		base, _, _ := strings.Cut(tag, "-")
+		candidates = append(candidates, candidate{lang: base, q: q})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	for _, c := range candidates {
+		if _, ok := messageCatalogs[c.lang]; ok && c.q > 0 {
+			return c.lang
+		}
+	}
+	return defaultLanguage
+}
+
+// localize returns the message for code in lang, falling back to English and
+// finally to the code itself.
+func localize(lang, code string) string {
+	if msg, ok := messageCatalogs[lang][code]; ok {
+		return msg
+	}
+	if msg, ok := messageCatalogs[defaultLanguage][code]; ok {
+		return msg
+	}
+	return code
+}
+
+// writeError writes a JSON error body with a stable code and a message localized
+// for the caller.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	lang := negotiateLanguage(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{code, localize(lang, code)})
+}
+
+// apiError carries a response status and error code out of code that cannot
+// write the response itself, such as the body of a transaction.
+type apiError struct {
+	status int
+	code   string
+}
+
+func (e *apiError) Error() string {
+	return e.code
+}
+
+// handleError writes the response for err: the status and code of an apiError,
+// or a 500 for anything else.
+func handleError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		writeError(w, r, apiErr.status, apiErr.code)
+		return
+	}
+	serverError(w, r, err)
+}
+
+// isForeignKeyViolation reports whether err is a Postgres foreign key violation,
+// which handlers map to a 404 for the referenced resource.
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// serverError logs err and responds with a generic localized 500, so database
+// details are not leaked to clients.
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	writeError(w, r, http.StatusInternalServerError, errCodeInternal)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// maxEventBatch is the largest number of events accepted per request.
+const maxEventBatch = 100
+
+type analyticsEvent struct {
+	Type        string          `json:"type"`
+	AnonymousID string          `json:"anonymous_id"`
+	ItemID      *int            `json:"item_id"`
+	Query       *string         `json:"query"`
+	OccurredAt  *time.Time      `json:"occurred_at"`
+	Properties  json.RawMessage `json:"properties"`
+}
+
+// validate checks an event against the schema of its type and returns the
+// reason it is rejected, or "" when it is valid.
+func (e analyticsEvent) validate() string {
+	if e.AnonymousID == "" {
+		return "missing_anonymous_id"
+	}
+	switch e.Type {
+	case "view", "add_to_cart":
+		if e.ItemID == nil || *e.ItemID <= 0 {
+			return "missing_item_id"
+		}
+	case "search":
+		if e.Query == nil || *e.Query == "" {
+			return "missing_query"
+		}
+	default:
+		return "unknown_type"
+	}
+	if len(e.Properties) > 0 && e.Properties[0] != '{' {
+		return "invalid_properties"
+	}
+	return ""
+}
+
+// anonymize replaces the client identifier with a one-way hash so stored events
+// cannot be joined back to the raw identifier the app generated.
+func anonymize(id string) string {
+	sum := sha256.Sum256([]byte("analytics:" + id))
+	return hex.EncodeToString(sum[:16])
+}
+
+// sampled reports whether events of the (anonymized) client are kept.
+func sampled(anonymousID string) bool {
+	eventSampleRate := currentConfig().EventSampleRate
+	if eventSampleRate >= 1 {
+		return true
+	}
+	sum := sha256.Sum256([]byte(anonymousID))
+	return float64(binary.BigEndian.Uint64(sum[:8]))/float64(^uint64(0)) < eventSampleRate
+}
+
+// postEvents ingests a batch of client analytics events. Invalid events are
+// reported back individually; the rest of the batch is still stored and later
+// shipped to the warehouse by the export job.
+func postEvents(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data struct {
+			Events []analyticsEvent `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if len(data.Events) == 0 || len(data.Events) > maxEventBatch {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidEventBatch)
+			return
+		}
+
+		type rejected struct {
+			Index  int    `json:"index"`
+			Reason string `json:"reason"`
+		}
+		report := struct {
+			Accepted int        `json:"accepted"`
+			Rejected []rejected `json:"rejected"`
+		}{Rejected: []rejected{}}
+
+		tx, err := db.Begin()
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		// Events of callers who opted out of tracking are accepted but not kept
+		tracking := true
+		if owner := callerID(r); owner != "" {
+			if tracking, err = NewStore(db).HasConsent(r.Context(), owner, consentTracking); err != nil {
+				serverError(w, r, err)
+				return
+			}
+		}
+
+		now := time.Now()
+		for idx, e := range data.Events {
+			if reason := e.validate(); reason != "" {
+				report.Rejected = append(report.Rejected, rejected{idx, reason})
+				continue
+			}
+			report.Accepted++
+
+			anonymousID := anonymize(e.AnonymousID)
+			if !tracking || !sampled(anonymousID) {
+				continue
+			}
+			occurredAt := now
+			if e.OccurredAt != nil && e.OccurredAt.Before(now) {
+				occurredAt = *e.OccurredAt
+			}
+			properties := e.Properties
+			if len(properties) == 0 {
+				properties = json.RawMessage("{}")
+			}
+
+			_, err := tx.Exec(`
+            INSERT INTO analytics_events (type, anonymous_id, item_id, query, properties, occurred_at)
+            VALUES ($1, $2, $3, $4, $5, $6)`,
+				e.Type, anonymousID, e.ItemID, e.Query, []byte(properties), occurredAt)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(report)
+	}
+}
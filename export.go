@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// exportLag keeps the exporter behind the newest rows so transactions that are
+// still in flight when a batch is cut are picked up by the next run.
+const exportLag = time.Minute
+
+// exportDataset is a table exported incrementally by its change timestamp. The
+// query receives the previous and the new watermark and returns
+// (changed_at, row as JSON) pairs.
+type exportDataset struct {
+	name  string
+	query string
+}
+
+var exportDatasets = []exportDataset{
+	{"items", `
+        SELECT updated_at, to_jsonb(s) - 'search_vector'
+        FROM sneakers s
+        WHERE updated_at > $1 AND updated_at <= $2
+        ORDER BY updated_at, id`},
+	{"events", `
+        SELECT received_at, to_jsonb(e)
+        FROM analytics_events e
+        WHERE received_at > $1 AND received_at <= $2
+        ORDER BY received_at, id`},
+}
+
+// exportSink stores exported batches.
+type exportSink interface {
+	Put(ctx context.Context, key string, body []byte) error
+}
+
+// dirSink writes batches below a directory, typically a bucket mounted with
+// gcsfuse or s3fs. Files appear atomically.
+type dirSink struct {
+	dir string
+}
+
+func (s dirSink) Put(ctx context.Context, key string, body []byte) error {
+	path := filepath.Join(s.dir, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// exportChanges ships every dataset's rows changed since its watermark to sink
+// as NDJSON and advances the watermark once the batch is stored.
+func exportChanges(db *sql.DB, sink exportSink) func(context.Context) error {
+	return func(ctx context.Context) error {
+		for _, ds := range exportDatasets {
+			if err := exportDatasetChanges(ctx, db, sink, ds); err != nil {
+				return fmt.Errorf("%s: %w", ds.name, err)
+			}
+		}
+		return nil
+	}
+}
+
+func exportDatasetChanges(ctx context.Context, db *sql.DB, sink exportSink, ds exportDataset) error {
+	var from time.Time
+	err := db.QueryRowContext(ctx, "SELECT watermark FROM export_watermarks WHERE dataset = $1", ds.name).Scan(&from)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	to := time.Now().Add(-exportLag)
+	if !to.After(from) {
+		return nil
+	}
+
+	rows, err := db.QueryContext(ctx, ds.query, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	count := 0
+	for rows.Next() {
+		var changedAt time.Time
+		var row []byte
+		if err := rows.Scan(&changedAt, &row); err != nil {
+			return err
+		}
+		buf.Write(row)
+		buf.WriteByte('\n')
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		key := fmt.Sprintf("%s/%s_%s.ndjson", ds.name, ds.name, to.UTC().Format("20060102T150405Z"))
+		if err := sink.Put(ctx, key, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.ExecContext(ctx, `
+    INSERT INTO export_watermarks (dataset, watermark) VALUES ($1, $2)
+    ON CONFLICT (dataset) DO UPDATE SET watermark = EXCLUDED.watermark`, ds.name, to)
+	return err
+}
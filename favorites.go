@@ -0,0 +1,378 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// favoritesOwner returns the owner of the favorites the caller reads,
+// identified like other per-customer data by X-User-ID or X-Device-ID.
+// Callers identifying themselves with neither read the list of favorites
+// made before favorites had owners, whose rows have none. That legacy list
+// is read-only, through GET /favorites and GET /favorites/export, and
+// deprecated (see deprecations); every other favorites endpoint requires a
+// caller.
+func favoritesOwner(r *http.Request) *string {
+	if owner := callerID(r); owner != "" {
+		return &owner
+	}
+	return nil
+}
+
+// reorderFavorites persists a new favorites order. The body either lists
+// favorite IDs in the desired order ({"favorite_ids": [3, 1]}; unlisted
+// favorites keep their relative order after them) or moves a single favorite
+// to a 1-based position ({"favorite_id": 3, "position": 1}).
+func reorderFavorites(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		var data struct {
+			FavoriteIDs []int `json:"favorite_ids"`
+			FavoriteID  int   `json:"favorite_id"`
+			Position    int   `json:"position"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		listed := len(data.FavoriteIDs) > 0
+		moved := data.FavoriteID != 0
+		if listed == moved || (moved && data.Position < 1) || hasDuplicates(data.FavoriteIDs) {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidFavoriteOrder)
+			return
+		}
+
+		err := withTx(r.Context(), db, nil, func(tx *sql.Tx) error {
+			rows, err := tx.Query("SELECT id FROM favorite WHERE owner = $1 ORDER BY position, id FOR UPDATE", owner)
+			if err != nil {
+				return err
+			}
+			var current []int
+			for rows.Next() {
+				var id int
+				if err := rows.Scan(&id); err != nil {
+					rows.Close()
+					return err
+				}
+				current = append(current, id)
+			}
+			rows.Close()
+
+			requested := data.FavoriteIDs
+			if moved {
+				requested = []int{data.FavoriteID}
+			}
+			for _, id := range requested {
+				if !slices.Contains(current, id) {
+					return &apiError{http.StatusNotFound, errCodeFavoriteNotFound}
+				}
+			}
+
+			var order []int
+			if listed {
+				order = append(order, data.FavoriteIDs...)
+				for _, id := range current {
+					if !slices.Contains(data.FavoriteIDs, id) {
+						order = append(order, id)
+					}
+				}
+			} else {
+				order = slices.DeleteFunc(current, func(id int) bool { return id == data.FavoriteID })
+				order = slices.Insert(order, min(data.Position-1, len(order)), data.FavoriteID)
+			}
+
+			_, err = tx.Exec(`
+            UPDATE favorite f SET position = o.position
+            FROM unnest($1::int[]) WITH ORDINALITY AS o(id, position)
+            WHERE f.id = o.id`, pq.Array(order))
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func hasDuplicates(ids []int) bool {
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return true
+		}
+		seen[id] = true
+	}
+	return false
+}
+
+// maxFavoriteBatch is the most sneakers a batch can add or remove.
+const maxFavoriteBatch = 500
+
+// readFavoriteBatch decodes the sneakers of a batch, {"item_ids": [12, 7]},
+// without repeats. It writes the error response and returns nil when the
+// body is invalid.
+func readFavoriteBatch(w http.ResponseWriter, r *http.Request) []int {
+	var data struct {
+		ItemIDs []int `json:"item_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+		return nil
+	}
+	if len(data.ItemIDs) == 0 || len(data.ItemIDs) > maxFavoriteBatch {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidFavoriteBatch)
+		return nil
+	}
+	var ids []int
+	for _, id := range data.ItemIDs {
+		if id <= 0 {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return nil
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// postFavoritesBatch favorites several sneakers at once, in one transaction,
+// so the app can sync its local list in a single request. New favorites are
+// added after the existing ones in the order given; sneakers already
+// favorited are kept as they are. If one of the sneakers cannot be found,
+// nothing is favorited. The favorites of all listed sneakers are returned,
+// with created set on new ones.
+func postFavoritesBatch(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		itemIDs := readFavoriteBatch(w, r)
+		if itemIDs == nil {
+			return
+		}
+
+		type batchFavorite struct {
+			ID      int  `json:"id"`
+			ItemID  int  `json:"item_id"`
+			Created bool `json:"created"`
+		}
+		var favorites []batchFavorite
+		err := withTx(r.Context(), db, nil, func(tx *sql.Tx) error {
+			favorites = []batchFavorite{}
+			var found int
+			err := tx.QueryRow("SELECT count(*) FROM sneakers WHERE id = ANY($1) AND "+itemVisible(""), pq.Array(itemIDs)).Scan(&found)
+			if err != nil {
+				return err
+			}
+			if found < len(itemIDs) {
+				return &apiError{http.StatusNotFound, errCodeItemNotFound}
+			}
+
+			rows, err := tx.Query(`
+            WITH created AS (
+                INSERT INTO favorite (owner, item_id, position, wishlist_id)
+                SELECT $1, o.item_id, (SELECT coalesce(max(position), 0) FROM favorite WHERE owner = $1) + o.n, `+defaultWishlist(1)+`
+                FROM unnest($2::int[]) WITH ORDINALITY AS o(item_id, n)
+                ON CONFLICT ((coalesce(owner, '')), item_id) DO NOTHING
+                RETURNING id, item_id)
+            SELECT id, item_id, true FROM created
+            UNION ALL
+            SELECT id, item_id, false FROM favorite
+            WHERE owner = $1 AND item_id = ANY($2)
+            ORDER BY 2`, owner, pq.Array(itemIDs))
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var f batchFavorite
+				if err := rows.Scan(&f.ID, &f.ItemID, &f.Created); err != nil {
+					return err
+				}
+				favorites = append(favorites, f)
+			}
+			return rows.Err()
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(favorites)
+	}
+}
+
+// deleteFavoritesBatch unfavorites several sneakers at once. Sneakers that
+// are not favorited are ignored.
+func deleteFavoritesBatch(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		itemIDs := readFavoriteBatch(w, r)
+		if itemIDs == nil {
+			return
+		}
+
+		// A single statement, so the batch is removed atomically
+		_, err := db.ExecContext(r.Context(), "DELETE FROM favorite WHERE owner = $1 AND item_id = ANY($2)", owner, pq.Array(itemIDs))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// favoritesExport is the documented favorites export/import format:
+//
+//	{
+//	  "format": "sneakers-favorites",
+//	  "version": 1,
+//	  "exported_at": "2024-05-18T12:00:00Z",
+//	  "favorites": [
+//	    {"item_id": 12, "style_code": "DD1391-100", "title": "Nike Dunk Low Panda"}
+//	  ]
+//	}
+//
+// Entries are listed in the user's favorites order. On import an entry is
+// matched by item_id first and by style_code second, so exports from the old
+// app, whose IDs differ, still resolve; title is informational only.
+type favoritesExport struct {
+	Format     string                 `json:"format"`
+	Version    int                    `json:"version"`
+	ExportedAt time.Time              `json:"exported_at"`
+	Favorites  []favoritesExportEntry `json:"favorites"`
+}
+
+type favoritesExportEntry struct {
+	ItemID    int     `json:"item_id,omitempty"`
+	StyleCode *string `json:"style_code,omitempty"`
+	Title     string  `json:"title,omitempty"`
+}
+
+const (
+	favoritesExportFormat  = "sneakers-favorites"
+	favoritesExportVersion = 1
+)
+
+func exportFavorites(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query(`
+        SELECT s.id, s.style_code, s.title
+        FROM favorite f
+        INNER JOIN sneakers s ON f.item_id = s.id
+        WHERE f.owner IS NOT DISTINCT FROM $1 AND `+itemVisible("s")+`
+        ORDER BY f.position, f.id`, favoritesOwner(r))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		export := favoritesExport{
+			Format:     favoritesExportFormat,
+			Version:    favoritesExportVersion,
+			ExportedAt: time.Now().UTC(),
+			Favorites:  []favoritesExportEntry{},
+		}
+		for rows.Next() {
+			var e favoritesExportEntry
+			if err := rows.Scan(&e.ItemID, &e.StyleCode, &e.Title); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			export.Favorites = append(export.Favorites, e)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="favorites.json"`)
+		json.NewEncoder(w).Encode(export)
+	}
+}
+
+// importFavorites adds the entries of a favoritesExport after the existing
+// favorites, skipping sneakers that are already favorited or cannot be found.
+func importFavorites(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		var data favoritesExport
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if data.Format != favoritesExportFormat || data.Version != favoritesExportVersion {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeUnsupportedExportFormat)
+			return
+		}
+
+		type skipped struct {
+			Index  int    `json:"index"`
+			Reason string `json:"reason"`
+		}
+		type importReport struct {
+			Imported int       `json:"imported"`
+			Skipped  []skipped `json:"skipped"`
+		}
+		var report importReport
+
+		err := withTx(r.Context(), db, nil, func(tx *sql.Tx) error {
+			report = importReport{Skipped: []skipped{}}
+			for idx, e := range data.Favorites {
+				var itemID int
+				err := tx.QueryRow(`
+                SELECT id FROM sneakers
+                WHERE `+itemVisible("")+` AND (id = $1 OR ($2::text IS NOT NULL AND style_code = $2))
+                ORDER BY id = $1 DESC
+                LIMIT 1`, e.ItemID, e.StyleCode).Scan(&itemID)
+				if errors.Is(err, sql.ErrNoRows) {
+					report.Skipped = append(report.Skipped, skipped{idx, "not_found"})
+					continue
+				}
+				if err != nil {
+					return err
+				}
+
+				res, err := tx.Exec(`
+                INSERT INTO favorite (owner, item_id, position, wishlist_id)
+                SELECT $2, $1, (SELECT coalesce(max(position), 0) + 1 FROM favorite WHERE owner = $2), `+defaultWishlist(2)+`
+                ON CONFLICT ((coalesce(owner, '')), item_id) DO NOTHING`, itemID, owner)
+				if err != nil {
+					return err
+				}
+				if n, _ := res.RowsAffected(); n == 0 {
+					report.Skipped = append(report.Skipped, skipped{idx, "already_favorited"})
+					continue
+				}
+				report.Imported++
+			}
+			return nil
+		})
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
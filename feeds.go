@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"time"
+)
+
+// newArrivalsLimit is the number of entries in the new arrivals feed.
+const newArrivalsLimit = 50
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Link      atomLink    `xml:"link"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// getNewArrivalsFeed serves an Atom feed of the most recently added sneakers.
+func getNewArrivalsFeed(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query(`
+        SELECT id, title, price, imageUrl, created_at, `+itemModified+`
+        FROM sneakers
+        WHERE `+itemVisible("")+` AND `+itemInMarket("", "$2")+`
+        ORDER BY created_at DESC, id DESC
+        LIMIT $1`, newArrivalsLimit, requestMarket(w, r))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		feed := atomFeed{
+			ID:    storefrontURL + "/feeds/new-arrivals",
+			Title: "New arrivals",
+			Links: []atomLink{
+				{Href: storefrontURL, Rel: "alternate", Type: "text/html"},
+				{Href: "/feeds/new-arrivals.atom", Rel: "self", Type: "application/atom+xml"},
+			},
+		}
+		var updated time.Time
+		for rows.Next() {
+			var i sneakerSummary
+			var createdAt, updatedAt time.Time
+			if err := rows.Scan(&i.ID, &i.Title, &i.Price, &i.ImageURL, &createdAt, &updatedAt); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			if updatedAt.After(updated) {
+				updated = updatedAt
+			}
+
+			link := fmt.Sprintf("%s/items/%d", storefrontURL, i.ID)
+			feed.Entries = append(feed.Entries, atomEntry{
+				ID:        link,
+				Title:     i.Title,
+				Updated:   updatedAt.UTC().Format(time.RFC3339),
+				Published: createdAt.UTC().Format(time.RFC3339),
+				Link:      atomLink{Href: link, Rel: "alternate", Type: "text/html"},
+				Content: atomContent{Type: "html", Body: fmt.Sprintf(`<p><img src="%s" alt="%s"/></p><p>Price: %s</p>`,
+					html.EscapeString(i.ImageURL), html.EscapeString(i.Title), i.Price)},
+			})
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		// Sneakers leaving the feed change it too
+		catalog, err := catalogModified(db)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if catalog.After(updated) {
+			updated = catalog
+		}
+		if updated.IsZero() {
+			updated = time.Now()
+		}
+		feed.Updated = updated.UTC().Format(time.RFC3339)
+
+		if notModified(w, r, updated) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(feed)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Search history retention: only the most recent distinct queries of each
+// caller are kept, and none older than searchHistoryMaxAge.
+const (
+	searchHistoryLimit  = 20
+	searchHistoryMaxAge = 90 * 24 * time.Hour
+)
+
+// recordSearchHistory remembers query as the caller's most recent search and
+// trims their history. Failures are logged but never fail the search.
+func recordSearchHistory(db *sql.DB, owner, query string) {
+	query = normalizeQuery(query)
+	_, err := db.Exec(`
+    INSERT INTO search_history (owner, query) VALUES ($1, $2)
+    ON CONFLICT (owner, query) DO UPDATE SET searched_at = now()`, owner, query)
+	if err == nil {
+		_, err = db.Exec(`
+        DELETE FROM search_history
+        WHERE owner = $1 AND id NOT IN (
+            SELECT id FROM search_history WHERE owner = $1 ORDER BY searched_at DESC LIMIT $2
+        )`, owner, searchHistoryLimit)
+	}
+	if err != nil {
+		log.Printf("record search history: %v", err)
+	}
+}
+
+func getSearchHistory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT id, query, searched_at
+        FROM search_history
+        WHERE owner = $1 AND searched_at > $2
+        ORDER BY searched_at DESC
+        LIMIT $3`, owner, time.Now().Add(-searchHistoryMaxAge), searchHistoryLimit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type search struct {
+			ID         int64     `json:"id"`
+			Query      string    `json:"query"`
+			SearchedAt time.Time `json:"searched_at"`
+		}
+		searches := []search{}
+		for rows.Next() {
+			var s search
+			if err := rows.Scan(&s.ID, &s.Query, &s.SearchedAt); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			searches = append(searches, s)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(searches)
+	}
+}
+
+// deleteSearchHistory clears the caller's whole search history.
+func deleteSearchHistory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+
+		if _, err := db.Exec("DELETE FROM search_history WHERE owner = $1", owner); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deleteSearchHistoryEntry removes a single search from the caller's history.
+func deleteSearchHistoryEntry(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		id, err := strconv.ParseInt(mux.Vars(r)["searchId"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidSearchHistoryID)
+			return
+		}
+
+		res, err := db.Exec("DELETE FROM search_history WHERE owner = $1 AND id = $2", owner, id)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
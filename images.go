@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// itemImage is one picture of a sneaker's gallery. Exactly one image of every
+// sneaker is primary; its URL is also the sneaker's image_url.
+type itemImage struct {
+	ID        int    `json:"id"`
+	URL       string `json:"url"`
+	AltText   string `json:"alt_text"`
+	Position  int    `json:"position"`
+	IsPrimary bool   `json:"is_primary"`
+	// Srcset maps size names (thumb, card, zoom) to resized versions of
+	// uploaded images once they have been generated.
+	Srcset map[string]string `json:"srcset,omitempty"`
+}
+
+// itemImagesColumn selects the gallery of a sneaker as a JSON array, for
+// itemColumns. Images still without alt text, such as those added through
+// image_url, fall back to the sneaker's title so frontends always have one.
+const itemImagesColumn = `(SELECT coalesce(json_agg(json_build_object(
+		'id', im.id, 'url', im.url, 'alt_text', coalesce(nullif(im.alt_text, ''), sneakers.title), 'position', im.position, 'is_primary', im.is_primary,
+		'srcset', im.renditions
+	) ORDER BY im.position, im.id), '[]') FROM item_images im WHERE im.item_id = sneakers.id)`
+
+const imageColumns = "id, url, alt_text, position, is_primary, renditions"
+
+func scanImage(row rowScanner) (itemImage, error) {
+	var im itemImage
+	var renditions []byte
+	err := row.Scan(&im.ID, &im.URL, &im.AltText, &im.Position, &im.IsPrimary, &renditions)
+	if err != nil || renditions == nil {
+		return im, err
+	}
+	return im, json.Unmarshal(renditions, &im.Srcset)
+}
+
+// imageInput is the writable part of an image. Position defaults to after
+// the last image.
+type imageInput struct {
+	URL       string `json:"url"`
+	AltText   string `json:"alt_text"`
+	Position  *int   `json:"position"`
+	IsPrimary bool   `json:"is_primary"`
+	// ObjectKey is set for images uploaded to the media store.
+	ObjectKey *string `json:"-"`
+}
+
+// maxAltTextLength is the longest alt text in characters; screen readers
+// read it in full, so it should describe the picture briefly.
+const maxAltTextLength = 250
+
+// validAltText reports whether s, trimmed, is an acceptable alt text.
+func validAltText(s string) bool {
+	n := utf8.RuneCountInString(strings.TrimSpace(s))
+	return n > 0 && n <= maxAltTextLength
+}
+
+func (in *imageInput) validate() string {
+	in.URL = strings.TrimSpace(in.URL)
+	in.AltText = strings.TrimSpace(in.AltText)
+	switch {
+	case in.URL == "":
+		return errCodeItemImageRequired
+	case !validAltText(in.AltText):
+		return errCodeInvalidAltText
+	case in.Position != nil && *in.Position < 0:
+		return errCodeInvalidImagePosition
+	}
+	return ""
+}
+
+// SetPrimaryImage makes an image the primary one of its sneaker and copies
+// its URL to the sneaker's image_url.
+func (s Store) SetPrimaryImage(ctx context.Context, itemID, imageID int) error {
+	if _, err := s.q.ExecContext(ctx, "UPDATE item_images SET is_primary = false WHERE item_id = $1 AND is_primary AND id <> $2", itemID, imageID); err != nil {
+		return err
+	}
+	_, err := s.q.ExecContext(ctx, `
+    WITH im AS (UPDATE item_images SET is_primary = true WHERE id = $2 RETURNING url)
+    UPDATE sneakers SET imageUrl = (SELECT url FROM im) WHERE id = $1`, itemID, imageID)
+	return err
+}
+
+func getItemImages(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		var images json.RawMessage
+		err = db.QueryRow("SELECT "+itemImagesColumn+" FROM sneakers WHERE id = $1 AND "+itemVisible(""), itemID).Scan(&images)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(images)
+	}
+}
+
+// maxImageBytes is the largest image accepted by uploads.
+const maxImageBytes = 10 << 20
+
+// imageTypes maps the accepted upload content types to file extensions.
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// createItemImage adds an image to a sneaker's gallery. The body is either
+// JSON referencing an image hosted elsewhere, or a multipart/form-data upload
+// with the image in the "file" field, its "alt_text" and optional "position"
+// and "is_primary" fields, which is stored in the media store. Every image
+// needs an alt text for screen readers.
+func createItemImage(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		var in imageInput
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if in, err = uploadImage(w, r, itemID); err != nil {
+				handleError(w, r, err)
+				return
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if code := in.validate(); code != "" {
+			writeError(w, r, http.StatusUnprocessableEntity, code)
+			return
+		}
+
+		var im itemImage
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			var err error
+			im, err = tx.AddImage(r.Context(), itemID, in)
+			return err
+		})
+		if err != nil {
+			if in.ObjectKey != nil {
+				media.Delete(context.WithoutCancel(r.Context()), *in.ObjectKey)
+			}
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/items/%d/images", itemID))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(im)
+	}
+}
+
+// uploadImage validates a multipart image upload and stores the file in the
+// media store, returning the input for the new gallery image.
+func uploadImage(w http.ResponseWriter, r *http.Request, itemID int) (imageInput, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageBytes+1<<20)
+	file, header, err := r.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return imageInput{}, &apiError{http.StatusRequestEntityTooLarge, errCodeImageTooLarge}
+	}
+	if err != nil {
+		return imageInput{}, &apiError{http.StatusBadRequest, errCodeInvalidBody}
+	}
+	defer file.Close()
+	if header.Size > maxImageBytes {
+		return imageInput{}, &apiError{http.StatusRequestEntityTooLarge, errCodeImageTooLarge}
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return imageInput{}, err
+	}
+
+	// Checked before the file is stored so a rejected upload leaves nothing behind
+	if !validAltText(r.FormValue("alt_text")) {
+		return imageInput{}, &apiError{http.StatusUnprocessableEntity, errCodeInvalidAltText}
+	}
+
+	// The declared content type is not trusted; the type is sniffed from the data
+	contentType := http.DetectContentType(data)
+	ext, ok := imageTypes[contentType]
+	if !ok {
+		return imageInput{}, &apiError{http.StatusUnsupportedMediaType, errCodeUnsupportedImageType}
+	}
+
+	name := make([]byte, 16)
+	rand.Read(name)
+	key := fmt.Sprintf("items/%d/%x%s", itemID, name, ext)
+	if err := media.Put(r.Context(), key, contentType, data); err != nil {
+		return imageInput{}, err
+	}
+
+	in := imageInput{
+		URL:       media.URL(key),
+		AltText:   r.FormValue("alt_text"),
+		IsPrimary: r.FormValue("is_primary") == "true",
+		ObjectKey: &key,
+	}
+	if raw := r.FormValue("position"); raw != "" {
+		position, err := strconv.Atoi(raw)
+		if err != nil {
+			return imageInput{}, &apiError{http.StatusUnprocessableEntity, errCodeInvalidImagePosition}
+		}
+		in.Position = &position
+	}
+	return in, nil
+}
+
+// AddImage inserts an image into a sneaker's gallery, making it primary when
+// requested.
+func (s Store) AddImage(ctx context.Context, itemID int, in imageInput) (itemImage, error) {
+	im, err := scanImage(s.q.QueryRowContext(ctx, `
+    INSERT INTO item_images (item_id, url, alt_text, position, object_key)
+    VALUES ($1, $2, $3, coalesce($4, (SELECT coalesce(max(position), -1) + 1 FROM item_images WHERE item_id = $1)), $5)
+    RETURNING `+imageColumns, itemID, in.URL, in.AltText, in.Position, in.ObjectKey))
+	if isForeignKeyViolation(err) {
+		return im, &apiError{http.StatusNotFound, errCodeItemNotFound}
+	}
+	if err != nil {
+		return im, err
+	}
+
+	if in.IsPrimary {
+		im.IsPrimary = true
+		return im, s.SetPrimaryImage(ctx, itemID, im.ID)
+	}
+	_, err = s.TouchItem(ctx, itemID)
+	return im, err
+}
+
+// updateItemImage replaces the URL, alt text and position of an image, and
+// makes it primary when requested. The primary image can only lose that role
+// by making another image primary.
+func updateItemImage(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+		imageID, err := strconv.Atoi(mux.Vars(r)["imageId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidImageID)
+			return
+		}
+
+		var in imageInput
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if code := in.validate(); code != "" {
+			writeError(w, r, http.StatusUnprocessableEntity, code)
+			return
+		}
+
+		var im itemImage
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			var err error
+			im, err = scanImage(tx.q.QueryRowContext(r.Context(), `
+            UPDATE item_images SET url = $3, alt_text = $4, position = coalesce($5, position)
+            WHERE id = $1 AND item_id = $2
+            RETURNING `+imageColumns, imageID, itemID, in.URL, in.AltText, in.Position))
+			if errors.Is(err, sql.ErrNoRows) {
+				return &apiError{http.StatusNotFound, errCodeImageNotFound}
+			}
+			if err != nil {
+				return err
+			}
+
+			if in.IsPrimary || im.IsPrimary {
+				im.IsPrimary = true
+				return tx.SetPrimaryImage(r.Context(), itemID, im.ID)
+			}
+			_, err = tx.TouchItem(r.Context(), itemID)
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(im)
+	}
+}
+
+// deleteItemImage removes an image. When it was the primary one, the next
+// image in gallery order takes its place; the last image cannot be removed
+// because every sneaker needs one.
+func deleteItemImage(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+		imageID, err := strconv.Atoi(mux.Vars(r)["imageId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidImageID)
+			return
+		}
+
+		var objectKey *string
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			var wasPrimary bool
+			err := tx.q.QueryRowContext(r.Context(), `
+            DELETE FROM item_images WHERE id = $1 AND item_id = $2
+            RETURNING is_primary, object_key`, imageID, itemID).Scan(&wasPrimary, &objectKey)
+			if errors.Is(err, sql.ErrNoRows) {
+				return &apiError{http.StatusNotFound, errCodeImageNotFound}
+			}
+			if err != nil {
+				return err
+			}
+
+			var next int
+			err = tx.q.QueryRowContext(r.Context(), "SELECT id FROM item_images WHERE item_id = $1 ORDER BY position, id LIMIT 1", itemID).Scan(&next)
+			if errors.Is(err, sql.ErrNoRows) {
+				return &apiError{http.StatusConflict, errCodeLastImage}
+			}
+			if err != nil {
+				return err
+			}
+
+			if wasPrimary {
+				return tx.SetPrimaryImage(r.Context(), itemID, next)
+			}
+			_, err = tx.TouchItem(r.Context(), itemID)
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		// Uploaded files go with their image; a leftover file is harmless, so
+		// failures are only logged
+		if objectKey != nil {
+			if err := deleteUpload(context.WithoutCancel(r.Context()), *objectKey); err != nil {
+				log.Printf("delete image %s: %v", *objectKey, err)
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getImagesMissingAltText reports the images of sneakers in the catalog that
+// have no alt text yet, such as those added through image_url before alt
+// text was required, so they can be worked through. Primary images, which
+// are seen most, come first.
+func getImagesMissingAltText(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := queryLimit(r, 100, 1000)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT im.id, im.url, im.is_primary, s.id, s.title, count(*) OVER ()
+        FROM item_images im
+        INNER JOIN sneakers s ON s.id = im.item_id
+        WHERE im.alt_text = '' AND s.deleted_at IS NULL
+        ORDER BY im.is_primary DESC, s.id, im.position, im.id
+        LIMIT $1`, limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type missing struct {
+			ImageID   int    `json:"image_id"`
+			URL       string `json:"url"`
+			IsPrimary bool   `json:"is_primary"`
+			ItemID    int    `json:"item_id"`
+			ItemTitle string `json:"item_title"`
+		}
+		report := struct {
+			Total  int       `json:"total"`
+			Images []missing `json:"images"`
+		}{Images: []missing{}}
+		for rows.Next() {
+			var m missing
+			if err := rows.Scan(&m.ImageID, &m.URL, &m.IsPrimary, &m.ItemID, &m.ItemTitle, &report.Total); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			report.Images = append(report.Images, m)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// patchImageAltTexts sets the alt text of several images at once, e.g.
+// [{"image_id": 12, "alt_text": "Side view of the Dunk Low Panda"}], in one
+// transaction.
+func patchImageAltTexts(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var updates []struct {
+			ImageID int    `json:"image_id"`
+			AltText string `json:"alt_text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if len(updates) == 0 || len(updates) > maxItemBatch {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidItemBatch)
+			return
+		}
+		for _, u := range updates {
+			if !validAltText(u.AltText) {
+				writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidAltText)
+				return
+			}
+		}
+
+		err := NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			for _, u := range updates {
+				var itemID int
+				err := tx.q.QueryRowContext(r.Context(), "UPDATE item_images SET alt_text = $2 WHERE id = $1 RETURNING item_id",
+					u.ImageID, strings.TrimSpace(u.AltText)).Scan(&itemID)
+				if errors.Is(err, sql.ErrNoRows) {
+					return &apiError{http.StatusNotFound, errCodeImageNotFound}
+				}
+				if err != nil {
+					return err
+				}
+				if _, err := tx.TouchItem(r.Context(), itemID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxImportBytes is the largest catalog file accepted by imports.
+const maxImportBytes = 10 << 20
+
+// maxImportRows is how many sneakers one import may create or update.
+const maxImportRows = 5000
+
+// importColumns are the columns a catalog file may have, in any order. Each
+// sets its field from a non-empty cell and returns the error code of an
+// invalid value, or "". Empty cells leave the field unchanged.
+var importColumns = map[string]func(in *itemInput, v string) string{
+	"sku":         func(in *itemInput, v string) string { in.SKU = &v; return "" },
+	"title":       func(in *itemInput, v string) string { in.Title = v; return "" },
+	"barcode":     func(in *itemInput, v string) string { in.Barcode = &v; return "" },
+	"image_url":   func(in *itemInput, v string) string { in.ImageURL = v; return "" },
+	"description": func(in *itemInput, v string) string { in.Description = v; return "" },
+	"style_code":  func(in *itemInput, v string) string { in.StyleCode = &v; return "" },
+	"style_group": func(in *itemInput, v string) string { in.StyleGroup = &v; return "" },
+	"status":      func(in *itemInput, v string) string { in.Status = strings.ToLower(v); return "" },
+	"attributes":  func(in *itemInput, v string) string { in.Attributes = json.RawMessage(v); return "" },
+	"materials": func(in *itemInput, v string) string {
+		in.Materials = []string{}
+		for _, m := range strings.Split(v, "|") {
+			if m = strings.TrimSpace(m); m != "" {
+				in.Materials = append(in.Materials, m)
+			}
+		}
+		return ""
+	},
+	"markets": func(in *itemInput, v string) string {
+		in.Markets = nil
+		for _, c := range strings.Split(v, "|") {
+			if c = strings.TrimSpace(c); c != "" {
+				in.Markets = append(in.Markets, c)
+			}
+		}
+		return ""
+	},
+	"price": func(in *itemInput, v string) string {
+		p, err := parseMoney(v)
+		if err != nil {
+			return errCodeInvalidPrice
+		}
+		in.Price = p
+		return ""
+	},
+	"stock": func(in *itemInput, v string) string {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return errCodeInvalidStock
+		}
+		in.Stock = n
+		return ""
+	},
+	"low_stock_threshold": importInt(func(in *itemInput) **int { return &in.LowStockThreshold }, errCodeInvalidStock),
+	"release_year":        importInt(func(in *itemInput) **int { return &in.ReleaseYear }, errCodeInvalidReleaseYear),
+	"weight_grams":        importInt(func(in *itemInput) **int { return &in.WeightGrams }, errCodeInvalidWeight),
+	"brand_id":            importInt(func(in *itemInput) **int { return &in.BrandID }, errCodeInvalidBrandID),
+	"category_id":         importInt(func(in *itemInput) **int { return &in.CategoryID }, errCodeInvalidCategoryID),
+	"model_id":            importInt(func(in *itemInput) **int { return &in.ModelID }, errCodeInvalidModelID),
+	"publish_at":          importTime(func(in *itemInput) **time.Time { return &in.PublishAt }),
+	"member_access_at":    importTime(func(in *itemInput) **time.Time { return &in.MemberAccessAt }),
+	"release_at":          importTime(func(in *itemInput) **time.Time { return &in.ReleaseAt }),
+}
+
+func importInt(field func(*itemInput) **int, code string) func(*itemInput, string) string {
+	return func(in *itemInput, v string) string {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return code
+		}
+		*field(in) = &n
+		return ""
+	}
+}
+
+// importTime parses RFC 3339 timestamps. Spreadsheets must store them as
+// text; date cells are serial numbers that cannot be told apart from others.
+func importTime(field func(*itemInput) **time.Time) func(*itemInput, string) string {
+	return func(in *itemInput, v string) string {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return errCodeInvalidDate
+		}
+		*field(in) = &t
+		return ""
+	}
+}
+
+// importItems creates and updates sneakers from a catalog file, matching
+// existing ones by SKU. The file is a CSV or XLSX spreadsheet, sent as the
+// body or as the "file" field of a multipart form, whose first row names the
+// columns, e.g.
+//
+//	sku,title,price,stock,image_url,brand_id,materials
+//	DD1391-100,Dunk Low Panda,119.99,12,https://cdn.example.com/dunk.jpg,1,leather|rubber
+//
+// Only sku is required; the other columns overwrite the stored values of
+// existing sneakers, and new sneakers need at least a title, a price and an
+// image_url. Each row is saved on its own, so an invalid row is reported and
+// skipped without holding back the others:
+//
+//	{"created": 120, "updated": 879, "errors": [{"row": 14, "code": "invalid_price", "message": "..."}]}
+//
+// Rows are numbered as in the spreadsheet, the header being row 1. Since
+// rows are matched by SKU, fixing the reported rows and importing the file
+// again is safe. New sneakers that likely duplicate others, by style code or
+// title, are still created but flagged for review and listed in "warnings"
+// with the sneakers they match.
+func importItems(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := readImportFile(w, r)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		rows, err := parseImportFile(data)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		var header []string
+		if len(rows) > 0 {
+			header = rows[0]
+		}
+		skuColumn := -1
+		for n, name := range header {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, ok := importColumns[name]; !ok && name != "" {
+				writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidImportFile)
+				return
+			}
+			if name == "sku" {
+				skuColumn = n
+			}
+			header[n] = name
+		}
+		if skuColumn < 0 {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidImportFile)
+			return
+		}
+		if len(rows)-1 > maxImportRows {
+			writeError(w, r, http.StatusRequestEntityTooLarge, errCodeImportTooLarge)
+			return
+		}
+
+		type rowError struct {
+			Row     int    `json:"row"`
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		type rowWarning struct {
+			Row        int              `json:"row"`
+			Code       string           `json:"code"`
+			Duplicates []duplicateMatch `json:"duplicates"`
+		}
+		report := struct {
+			Created  int          `json:"created"`
+			Updated  int          `json:"updated"`
+			Errors   []rowError   `json:"errors"`
+			Warnings []rowWarning `json:"warnings"`
+		}{Errors: []rowError{}, Warnings: []rowWarning{}}
+		lang := negotiateLanguage(r)
+
+		for n, row := range rows[1:] {
+			if strings.TrimSpace(strings.Join(row, "")) == "" {
+				continue
+			}
+			created, matches, err := importRow(r, db, header, skuColumn, row)
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				report.Errors = append(report.Errors, rowError{n + 2, apiErr.code, localize(lang, apiErr.code)})
+				continue
+			}
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			if len(matches) > 0 {
+				report.Warnings = append(report.Warnings, rowWarning{n + 2, errCodeLikelyDuplicate, matches})
+			}
+			if created {
+				report.Created++
+			} else {
+				report.Updated++
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// importRow creates or updates the sneaker of one row and reports whether it
+// was created, and which sneakers a created one likely duplicates.
+func importRow(r *http.Request, db *sql.DB, header []string, skuColumn int, row []string) (bool, []duplicateMatch, error) {
+	sku := ""
+	if skuColumn < len(row) {
+		sku = strings.TrimSpace(row[skuColumn])
+	}
+	if sku == "" {
+		return false, nil, &apiError{http.StatusUnprocessableEntity, errCodeSKURequired}
+	}
+
+	var created bool
+	var matches []duplicateMatch
+	err := withTx(r.Context(), db, nil, func(tx *sql.Tx) error {
+		itemID := 0
+		var in itemInput
+		current, err := scanItem(tx.QueryRow("SELECT "+itemColumns+" FROM sneakers WHERE sku = $1 AND deleted_at IS NULL FOR UPDATE", sku))
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return err
+		default:
+			itemID, in = current.ID, current.input()
+		}
+
+		for n, name := range header {
+			if n >= len(row) || name == "" {
+				continue
+			}
+			if v := strings.TrimSpace(row[n]); v != "" {
+				if code := importColumns[name](&in, v); code != "" {
+					return &apiError{http.StatusUnprocessableEntity, code}
+				}
+			}
+		}
+		if code := in.validate(); code != "" {
+			return &apiError{http.StatusUnprocessableEntity, code}
+		}
+
+		created = itemID == 0
+		if created {
+			if itemID, err = insertItem(r.Context(), tx, in); err != nil {
+				return err
+			}
+			matches, err = flagDuplicates(r.Context(), tx, itemID)
+			return err
+		}
+		return saveItem(r.Context(), tx, itemID, in)
+	})
+	return created, matches, err
+}
+
+// readImportFile returns the uploaded catalog file, sent either as the body
+// or as the "file" field of a multipart form.
+func readImportFile(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes+1<<20)
+	var body io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, &apiError{http.StatusRequestEntityTooLarge, errCodeImportTooLarge}
+			}
+			return nil, &apiError{http.StatusBadRequest, errCodeInvalidBody}
+		}
+		defer file.Close()
+		body = file
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxImportBytes+1))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || len(data) > maxImportBytes {
+		return nil, &apiError{http.StatusRequestEntityTooLarge, errCodeImportTooLarge}
+	}
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, errCodeInvalidBody}
+	}
+	return data, nil
+}
+
+// parseImportFile returns the rows of a CSV or XLSX file. XLSX files are zip
+// archives and recognized by their signature.
+func parseImportFile(data []byte) ([][]string, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		rows, err := readXLSX(data)
+		if err != nil {
+			return nil, &apiError{http.StatusUnprocessableEntity, errCodeInvalidImportFile}
+		}
+		return rows, nil
+	}
+
+	cr := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	cr.FieldsPerRecord = -1
+	// Spreadsheets exported with a European locale separate fields with semicolons
+	if line, _, _ := bytes.Cut(data, []byte("\n")); bytes.Count(line, []byte(";")) > bytes.Count(line, []byte(",")) {
+		cr.Comma = ';'
+	}
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, &apiError{http.StatusUnprocessableEntity, errCodeInvalidImportFile}
+	}
+	return rows, nil
+}
+
+// readXLSX returns the cell values of the first worksheet of an XLSX file,
+// with blank rows and cells left in so positions match the spreadsheet.
+func readXLSX(data []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	readPart := func(name string, v any) error {
+		f, err := zr.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return xml.NewDecoder(io.LimitReader(f, 8*maxImportBytes)).Decode(v)
+	}
+
+	// Text cells refer to the shared string table by index
+	var sst struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	// Workbooks without text have no shared string table
+	if err := readPart("xl/sharedStrings.xml", &sst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	shared := make([]string, len(sst.Items))
+	for n, si := range sst.Items {
+		shared[n] = si.Text
+		for _, run := range si.Runs {
+			shared[n] += run.Text
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Index int `xml:"r,attr"`
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := readPart("xl/worksheets/sheet1.xml", &sheet); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, sr := range sheet.Rows {
+		if sr.Index > maxImportRows+1 {
+			return nil, fmt.Errorf("xlsx: row %d out of range", sr.Index)
+		}
+		for sr.Index > len(rows)+1 {
+			rows = append(rows, nil)
+		}
+		var row []string
+		for _, c := range sr.Cells {
+			col := len(row)
+			if c.Ref != "" {
+				if col, err = xlsxColumn(c.Ref); err != nil {
+					return nil, err
+				}
+			}
+			for col > len(row) {
+				row = append(row, "")
+			}
+			v := c.Value
+			switch c.Type {
+			case "s":
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 || n >= len(shared) {
+					return nil, fmt.Errorf("xlsx: cell %s: bad shared string %q", c.Ref, v)
+				}
+				v = shared[n]
+			case "inlineStr":
+				v = c.Inline
+			}
+			row = append(row, v)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// xlsxColumn returns the zero-based column of a cell reference like "AB12".
+func xlsxColumn(ref string) (int, error) {
+	col := 0
+	for i, c := range ref {
+		if c < 'A' || c > 'Z' {
+			if i == 0 || col > 16384 {
+				break
+			}
+			return col - 1, nil
+		}
+		col = col*26 + int(c-'A'+1)
+	}
+	return 0, fmt.Errorf("xlsx: bad cell reference %q", ref)
+}
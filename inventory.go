@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// outOfStockError is returned when a decrement would drive stock negative.
+// Checkout reports it to the customer instead of failing with a 500.
+type outOfStockError struct {
+	ItemID    int
+	VariantID *int
+	Requested int
+}
+
+func (e *outOfStockError) Error() string {
+	if e.VariantID != nil {
+		return fmt.Sprintf("item %d size %d: fewer than %d in stock", e.ItemID, *e.VariantID, e.Requested)
+	}
+	return fmt.Sprintf("item %d: fewer than %d in stock", e.ItemID, e.Requested)
+}
+
+// DecrementStock atomically takes qty units of an item, or of one of its
+// sizes when variantID is set, out of stock. It must run on the Store of the
+// order transaction (see Store.WithTx) so the decrement commits or rolls back
+// with the order and is retried with it after a deadlock. The conditional
+// update makes concurrent checkouts safe without explicit locking: whichever
+// commits second sees the already reduced stock.
+func (s Store) DecrementStock(ctx context.Context, itemID int, variantID *int, qty int) error {
+	var res sql.Result
+	var err error
+	if variantID != nil {
+		res, err = s.q.ExecContext(ctx, "UPDATE item_variants SET stock = stock - $3 WHERE id = $1 AND item_id = $2 AND stock >= $3", *variantID, itemID, qty)
+	} else {
+		res, err = s.q.ExecContext(ctx, "UPDATE sneakers SET stock = stock - $2 WHERE id = $1 AND stock >= $2", itemID, qty)
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &outOfStockError{ItemID: itemID, VariantID: variantID, Requested: qty}
+	}
+	if variantID != nil {
+		_, err = s.TouchItem(ctx, itemID)
+	}
+	return err
+}
+
+// IncrementStock puts qty units of an item, or of one of its sizes, back into
+// stock, e.g. when an order is cancelled or returned. It returns
+// sql.ErrNoRows when the item or size does not exist.
+func (s Store) IncrementStock(ctx context.Context, itemID int, variantID *int, qty int) error {
+	var res sql.Result
+	var err error
+	if variantID != nil {
+		res, err = s.q.ExecContext(ctx, "UPDATE item_variants SET stock = stock + $3 WHERE id = $1 AND item_id = $2", *variantID, itemID, qty)
+	} else {
+		res, err = s.q.ExecContext(ctx, "UPDATE sneakers SET stock = stock + $2 WHERE id = $1", itemID, qty)
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	if variantID != nil {
+		_, err = s.TouchItem(ctx, itemID)
+	}
+	return err
+}
+
+// adjustStock serves the internal endpoints checkout uses to reserve stock
+// for an order (decrement) and to put it back (increment). The lines of a
+// request are applied all or nothing: a line without enough stock answers
+// 409 and leaves every stock unchanged.
+func adjustStock(db *sql.DB, increment bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data struct {
+			Lines []cartLine `json:"lines"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if len(data.Lines) == 0 || len(data.Lines) > maxCartLines {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidCart)
+			return
+		}
+		for _, l := range data.Lines {
+			if l.ItemID <= 0 || l.Quantity < 1 {
+				writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidCart)
+				return
+			}
+		}
+
+		err := NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			for _, l := range data.Lines {
+				var err error
+				if increment {
+					err = tx.IncrementStock(r.Context(), l.ItemID, l.VariantID, l.Quantity)
+				} else {
+					err = tx.DecrementStock(r.Context(), l.ItemID, l.VariantID, l.Quantity)
+				}
+				var outOfStock *outOfStockError
+				switch {
+				case errors.As(err, &outOfStock):
+					return &apiError{http.StatusConflict, errCodeOutOfStock}
+				case errors.Is(err, sql.ErrNoRows) && l.VariantID != nil:
+					return &apiError{http.StatusUnprocessableEntity, errCodeVariantNotFound}
+				case errors.Is(err, sql.ErrNoRows):
+					return &apiError{http.StatusUnprocessableEntity, errCodeItemNotFound}
+				case err != nil:
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// itemStock is the SQL expression of a sneaker's stock: the total of its
+// sizes for sneakers sold in sizes, whose own stock column is unused.
+const itemStock = "coalesce((SELECT sum(v.stock) FROM item_variants v WHERE v.item_id = s.id), s.stock)"
+
+// getLowStockItems lists the sneakers whose stock is at or below their
+// low_stock_threshold, lowest stock first, along with when they last dropped
+// there.
+func getLowStockItems(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query(`
+        SELECT id, title, sku, stock, low_stock_threshold, low_since
+        FROM (
+            SELECT s.id, s.title, s.sku, ` + itemStock + ` AS stock, s.low_stock_threshold,
+                   (SELECT max(a.created_at) FROM stock_alerts a WHERE a.item_id = s.id) AS low_since
+            FROM sneakers s
+            WHERE s.deleted_at IS NULL AND s.low_stock_threshold IS NOT NULL
+        ) s
+        WHERE stock <= low_stock_threshold
+        ORDER BY stock, id`)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type lowStockItem struct {
+			ID                int        `json:"id"`
+			Title             string     `json:"title"`
+			SKU               *string    `json:"sku"`
+			Stock             int        `json:"stock"`
+			LowStockThreshold int        `json:"low_stock_threshold"`
+			LowSince          *time.Time `json:"low_since"`
+		}
+		items := []lowStockItem{}
+		for rows.Next() {
+			var i lowStockItem
+			if err := rows.Scan(&i.ID, &i.Title, &i.SKU, &i.Stock, &i.LowStockThreshold, &i.LowSince); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			items = append(items, i)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
@@ -0,0 +1,997 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// item is a sneaker as returned by the catalog endpoints.
+type item struct {
+	ID                int             `json:"id"`
+	Title             string          `json:"title"`
+	Slug              string          `json:"slug"`
+	BrandID           *int            `json:"brand_id"`
+	Brand             *string         `json:"brand"`
+	ModelID           *int            `json:"model_id"`
+	CategoryID        *int            `json:"category_id"`
+	SKU               *string         `json:"sku"`
+	Barcode           *string         `json:"barcode"`
+	Price             Money           `json:"price"`
+	ImageURL          string          `json:"image_url"`
+	IsFavorite        bool            `json:"is_favorite"`
+	FavoriteID        *int            `json:"favorite_id"`
+	IsAdded           bool            `json:"is_added"`
+	Stock             int             `json:"stock"`
+	Description       string          `json:"description"`
+	Materials         []string        `json:"materials"`
+	ReleaseYear       *int            `json:"release_year"`
+	StyleCode         *string         `json:"style_code"`
+	StyleGroup        *string         `json:"style_group"`
+	WeightGrams       *int            `json:"weight_grams"`
+	Attributes        json.RawMessage `json:"attributes"`
+	LowStockThreshold *int            `json:"low_stock_threshold"`
+	Status            string          `json:"status"`
+	PublishAt         *time.Time      `json:"publish_at"`
+	MemberAccessAt    *time.Time      `json:"member_access_at"`
+	ReleaseAt         *time.Time      `json:"release_at"`
+	Markets           []string        `json:"markets"`
+	Purchasable       bool            `json:"purchasable"`
+	Tags              []string        `json:"tags"`
+	FavoritesCount    int             `json:"favorites_count"`
+	Images            []itemImage     `json:"images"`
+	Variants          []variant       `json:"variants,omitempty"`
+
+	// modifiedAt is when the item's representation last changed; see
+	// itemModified.
+	modifiedAt time.Time
+}
+
+// itemColumns is the select list matching scanItem, with purchasable as
+// for the public.
+var itemColumns = itemColumnsFor(false)
+
+// itemColumnsFor is itemColumns with purchasable as for a caller who may be
+// a member with early access; see itemOnSale.
+func itemColumnsFor(member bool) string {
+	return `id, title, coalesce(slug, ''), brand_id, (SELECT b.name FROM brands b WHERE b.id = sneakers.brand_id), model_id, category_id, sku, barcode, price, imageUrl, isFavorite, favoriteId, isAdded, stock,
+	description, materials, release_year, style_code, style_group, weight_grams, attributes, low_stock_threshold, status, publish_at, member_access_at,
+	release_at, markets, ` + itemOnSale("", member) + `,
+	ARRAY(SELECT t.name FROM item_tags it JOIN tags t ON t.id = it.tag_id WHERE it.item_id = sneakers.id ORDER BY t.name),
+	` + favoritesCount + `,
+	` + itemImagesColumn + `,
+	` + itemModified
+}
+
+// itemModified is the SQL expression of when a sneaker's representation last
+// changed, for Last-Modified: its updated_at, or later when its favorites
+// count changed or one of its scheduled times passed, as neither touches
+// updated_at.
+const itemModified = `greatest(updated_at,
+	(SELECT c.changed_at FROM item_favorites_changes c WHERE c.item_id = sneakers.id),
+	CASE WHEN publish_at <= now() THEN publish_at END,
+	CASE WHEN member_access_at <= now() THEN member_access_at END,
+	CASE WHEN release_at <= now() THEN release_at END)`
+
+// catalogModified returns when the catalog as a whole last changed: the
+// latest logged write of any sneaker, including deletions, or the latest
+// scheduled time that passed. Lists take it into account, since a sneaker
+// leaving a list leaves no row behind to date the change.
+func catalogModified(db *sql.DB) (time.Time, error) {
+	var t sql.NullTime
+	err := db.QueryRow(`
+    SELECT greatest(
+        (SELECT max(changed_at) FROM item_changes),
+        (SELECT max(publish_at) FROM sneakers WHERE publish_at <= now()),
+        (SELECT max(member_access_at) FROM sneakers WHERE member_access_at <= now()),
+        (SELECT max(release_at) FROM sneakers WHERE release_at <= now()))`).Scan(&t)
+	return t.Time, err
+}
+
+// favoritesCount is the SQL expression of the number of times a sneaker has
+// been favorited, as a measure of demand.
+const favoritesCount = "(SELECT count(*) FROM favorite f WHERE f.item_id = sneakers.id)"
+
+// itemSorts maps the sortBy values of GET /items to their ORDER BY clause.
+var itemSorts = map[string]string{
+	"title":      "title, id",
+	"-title":     "title DESC, id",
+	"price":      "price, id",
+	"-price":     "price DESC, id",
+	"newest":     "created_at DESC, id DESC",
+	"popularity": popularityOrder,
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanItem(row rowScanner) (item, error) {
+	var i item
+	var attributes, images []byte
+	err := row.Scan(&i.ID, &i.Title, &i.Slug, &i.BrandID, &i.Brand, &i.ModelID, &i.CategoryID, &i.SKU, &i.Barcode, &i.Price, &i.ImageURL, &i.IsFavorite, &i.FavoriteID, &i.IsAdded, &i.Stock,
+		&i.Description, pq.Array(&i.Materials), &i.ReleaseYear, &i.StyleCode, &i.StyleGroup, &i.WeightGrams, &attributes, &i.LowStockThreshold, &i.Status, &i.PublishAt, &i.MemberAccessAt,
+		&i.ReleaseAt, pq.Array(&i.Markets), &i.Purchasable, pq.Array(&i.Tags), &i.FavoritesCount, &images, &i.modifiedAt)
+	if err != nil {
+		return i, err
+	}
+	i.Attributes = attributes
+	return i, json.Unmarshal(images, &i.Images)
+}
+
+// itemQuery accumulates the WHERE conditions of a catalog query together with
+// their positional arguments.
+type itemQuery struct {
+	conditions []string
+	args       []any
+	// member includes the sneakers members have early access to.
+	member bool
+}
+
+// where adds a condition. Each %s in cond is replaced with the placeholder of
+// the corresponding argument.
+func (q *itemQuery) where(cond string, args ...any) {
+	placeholders := make([]any, len(args))
+	for i, arg := range args {
+		placeholders[i] = q.arg(arg)
+	}
+	q.conditions = append(q.conditions, fmt.Sprintf(cond, placeholders...))
+}
+
+// arg adds an argument and returns its placeholder, for expressions outside
+// the WHERE clause such as an ORDER BY.
+func (q *itemQuery) arg(v any) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func (q itemQuery) clone() itemQuery {
+	return itemQuery{conditions: slices.Clone(q.conditions), args: slices.Clone(q.args), member: q.member}
+}
+
+// Publication states of a sneaker. Only published sneakers whose publish_at,
+// if any, has passed are in the public catalog; a published sneaker with a
+// future publish_at is scheduled.
+const (
+	itemDraft     = "draft"
+	itemPublished = "published"
+	itemArchived  = "archived"
+)
+
+var itemStatuses = map[string]bool{itemDraft: true, itemPublished: true, itemArchived: true}
+
+// itemVisible returns the SQL condition selecting the sneakers of the public
+// catalog: not deleted, published and past their publication time. alias
+// qualifies the columns when sneakers is joined with other tables.
+func itemVisible(alias string) string {
+	return itemVisibleTo(alias, false)
+}
+
+// itemVisibleTo is itemVisible for a caller who may be a member with early
+// access: they also see the scheduled sneakers whose member_access_at has
+// passed.
+func itemVisibleTo(alias string, member bool) string {
+	if alias != "" {
+		alias += "."
+	}
+	published := "%[1]spublish_at IS NULL OR %[1]spublish_at <= now()"
+	if member {
+		published += " OR %[1]smember_access_at <= now()"
+	}
+	return fmt.Sprintf("%[1]sdeleted_at IS NULL AND %[1]sstatus = 'published' AND ("+published+")", alias)
+}
+
+// itemOnSale returns the SQL condition of the visible sneakers that can be
+// bought: those without a release_at or past it. member_access_at opens the
+// sale early to members with early access as well as showing them the
+// sneaker, so they can buy it from the earlier of the two times; a
+// member_access_at after release_at changes nothing.
+func itemOnSale(alias string, member bool) string {
+	if alias != "" {
+		alias += "."
+	}
+	onSale := "%[1]srelease_at IS NULL OR %[1]srelease_at <= now()"
+	if member {
+		onSale += " OR %[1]smember_access_at <= now()"
+	}
+	return fmt.Sprintf("("+onSale+")", alias)
+}
+
+// queryItems runs q against the catalog and returns the matching items along
+// with the time the result last changed: the latest of their modification
+// times and of catalogModified. Only visible items are returned.
+// A zero limit means no limit.
+func queryItems(db *sql.DB, q itemQuery, orderBy string, limit int) ([]item, time.Time, error) {
+	conditions := append([]string{itemVisibleTo("", q.member)}, q.conditions...)
+	query := "SELECT " + itemColumnsFor(q.member) + " FROM sneakers WHERE " + strings.Join(conditions, " AND ")
+	if orderBy != "" {
+		query += " ORDER BY " + orderBy
+	}
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := db.Query(query, q.args...)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var items []item
+	var lastModified time.Time
+	for rows.Next() {
+		i, err := scanItem(rows)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if i.modifiedAt.After(lastModified) {
+			lastModified = i.modifiedAt
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	catalog, err := catalogModified(db)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if catalog.After(lastModified) {
+		lastModified = catalog
+	}
+	return items, lastModified, nil
+}
+
+// itemInput is the writable part of an item, accepted by the admin endpoints.
+type itemInput struct {
+	Title             string          `json:"title"`
+	BrandID           *int            `json:"brand_id"`
+	ModelID           *int            `json:"model_id"`
+	CategoryID        *int            `json:"category_id"`
+	SKU               *string         `json:"sku"`
+	Barcode           *string         `json:"barcode"`
+	Price             Money           `json:"price"`
+	ImageURL          string          `json:"image_url"`
+	Stock             int             `json:"stock"`
+	Description       string          `json:"description"`
+	Materials         []string        `json:"materials"`
+	ReleaseYear       *int            `json:"release_year"`
+	StyleCode         *string         `json:"style_code"`
+	StyleGroup        *string         `json:"style_group"`
+	WeightGrams       *int            `json:"weight_grams"`
+	Attributes        json.RawMessage `json:"attributes"`
+	LowStockThreshold *int            `json:"low_stock_threshold"`
+	Status            string          `json:"status"`
+	PublishAt         *time.Time      `json:"publish_at"`
+	// MemberAccessAt lets members see and buy a scheduled sneaker early.
+	MemberAccessAt *time.Time `json:"member_access_at"`
+	// ReleaseAt is when the sneaker goes on sale; before that it can be seen
+	// but not bought, except by members once MemberAccessAt has passed.
+	ReleaseAt *time.Time `json:"release_at"`
+	// Markets restricts the sneaker to these countries, for staggered
+	// launches; without markets it is sold everywhere.
+	Markets []string `json:"markets"`
+}
+
+func (i item) input() itemInput {
+	return itemInput{
+		Title:             i.Title,
+		BrandID:           i.BrandID,
+		ModelID:           i.ModelID,
+		CategoryID:        i.CategoryID,
+		SKU:               i.SKU,
+		Barcode:           i.Barcode,
+		Price:             i.Price,
+		ImageURL:          i.ImageURL,
+		Stock:             i.Stock,
+		Description:       i.Description,
+		Materials:         i.Materials,
+		ReleaseYear:       i.ReleaseYear,
+		StyleCode:         i.StyleCode,
+		StyleGroup:        i.StyleGroup,
+		WeightGrams:       i.WeightGrams,
+		Attributes:        i.Attributes,
+		LowStockThreshold: i.LowStockThreshold,
+		Status:            i.Status,
+		PublishAt:         i.PublishAt,
+		MemberAccessAt:    i.MemberAccessAt,
+		ReleaseAt:         i.ReleaseAt,
+		Markets:           i.Markets,
+	}
+}
+
+// validate normalizes the input and returns the error code of the first
+// invalid field, or "" when the input is valid.
+func (in *itemInput) validate() string {
+	in.Title = strings.TrimSpace(in.Title)
+	in.ImageURL = strings.TrimSpace(in.ImageURL)
+	in.SKU = normalizeCode(in.SKU)
+	in.Barcode = normalizeCode(in.Barcode)
+	in.StyleGroup = normalizeCode(in.StyleGroup)
+	if in.Materials == nil {
+		in.Materials = []string{}
+	}
+	if len(in.Attributes) == 0 || string(in.Attributes) == "null" {
+		in.Attributes = json.RawMessage("{}")
+	}
+	if in.Status == "" {
+		in.Status = itemPublished
+	}
+	markets, marketsOK := normalizeMarkets(in.Markets)
+	in.Markets = markets
+
+	var attributes map[string]any
+	switch {
+	case in.Title == "":
+		return errCodeItemTitleRequired
+	case in.Price.Amount < 0, in.Price.Currency != shopCurrency:
+		return errCodeInvalidPrice
+	case in.Stock < 0, in.LowStockThreshold != nil && *in.LowStockThreshold < 0:
+		return errCodeInvalidStock
+	case in.ImageURL == "":
+		return errCodeItemImageRequired
+	case in.ReleaseYear != nil && (*in.ReleaseYear < 1900 || *in.ReleaseYear > 2100):
+		return errCodeInvalidReleaseYear
+	case in.WeightGrams != nil && *in.WeightGrams <= 0:
+		return errCodeInvalidWeight
+	case json.Unmarshal(in.Attributes, &attributes) != nil:
+		return errCodeInvalidAttributes
+	case in.Barcode != nil && !validGTIN(*in.Barcode):
+		return errCodeInvalidBarcode
+	case !itemStatuses[in.Status]:
+		return errCodeInvalidItemStatus
+	case in.MemberAccessAt != nil && (in.PublishAt == nil || !in.MemberAccessAt.Before(*in.PublishAt)):
+		return errCodeInvalidMemberAccess
+	case !marketsOK:
+		return errCodeInvalidMarkets
+	}
+	return ""
+}
+
+// foreignKeyCode maps a foreign key violation on sneakers to the error code
+// of the referenced entity that does not exist.
+func foreignKeyCode(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && strings.Contains(pqErr.Constraint, "category") {
+		return errCodeCategoryNotFound
+	}
+	if errors.As(err, &pqErr) && strings.Contains(pqErr.Constraint, "model") {
+		return errCodeModelNotFound
+	}
+	return errCodeBrandNotFound
+}
+
+// getItem returns a single sneaker with its gallery and sizes, for product
+// detail pages. is_favorite and favorite_id are the caller's own; anonymous
+// callers get the public body with neither set.
+func getItem(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		member, err := earlyAccess(w, r, db)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		i, err := scanItem(db.QueryRow("SELECT "+itemColumnsFor(member)+" FROM sneakers WHERE id = $1 AND "+itemVisibleTo("", member)+" AND "+itemInMarket("", "$2"),
+			itemID, requestMarket(w, r)))
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		// The legacy isFavorite and favoriteId columns are shared by every
+		// caller, so the favorite status comes from the caller's favorites,
+		// which keeps their response out of shared caches
+		owner := callerID(r)
+		w.Header().Add("Vary", "X-User-ID, X-Device-ID")
+		if owner != "" {
+			w.Header().Set("Cache-Control", cachePrivate.header())
+		}
+		if notModified(w, r, i.modifiedAt) || r.Method == http.MethodHead {
+			return
+		}
+		i.IsFavorite, i.FavoriteID = false, nil
+		if owner != "" {
+			err := db.QueryRow("SELECT id FROM favorite WHERE owner = $1 AND item_id = $2", owner, i.ID).Scan(&i.FavoriteID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				serverError(w, r, err)
+				return
+			}
+			i.IsFavorite = i.FavoriteID != nil
+		}
+
+		items := []item{i}
+		if err := embedVariants(db, items); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items[0])
+	}
+}
+
+// getColorways returns the other colorways of a sneaker's model, i.e. the
+// sneakers sharing its style_group, for the "other colors" widget.
+func getColorways(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		market := requestMarket(w, r)
+		var group sql.NullString
+		err = db.QueryRow("SELECT style_group FROM sneakers WHERE id = $1 AND "+itemVisible("")+" AND "+itemInMarket("", "$2"), itemID, market).Scan(&group)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		items := []item{}
+		if group.Valid {
+			var q itemQuery
+			q.where("style_group = %s AND id <> %s", group.String, itemID)
+			q.where(itemInMarket("", "%[1]s"), market)
+			if items, _, err = queryItems(db, q, "title, id", 0); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			if items == nil {
+				items = []item{}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+// getRelatedItems suggests sneakers similar to one, for "you may also like".
+// Each shared tag counts three points, the same brand two and the same
+// category one; the best scores come first. ?limit= defaults to the
+// related_limit of the runtime config.
+func getRelatedItems(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+		limit, ok := queryLimit(r, currentConfig().RelatedLimit, 50)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+
+		market := requestMarket(w, r)
+		i, err := scanItem(db.QueryRow("SELECT "+itemColumns+" FROM sneakers WHERE id = $1 AND "+itemVisible("")+" AND "+itemInMarket("", "$2"), itemID, market))
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		var q itemQuery
+		q.where(itemInMarket("", "%[1]s"), market)
+		score := fmt.Sprintf(`3 * (SELECT count(*) FROM item_tags it JOIN tags t ON t.id = it.tag_id WHERE it.item_id = sneakers.id AND t.name = ANY(%s))
+			+ CASE WHEN brand_id = %s THEN 2 ELSE 0 END + CASE WHEN category_id = %s THEN 1 ELSE 0 END`,
+			q.arg(pq.Array(i.Tags)), q.arg(i.BrandID), q.arg(i.CategoryID))
+		q.where("id <> %s", i.ID)
+		q.where(score + " > 0")
+		items, lastModified, err := queryItems(db, q, score+" DESC, id", limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if items == nil {
+			items = []item{}
+		}
+		// The suggestions also change with the sneaker's own brand, category and tags
+		if i.modifiedAt.After(lastModified) {
+			lastModified = i.modifiedAt
+		}
+		if notModified(w, r, lastModified) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+// getUpcomingItems lists the sneakers that can be seen but not bought yet,
+// soonest release first, for drop countdowns. They go on sale at release_at,
+// or for members at member_access_at, without anyone toggling them.
+func getUpcomingItems(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := queryLimit(r, 50, 200)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+		member, err := earlyAccess(w, r, db)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		q := itemQuery{member: member}
+		q.where("NOT " + itemOnSale("", member))
+		q.where(itemInMarket("", "%[1]s"), requestMarket(w, r))
+		items, lastModified, err := queryItems(db, q, "release_at, id", limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if items == nil {
+			items = []item{}
+		}
+		if notModified(w, r, lastModified) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+// insertItem adds a sneaker from validated input, with its slug, and returns
+// its ID. Unknown references and duplicate codes are returned as apiErrors.
+func insertItem(ctx context.Context, tx *sql.Tx, in itemInput) (int, error) {
+	var itemID int
+	err := tx.QueryRowContext(ctx, `
+    INSERT INTO sneakers (title, price, imageUrl, stock, description, materials, release_year, style_code, weight_grams, attributes, brand_id, category_id, sku, barcode, style_group, low_stock_threshold, status, publish_at, member_access_at, release_at, model_id, markets)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+    RETURNING id`,
+		in.Title, in.Price, in.ImageURL, in.Stock, in.Description, pq.Array(in.Materials), in.ReleaseYear, in.StyleCode, in.WeightGrams, []byte(in.Attributes), in.BrandID, in.CategoryID, in.SKU, in.Barcode, in.StyleGroup, in.LowStockThreshold, in.Status, in.PublishAt, in.MemberAccessAt, in.ReleaseAt, in.ModelID, pq.Array(in.Markets)).Scan(&itemID)
+	if isForeignKeyViolation(err) {
+		return 0, &apiError{http.StatusUnprocessableEntity, foreignKeyCode(err)}
+	}
+	if isUniqueViolation(err) {
+		return 0, &apiError{http.StatusConflict, uniqueCode(err)}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return itemID, setItemSlug(ctx, tx, itemID, in.Title)
+}
+
+// saveItem replaces the writable fields of a sneaker with validated input.
+// Deleted sneakers must be restored before they can be edited; they answer
+// 404 like unknown ones.
+func saveItem(ctx context.Context, tx *sql.Tx, itemID int, in itemInput) error {
+	res, err := tx.ExecContext(ctx, `
+    UPDATE sneakers
+    SET title = $2, price = $3, imageUrl = $4, stock = $5, description = $6, materials = $7,
+        release_year = $8, style_code = $9, weight_grams = $10, attributes = $11, brand_id = $12, category_id = $13,
+        sku = $14, barcode = $15, style_group = $16, low_stock_threshold = $17, status = $18, publish_at = $19,
+        member_access_at = $20, release_at = $21, model_id = $22, markets = $23
+    WHERE id = $1 AND deleted_at IS NULL`,
+		itemID, in.Title, in.Price, in.ImageURL, in.Stock, in.Description, pq.Array(in.Materials), in.ReleaseYear, in.StyleCode, in.WeightGrams, []byte(in.Attributes), in.BrandID, in.CategoryID, in.SKU, in.Barcode, in.StyleGroup, in.LowStockThreshold, in.Status, in.PublishAt, in.MemberAccessAt, in.ReleaseAt, in.ModelID, pq.Array(in.Markets))
+	if isForeignKeyViolation(err) {
+		return &apiError{http.StatusUnprocessableEntity, foreignKeyCode(err)}
+	}
+	if isUniqueViolation(err) {
+		return &apiError{http.StatusConflict, uniqueCode(err)}
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &apiError{http.StatusNotFound, errCodeItemNotFound}
+	}
+	return setItemSlug(ctx, tx, itemID, in.Title)
+}
+
+// createItem adds a sneaker to the catalog. A sneaker that likely duplicates
+// others, by SKU, style code or title, is rejected with 409 and the matches
+// unless ?force=true is set; it is then created and flagged for review.
+func createItem(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		force := r.URL.Query().Get("force") == "true"
+		var in itemInput
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if code := in.validate(); code != "" {
+			writeError(w, r, http.StatusUnprocessableEntity, code)
+			return
+		}
+
+		var i item
+		err := withTx(r.Context(), db, nil, func(tx *sql.Tx) error {
+			itemID, err := insertItem(r.Context(), tx, in)
+			if err != nil {
+				return err
+			}
+			matches, err := flagDuplicates(r.Context(), tx, itemID)
+			if err != nil {
+				return err
+			}
+			if len(matches) > 0 && !force {
+				return &likelyDuplicateError{matches}
+			}
+			// Selected separately so the gallery includes the primary image added by the trigger
+			i, err = scanItem(tx.QueryRow("SELECT "+itemColumns+" FROM sneakers WHERE id = $1", itemID))
+			return err
+		})
+		var dup *likelyDuplicateError
+		if errors.As(err, &dup) {
+			lang := negotiateLanguage(r)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Language", lang)
+			w.Header().Add("Vary", "Accept-Language")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(struct {
+				Code       string           `json:"code"`
+				Message    string           `json:"message"`
+				Duplicates []duplicateMatch `json:"duplicates"`
+			}{errCodeLikelyDuplicate, localize(lang, errCodeLikelyDuplicate), dup.matches})
+			return
+		}
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/items/%d", i.ID))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(i)
+	}
+}
+
+// updateItem serves both PUT, which replaces the item, and PATCH, which
+// applies a JSON merge patch: only the fields present in the body change and
+// null clears optional fields.
+func updateItem(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		// The body is read up front: a PATCH is decoded onto the stored row, which
+		// happens again if the transaction is retried.
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+
+		var i item
+		err = withTx(r.Context(), db, nil, func(tx *sql.Tx) error {
+			var in itemInput
+			if r.Method == http.MethodPatch {
+				current, err := scanItem(tx.QueryRow("SELECT "+itemColumns+" FROM sneakers WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", itemID))
+				if errors.Is(err, sql.ErrNoRows) {
+					return &apiError{http.StatusNotFound, errCodeItemNotFound}
+				}
+				if err != nil {
+					return err
+				}
+				in = current.input()
+			}
+			if err := json.Unmarshal(body, &in); err != nil {
+				return &apiError{http.StatusBadRequest, errCodeInvalidBody}
+			}
+			if code := in.validate(); code != "" {
+				return &apiError{http.StatusUnprocessableEntity, code}
+			}
+
+			if err := saveItem(r.Context(), tx, itemID, in); err != nil {
+				return err
+			}
+
+			// Selected separately so the gallery reflects the image_url change made by the trigger
+			i, err = scanItem(tx.QueryRow("SELECT "+itemColumns+" FROM sneakers WHERE id = $1", itemID))
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(i)
+	}
+}
+
+// maxItemBatch is the largest number of updates in a batch.
+const maxItemBatch = 500
+
+// errBatchFailed rolls back a batch of which an update failed.
+var errBatchFailed = errors.New("batch failed")
+
+// patchItems applies a batch of partial updates in one transaction, for
+// merchandising tools. Each update is a PATCH of one sneaker with its id,
+// and may adjust the stock by a delta instead of setting it, e.g.
+//
+//	[{"id": 12, "price": {"amount": 9999, "currency": "EUR"}}, {"id": 13, "stock_delta": -2}, {"id": 14, "status": "archived"}]
+//
+// The response has a result per update, in order. Either every update is
+// applied, answering 200 with the updated sneakers, or none is, answering
+// 422 with the status and error code of each update that failed; the valid
+// updates then have status 424 as they were rolled back with the others.
+func patchItems(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var updates []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if len(updates) == 0 || len(updates) > maxItemBatch {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidItemBatch)
+			return
+		}
+
+		type result struct {
+			ID      int    `json:"id"`
+			Status  int    `json:"status"`
+			Item    *item  `json:"item,omitempty"`
+			Code    string `json:"code,omitempty"`
+			Message string `json:"message,omitempty"`
+		}
+		var results []result
+		lang := negotiateLanguage(r)
+
+		err := withTx(r.Context(), db, nil, func(tx *sql.Tx) error {
+			results = make([]result, len(updates))
+			failed := false
+			for n, raw := range updates {
+				// A savepoint per update keeps the transaction usable after a
+				// constraint violation, so every failure is reported at once.
+				if _, err := tx.ExecContext(r.Context(), "SAVEPOINT item_update"); err != nil {
+					return err
+				}
+				var u struct {
+					ID         int  `json:"id"`
+					StockDelta *int `json:"stock_delta"`
+				}
+				err := json.Unmarshal(raw, &u)
+				if err != nil {
+					err = &apiError{http.StatusBadRequest, errCodeInvalidBody}
+				} else if u.ID <= 0 {
+					err = &apiError{http.StatusBadRequest, errCodeInvalidItemID}
+				}
+				var i item
+				if err == nil {
+					i, err = patchItem(r.Context(), tx, u.ID, raw, u.StockDelta)
+				}
+				var apiErr *apiError
+				if errors.As(err, &apiErr) {
+					if _, err := tx.ExecContext(r.Context(), "ROLLBACK TO SAVEPOINT item_update"); err != nil {
+						return err
+					}
+					results[n] = result{ID: u.ID, Status: apiErr.status, Code: apiErr.code, Message: localize(lang, apiErr.code)}
+					failed = true
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				results[n] = result{ID: u.ID, Status: http.StatusOK, Item: &i}
+			}
+			if failed {
+				return errBatchFailed
+			}
+			return nil
+		})
+		status := http.StatusOK
+		if errors.Is(err, errBatchFailed) {
+			for n := range results {
+				if results[n].Item != nil {
+					results[n].Status, results[n].Item = http.StatusFailedDependency, nil
+				}
+			}
+			status = http.StatusUnprocessableEntity
+		} else if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+// patchItem applies the partial update body, and the stock adjustment
+// stockDelta when set, to a sneaker.
+func patchItem(ctx context.Context, tx *sql.Tx, itemID int, body []byte, stockDelta *int) (item, error) {
+	current, err := scanItem(tx.QueryRowContext(ctx, "SELECT "+itemColumns+" FROM sneakers WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", itemID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return item{}, &apiError{http.StatusNotFound, errCodeItemNotFound}
+	}
+	if err != nil {
+		return item{}, err
+	}
+	in := current.input()
+	if err := json.Unmarshal(body, &in); err != nil {
+		return item{}, &apiError{http.StatusBadRequest, errCodeInvalidBody}
+	}
+	if stockDelta != nil {
+		in.Stock += *stockDelta
+	}
+	if code := in.validate(); code != "" {
+		return item{}, &apiError{http.StatusUnprocessableEntity, code}
+	}
+	if err := saveItem(ctx, tx, itemID, in); err != nil {
+		return item{}, err
+	}
+	return scanItem(tx.QueryRowContext(ctx, "SELECT "+itemColumns+" FROM sneakers WHERE id = $1", itemID))
+}
+
+// deleteItem soft deletes a sneaker: it disappears from the catalog but can be
+// restored until the retention job purges it.
+func deleteItem(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		res, err := db.Exec("UPDATE sneakers SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL", itemID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getDeletedItems lists the soft deleted sneakers, most recently deleted
+// first.
+func getDeletedItems(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query("SELECT " + itemColumns + ", deleted_at FROM sneakers WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id")
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type deletedItem struct {
+			item
+			DeletedAt time.Time `json:"deleted_at"`
+		}
+		items := []deletedItem{}
+		for rows.Next() {
+			var d deletedItem
+			var err error
+			d.item, err = scanItem(scannerFunc(func(dest ...any) error {
+				return rows.Scan(append(dest, &d.DeletedAt)...)
+			}))
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			items = append(items, d)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+// getAdminItems lists the sneakers in a publication state, ?status= draft,
+// scheduled, published (live) or archived, for preparing launches. Without
+// ?status= it lists every sneaker that is not live yet, soonest first.
+func getAdminItems(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var cond string
+		switch r.URL.Query().Get("status") {
+		case "":
+			cond = "(status = 'draft' OR status = 'published' AND publish_at > now())"
+		case itemDraft:
+			cond = "status = 'draft'"
+		case "scheduled":
+			cond = "status = 'published' AND publish_at > now()"
+		case itemPublished:
+			cond = "status = 'published' AND (publish_at IS NULL OR publish_at <= now())"
+		case itemArchived:
+			cond = "status = 'archived'"
+		default:
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemStatus)
+			return
+		}
+
+		rows, err := db.Query("SELECT " + itemColumns + " FROM sneakers WHERE deleted_at IS NULL AND " + cond + " ORDER BY publish_at NULLS LAST, id")
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		items := []item{}
+		for rows.Next() {
+			i, err := scanItem(rows)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			items = append(items, i)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+// scannerFunc adapts a function to rowScanner.
+type scannerFunc func(dest ...any) error
+
+func (f scannerFunc) Scan(dest ...any) error { return f(dest...) }
+
+// restoreItem brings a soft deleted sneaker back into the catalog.
+func restoreItem(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		i, err := scanItem(db.QueryRow(`
+        UPDATE sneakers SET deleted_at = NULL
+        WHERE id = $1 AND deleted_at IS NOT NULL
+        RETURNING `+itemColumns, itemID))
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(i)
+	}
+}
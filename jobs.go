@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runEvery runs fn immediately and then every interval until ctx is done.
+// Runs of the same job never overlap; failures are logged and retried on the
+// next tick.
+func runEvery(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		if err := fn(ctx); err != nil {
+			log.Printf("job %s: %v", name, err)
+		} else {
+			logAt(levelInfo, "job %s: done in %s", name, time.Since(start).Round(time.Millisecond))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
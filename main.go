@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 const (
@@ -20,16 +24,24 @@ const (
 	dbname   = "mydatabase"
 )
 
-func enableCORS(next http.Handler) http.Handler {
+func enableCORS(router *mux.Router, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set headers
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Allow any domain, adjust if you need more restrictive settings
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-
-		// If it's a preflight OPTIONS request, send a simple response and stop processing
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Accept-Language, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-User-ID, X-Device-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, Deprecation, Sunset, Link, X-Total-Count, X-Search-ID, X-Search-Fallback, X-Suggested-Query")
+
+		// Answer OPTIONS (including CORS preflight) with the methods actually routed for this path
+		if r.Method == http.MethodOptions {
+			methods := allowedMethods(router, r)
+			if len(methods) == 0 {
+				writeError(w, r, http.StatusNotFound, errCodeNotFound)
+				return
+			}
+			allow := strings.Join(append(methods, http.MethodOptions), ", ")
+			w.Header().Set("Allow", allow)
+			w.Header().Set("Access-Control-Allow-Methods", allow)
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
@@ -38,17 +50,48 @@ func enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// allowedMethods returns the methods that have a route for the request's path.
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var methods []string
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		req := r.Clone(r.Context())
+		req.Method = method
+
+		var match mux.RouteMatch
+		if router.Match(req, &match) && match.MatchErr == nil {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
 func main() {
-	// Database connection string
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
+	if adminRequiresMTLS && adminListenAddr == "" {
+		log.Fatal("ADMIN_REQUIRE_MTLS needs ADMIN_LISTEN_ADDR: no admin request could be authenticated")
+	}
 
-	// Connect to the database
-	db, err := sql.Open("postgres", psqlInfo)
+	// Connect to the database; the password comes from the secrets provider
+	secrets, err := newSecretsProvider()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if media, err = newMediaStore(secrets); err != nil {
+		log.Fatal(err)
+	}
+	if pii, err = newPIICipher(context.Background(), secrets); err != nil {
+		log.Fatal(err)
+	}
+	if sms, err = newSMSSender(secrets); err != nil {
+		log.Fatal(err)
+	}
+	marketPrices, err := newMarketPriceProvider(secrets)
 	if err != nil {
 		log.Fatal(err)
 	}
+	db := sql.OpenDB(dbConnector{secrets})
 	defer db.Close()
+	// Recycle connections so they pick up a rotated password
+	db.SetConnMaxLifetime(getenvDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute))
 
 	// Test the connection
 	err = db.Ping()
@@ -56,32 +99,266 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Bring the schema up to date
+	if err := migrate(db); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadDictionary(context.Background(), db); err != nil {
+		log.Fatal(err)
+	}
+
+	scorer = affinityScorer{db}
+	notify = consentNotifier{NewStore(db), inboxNotifier{db}}
+	if sms != nil {
+		notify = consentNotifier{NewStore(db), smsNotifier{NewStore(db), sms, inboxNotifier{db}}}
+	}
+
 	// Router configuration
 	router := mux.NewRouter()
+	router.Use(trackSLO, deprecationSignals)
 
-	handler := enableCORS(router)
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, errCodeNotFound)
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", strings.Join(append(allowedMethods(router, r), http.MethodOptions), ", "))
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed)
+	})
+
+	handler := enableCORS(router, cacheControl(router))
 
 	// Define routes
-	router.HandleFunc("/favorites", getFavorites(db)).Methods("GET")
+	router.HandleFunc("/cart/preview", previewCart(db)).Methods("POST")
+	router.HandleFunc("/favorites", getFavorites(db)).Methods("GET", "HEAD")
 	router.HandleFunc("/favorites", postFavorite(db)).Methods("POST")
-	router.HandleFunc("/favorites/{favoriteId}", deleteFavorite(db)).Methods("DELETE")
-	router.HandleFunc("/items", getItems(db)).Methods("GET")
+	router.HandleFunc("/favorites/order", reorderFavorites(db)).Methods("PATCH")
+	router.HandleFunc("/favorites/batch", postFavoritesBatch(db)).Methods("POST")
+	router.HandleFunc("/favorites/add-to-cart", addFavoritesToCart(db)).Methods("POST")
+	router.HandleFunc("/favorites/batch", deleteFavoritesBatch(db)).Methods("DELETE")
+	router.HandleFunc("/wishlists", getWishlists(db)).Methods("GET")
+	router.HandleFunc("/wishlists", putWishlist(db)).Methods("POST")
+	router.HandleFunc("/wishlists/{wishlistId:[0-9]+}", putWishlist(db)).Methods("PATCH")
+	router.HandleFunc("/wishlists/{wishlistId:[0-9]+}", deleteWishlist(db)).Methods("DELETE")
+	router.HandleFunc("/wishlists/{wishlistId:[0-9]+}/items", getWishlistItems(db)).Methods("GET")
+	router.HandleFunc("/wishlists/{wishlistId:[0-9]+}/items", postWishlistItem(db)).Methods("POST")
+	router.HandleFunc("/wishlists/{wishlistId:[0-9]+}/items/{itemId:[0-9]+}", deleteWishlistItem(db)).Methods("DELETE")
+	router.HandleFunc("/shared/wishlists/{token}", getSharedWishlist(db)).Methods("GET")
+	router.HandleFunc("/favorites/export", exportFavorites(db)).Methods("GET")
+	router.HandleFunc("/favorites/import", importFavorites(db)).Methods("POST")
+	router.HandleFunc("/favorites/{favoriteId:[0-9]+}", deleteFavorite(db)).Methods("DELETE")
+	router.HandleFunc("/favorites/item/{itemId:[0-9]+}", deleteFavoriteByItem(db)).Methods("DELETE")
+	router.HandleFunc("/favorites/{favoriteId:[0-9]+}/add-to-cart", addFavoriteToCart(db)).Methods("POST")
+	router.HandleFunc("/favorites/{favoriteId:[0-9]+}/alert", putPriceAlert(db)).Methods("PUT")
+	router.HandleFunc("/favorites/{favoriteId:[0-9]+}/alert", deletePriceAlert(db)).Methods("DELETE")
+	router.HandleFunc("/favorites/{favoriteId:[0-9]+}/restock-alert", putRestockAlert(db)).Methods("PUT")
+	router.HandleFunc("/items", getItems(db)).Methods("GET", "HEAD")
+	router.HandleFunc("/items/lookup", lookupItem(db)).Methods("GET")
+	router.HandleFunc("/items/changes", getItemChanges(db)).Methods("GET")
+	router.HandleFunc("/items/upcoming", getUpcomingItems(db)).Methods("GET")
+	router.HandleFunc("/items/slug/{slug}", getItemBySlug(db)).Methods("GET")
+	router.Handle("/items", requireAdmin(createItem(db))).Methods("POST")
+	router.HandleFunc("/items/{id:[0-9]+}", getItem(db)).Methods("GET", "HEAD")
+	router.Handle("/items/{id:[0-9]+}", requireAdmin(updateItem(db))).Methods("PUT", "PATCH")
+	router.Handle("/items/{id:[0-9]+}", requireAdmin(deleteItem(db))).Methods("DELETE")
+	router.HandleFunc("/items/{id:[0-9]+}/colorways", getColorways(db)).Methods("GET")
+	router.HandleFunc("/items/{id:[0-9]+}/related", getRelatedItems(db)).Methods("GET")
+	router.HandleFunc("/items/{id:[0-9]+}/view", postItemView(db)).Methods("POST")
+	router.HandleFunc("/items/{id:[0-9]+}/price-history", getPriceHistory(db)).Methods("GET")
+	router.HandleFunc("/items/{id:[0-9]+}/images", getItemImages(db)).Methods("GET")
+	router.Handle("/items/{id:[0-9]+}/images", requireAdmin(createItemImage(db))).Methods("POST")
+	router.Handle("/items/{id:[0-9]+}/images/{imageId:[0-9]+}", requireAdmin(updateItemImage(db))).Methods("PUT")
+	router.Handle("/items/{id:[0-9]+}/images/{imageId:[0-9]+}", requireAdmin(deleteItemImage(db))).Methods("DELETE")
+	router.HandleFunc("/items/{id:[0-9]+}/variants", getVariants(db)).Methods("GET")
+	router.Handle("/items/{id:[0-9]+}/variants", requireAdmin(createVariant(db))).Methods("POST")
+	router.Handle("/items/{id:[0-9]+}/variants/{variantId:[0-9]+}", requireAdmin(updateVariant(db))).Methods("PUT")
+	router.Handle("/items/{id:[0-9]+}/variants/{variantId:[0-9]+}", requireAdmin(deleteVariant(db))).Methods("DELETE")
+	router.HandleFunc("/me/consents", getConsents(db)).Methods("GET")
+	router.HandleFunc("/me/consents/{purpose}", putConsent(db)).Methods("PUT")
+	router.HandleFunc("/me/notifications", getNotifications(db)).Methods("GET")
+	router.HandleFunc("/me/alerts", getAlerts(db)).Methods("GET")
+	router.HandleFunc("/me/restock-alerts", getRestockAlerts(db)).Methods("GET")
+	router.HandleFunc("/me/restock-alerts/{alertId:[0-9]+}", deleteRestockAlert(db)).Methods("DELETE")
+	router.HandleFunc("/me/wallet", getWallet(db)).Methods("GET")
+	router.HandleFunc("/me/membership", getMembership(db)).Methods("GET")
+	router.HandleFunc("/me/phone", getPhone(db)).Methods("GET")
+	router.HandleFunc("/me/phone", postPhone(db)).Methods("POST")
+	router.HandleFunc("/me/phone", deletePhone(db)).Methods("DELETE")
+	router.HandleFunc("/me/phone/verify", verifyPhone(db)).Methods("POST")
+	router.HandleFunc("/me/searches", getSearchHistory(db)).Methods("GET")
+	router.HandleFunc("/me/searches", deleteSearchHistory(db)).Methods("DELETE")
+	router.HandleFunc("/me/searches/{searchId:[0-9]+}", deleteSearchHistoryEntry(db)).Methods("DELETE")
+	router.HandleFunc("/me/recently-viewed", getRecentlyViewed(db)).Methods("GET")
+	router.HandleFunc("/me/recently-viewed", deleteRecentlyViewed(db)).Methods("DELETE")
+	router.HandleFunc("/releases", getReleases(db)).Methods("GET")
+	router.HandleFunc("/releases/{releaseId:[0-9]+}", getRelease(db)).Methods("GET")
+	router.HandleFunc("/releases/{releaseId:[0-9]+}/status", getReleaseStatus(db)).Methods("GET")
+	router.HandleFunc("/releases/{releaseId:[0-9]+}/reminder", putReleaseReminder(db)).Methods("PUT")
+	router.HandleFunc("/releases/{releaseId:[0-9]+}/reminder", deleteReleaseReminder(db)).Methods("DELETE")
+	router.HandleFunc("/searches/{searchId:[0-9]+}/clicks", postSearchClick(db)).Methods("POST")
+	router.HandleFunc("/tags", getTagCloud(db)).Methods("GET")
+	router.HandleFunc("/tags/{slug}/items", getTagFeed(db)).Methods("GET")
+	router.HandleFunc("/brands", getBrands(db)).Methods("GET")
+	router.HandleFunc("/brands/{slug}", getBrand(db)).Methods("GET")
+	router.HandleFunc("/models", getModels(db)).Methods("GET")
+	router.HandleFunc("/models/{modelId:[0-9]+}", getModel(db)).Methods("GET")
+	router.HandleFunc("/models/{modelId:[0-9]+}/price-history", getModelPriceHistory(db)).Methods("GET")
+	router.HandleFunc("/models/{modelId:[0-9]+}/market-price", getMarketPrice(db)).Methods("GET")
+	router.HandleFunc("/models/{modelId:[0-9]+}/availability", getModelAvailability(db)).Methods("GET")
+	router.HandleFunc("/categories", getCategories(db)).Methods("GET")
+	router.Handle("/categories", requireAdmin(createCategory(db))).Methods("POST")
+	router.Handle("/categories/{categoryId:[0-9]+}", requireAdmin(updateCategory(db))).Methods("PUT")
+	router.Handle("/categories/{categoryId:[0-9]+}", requireAdmin(deleteCategory(db))).Methods("DELETE")
+	router.HandleFunc("/categories/{category}", getCategory(db)).Methods("GET")
+	router.HandleFunc("/events", postEvents(db)).Methods("POST")
+	router.HandleFunc("/feeds/new-arrivals.atom", getNewArrivalsFeed(db)).Methods("GET")
+	router.HandleFunc("/sitemap.xml", getSitemapIndex).Methods("GET")
+	if d, ok := media.(dirMedia); ok {
+		router.PathPrefix("/media/").Handler(d.Handler()).Methods("GET", "HEAD")
+	}
+	router.HandleFunc("/sitemaps/{n:[0-9]+}.xml", getSitemapChunk).Methods("GET")
+
+	// Admin routes
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(requireAdmin)
+	admin.NotFoundHandler = router.NotFoundHandler
+	admin.MethodNotAllowedHandler = router.MethodNotAllowedHandler
+	admin.HandleFunc("/tags", createTag(db)).Methods("POST")
+	admin.HandleFunc("/tags/{tagId}", renameTag(db)).Methods("PUT")
+	admin.HandleFunc("/tags/{tagId}", deleteTag(db)).Methods("DELETE")
+	admin.HandleFunc("/tags/{tagId}/markets", putTagMarkets(db)).Methods("PUT")
+	admin.HandleFunc("/items", getAdminItems(db)).Methods("GET")
+	admin.HandleFunc("/items", patchItems(db)).Methods("PATCH")
+	admin.HandleFunc("/items/import", importItems(db)).Methods("POST")
+	admin.HandleFunc("/items/deleted", getDeletedItems(db)).Methods("GET")
+	admin.HandleFunc("/items/low-stock", getLowStockItems(db)).Methods("GET")
+	admin.HandleFunc("/items/{id:[0-9]+}/restore", restoreItem(db)).Methods("POST")
+	admin.HandleFunc("/duplicates", getDuplicates(db)).Methods("GET")
+	admin.HandleFunc("/duplicates/{duplicateId:[0-9]+}/merge", resolveDuplicate(db, true)).Methods("POST")
+	admin.HandleFunc("/duplicates/{duplicateId:[0-9]+}/dismiss", resolveDuplicate(db, false)).Methods("POST")
+	admin.HandleFunc("/items/{id}/tags", putItemTags(db)).Methods("PUT")
+	admin.HandleFunc("/items/{id}/tags/{tag}", attachItemTag(db)).Methods("PUT")
+	admin.HandleFunc("/items/{id}/tags/{tag}", detachItemTag(db)).Methods("DELETE")
+	admin.HandleFunc("/images/missing-alt-text", getImagesMissingAltText(db)).Methods("GET")
+	admin.HandleFunc("/images/alt-text", patchImageAltTexts(db)).Methods("PATCH")
+	admin.HandleFunc("/images/rehost", getImageRehost(db)).Methods("GET")
+	admin.HandleFunc("/images/rehost", startImageRehost(db)).Methods("POST")
+	admin.HandleFunc("/brands", createBrand(db)).Methods("POST")
+	admin.HandleFunc("/brands/{brandId:[0-9]+}", renameBrand(db)).Methods("PUT")
+	admin.HandleFunc("/brands/{brandId:[0-9]+}", deleteBrand(db)).Methods("DELETE")
+	admin.HandleFunc("/models", createModel(db)).Methods("POST")
+	admin.HandleFunc("/models/{modelId:[0-9]+}", updateModel(db)).Methods("PUT")
+	admin.HandleFunc("/models/{modelId:[0-9]+}", deleteModel(db)).Methods("DELETE")
+	admin.HandleFunc("/releases", createRelease(db)).Methods("POST")
+	admin.HandleFunc("/releases/{releaseId:[0-9]+}", updateRelease(db)).Methods("PUT")
+	admin.HandleFunc("/releases/{releaseId:[0-9]+}", deleteRelease(db)).Methods("DELETE")
+	admin.HandleFunc("/search/analytics", getSearchAnalytics(db)).Methods("GET")
+	admin.HandleFunc("/search/dictionary", getSearchDictionary(db)).Methods("GET")
+	admin.HandleFunc("/search/dictionary/reload", reloadSearchDictionary(db)).Methods("POST")
+	admin.HandleFunc("/search/synonyms/{term}", putSynonym(db)).Methods("PUT")
+	admin.HandleFunc("/search/synonyms/{term}", deleteSynonym(db)).Methods("DELETE")
+	admin.HandleFunc("/search/stopwords/{word}", putStopword(db)).Methods("PUT")
+	admin.HandleFunc("/search/stopwords/{word}", deleteStopword(db)).Methods("DELETE")
+	admin.HandleFunc("/snapshots/inventory", getInventorySnapshot(db)).Methods("GET")
+	admin.HandleFunc("/stock/decrement", adjustStock(db, false)).Methods("POST")
+	admin.HandleFunc("/stock/increment", adjustStock(db, true)).Methods("POST")
+	admin.HandleFunc("/users/{userId}/wallet/credits", creditWallet(db)).Methods("POST")
+	admin.HandleFunc("/users/{userId}/membership", putMembership(db)).Methods("PUT")
+	admin.HandleFunc("/config", getRuntimeConfig).Methods("GET")
+	admin.HandleFunc("/config", patchRuntimeConfig).Methods("PATCH")
+	admin.HandleFunc("/retention", getRetentionReport(db)).Methods("GET")
+	admin.HandleFunc("/slo", getSLO(db)).Methods("GET")
+	admin.HandleFunc("/shipping-zones", getShippingZones(db)).Methods("GET")
+	admin.HandleFunc("/shipping-zones", putShippingZone(db)).Methods("POST")
+	admin.HandleFunc("/shipping-zones/{zoneId:[0-9]+}", putShippingZone(db)).Methods("PUT")
+	admin.HandleFunc("/shipping-zones/{zoneId:[0-9]+}", deleteShippingZone(db)).Methods("DELETE")
+	admin.HandleFunc("/discounts", getDiscountCodes(db)).Methods("GET")
+	admin.HandleFunc("/discounts/{code}", putDiscountCode(db)).Methods("PUT")
+	admin.HandleFunc("/discounts/{code}", deleteDiscountCode(db)).Methods("DELETE")
+
+	// Background jobs
+	go runEvery(context.Background(), "sitemap", getenvDuration("SITEMAP_INTERVAL", time.Hour), generateSitemaps(db))
+	go runEvery(context.Background(), "vocabulary", 10*time.Minute, buildVocabulary(db))
+	go runEvery(context.Background(), "snapshot", time.Hour, takeSnapshot(db))
+	go runEvery(context.Background(), "release-reminders", time.Minute, sendReleaseReminders(db))
+	go runEvery(context.Background(), "renditions", time.Minute, generateRenditions(db))
+	go runEvery(context.Background(), "rehost", time.Minute, rehostImages(db))
+	go runEvery(context.Background(), "scheduled-changes", time.Minute, logScheduledChanges(db))
+	go runEvery(context.Background(), "retention", getenvDuration("RETENTION_INTERVAL", 24*time.Hour), purgeExpired(db))
+	go runEvery(context.Background(), "price-drops", getenvDuration("PRICE_ALERT_INTERVAL", 15*time.Minute), checkPriceDrops(db))
+	go runEvery(context.Background(), "slo", time.Minute, flushSLO(db))
+	go runEvery(context.Background(), "restocks", time.Minute, sendRestockAlerts(db))
+	go runEvery(context.Background(), "pii-rotation", getenvDuration("PII_ROTATION_INTERVAL", time.Hour), rotatePII(db))
+	if marketPrices != nil {
+		go runEvery(context.Background(), "market-prices", getenvDuration("MARKET_PRICE_INTERVAL", 6*time.Hour), pullMarketPrices(db, marketPrices))
+	}
+	if dir := getenv("EXPORT_DIR", ""); dir != "" {
+		go runEvery(context.Background(), "export", getenvDuration("EXPORT_INTERVAL", time.Hour), exportChanges(db, dirSink{dir}))
+	}
+
+	go reloadOnSIGHUP()
+	// The mTLS listener only serves the admin and integration routes
+	go serveAdminTLS(cacheControl(admin))
 
 	// Start the server
 	log.Fatal(http.ListenAndServe(":8080", handler))
 }
 
+// favoriteSorts maps the sortBy values of GET /favorites to their ORDER BY
+// clause; favorites are added in the order of their ID.
+var favoriteSorts = map[string]string{
+	"newest": "f.id DESC",
+	"oldest": "f.id",
+	"price":  "s.price, f.id",
+	"-price": "s.price DESC, f.id",
+}
+
+// getFavorites lists the caller's favorites a page at a time, with
+// ?limit= (100 by default, 500 at most) and ?offset=.
 func getFavorites(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := queryLimit(r, 100, 500)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+		offset, ok := queryOffset(r)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidOffset)
+			return
+		}
+		// Sorted in the customer's order unless ?sortBy= is set, e.g. ?sortBy=-price
+		orderBy := "f.position, f.id"
+		if sortBy := r.URL.Query().Get("sortBy"); sortBy != "" {
+			if orderBy, ok = favoriteSorts[sortBy]; !ok {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidSort)
+				return
+			}
+		}
+
+		// X-Total-Count is the number of favorites on all pages
+		var total int
+		err := db.QueryRow(`
+        SELECT count(*) FROM favorite f
+        INNER JOIN sneakers s ON f.item_id = s.id
+        WHERE f.owner IS NOT DISTINCT FROM $1 AND `+itemVisible("s"), favoritesOwner(r)).Scan(&total)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
 		// Joining favorites with sneakers on item_id to fetch related sneaker details
 		query := `
-        SELECT f.id, f.item_id, s.title, s.price, s.imageUrl, s.isFavorite, s.favoriteId, s.isAdded
+        SELECT f.id, f.item_id, f.variant_id, f.wishlist_id, s.title, s.price, s.imageUrl, s.isFavorite, s.favoriteId, s.isAdded,
+               EXISTS (SELECT 1 FROM price_alerts a WHERE a.owner = f.owner AND a.item_id = f.item_id)
         FROM favorite f
-        INNER JOIN sneakers s ON f.item_id = s.id`
+        INNER JOIN sneakers s ON f.item_id = s.id
+        WHERE f.owner IS NOT DISTINCT FROM $1 AND ` + itemVisible("s") + `
+        ORDER BY ` + orderBy + `
+        LIMIT $2 OFFSET $3`
 
-		rows, err := db.Query(query)
+		rows, err := db.Query(query, favoritesOwner(r), limit, offset)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			serverError(w, r, err)
 			return
 		}
 		defer rows.Close()
@@ -89,69 +366,194 @@ func getFavorites(db *sql.DB) http.HandlerFunc {
 		var favorites []struct {
 			ID         int    `json:"id"`
 			ItemID     int    `json:"item_id"`
+			VariantID  *int   `json:"variant_id"`
+			WishlistID *int   `json:"wishlist_id"`
 			Title      string `json:"title"`
-			Price      int    `json:"price"`
+			Price      Money  `json:"price"`
 			ImageURL   string `json:"image_url"`
 			IsFavorite bool   `json:"is_favorite"`
 			FavoriteID *int   `json:"favorite_id"`
 			IsAdded    bool   `json:"is_added"`
+			PriceAlert bool   `json:"price_alert"`
 		}
 
 		for rows.Next() {
 			var f struct {
 				ID         int    `json:"id"`
 				ItemID     int    `json:"item_id"`
+				VariantID  *int   `json:"variant_id"`
+				WishlistID *int   `json:"wishlist_id"`
 				Title      string `json:"title"`
-				Price      int    `json:"price"`
+				Price      Money  `json:"price"`
 				ImageURL   string `json:"image_url"`
 				IsFavorite bool   `json:"is_favorite"`
 				FavoriteID *int   `json:"favorite_id"`
 				IsAdded    bool   `json:"is_added"`
+				PriceAlert bool   `json:"price_alert"`
 			}
-			if err := rows.Scan(&f.ID, &f.ItemID, &f.Title, &f.Price, &f.ImageURL, &f.IsFavorite, &f.FavoriteID, &f.IsAdded); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+			if err := rows.Scan(&f.ID, &f.ItemID, &f.VariantID, &f.WishlistID, &f.Title, &f.Price, &f.ImageURL, &f.IsFavorite, &f.FavoriteID, &f.IsAdded, &f.PriceAlert); err != nil {
+				serverError(w, r, err)
 				return
 			}
 			favorites = append(favorites, f)
 		}
 
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		if r.Method == http.MethodHead {
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(favorites)
 	}
 }
 
+// sneakerSummary is the compact sneaker representation nested in other resources.
+type sneakerSummary struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	Price    Money  `json:"price"`
+	ImageURL string `json:"image_url"`
+}
+
+// postFavorite favorites a sneaker, optionally in a specific size given by
+// variant_id. A sneaker is favorited once per customer: favoriting it again
+// returns the existing favorite with 200 instead of 201. A variant_id that
+// is not one of the sneaker's sizes is rejected even then.
 func postFavorite(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
 		var data struct {
-			ItemID int `json:"item_id"`
+			ItemID    int  `json:"item_id"`
+			VariantID *int `json:"variant_id"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if data.ItemID <= 0 {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
 			return
 		}
 
-		_, err := db.Exec("INSERT INTO favorite (item_id) VALUES ($1)", data.ItemID)
+		// Checked up front, as an existing favorite would otherwise hide an
+		// invalid variant
+		var live, sized bool
+		err := db.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM sneakers WHERE id = $1 AND `+itemVisible("")+`),
+               $2::int IS NULL OR EXISTS (SELECT 1 FROM item_variants WHERE id = $2 AND item_id = $1)`,
+			data.ItemID, data.VariantID).Scan(&live, &sized)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			serverError(w, r, err)
+			return
+		}
+		if !live {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+		if !sized {
+			writeError(w, r, http.StatusNotFound, errCodeVariantNotFound)
 			return
 		}
 
+		var favorite struct {
+			ID        int            `json:"id"`
+			ItemID    int            `json:"item_id"`
+			VariantID *int           `json:"variant_id"`
+			Item      sneakerSummary `json:"item"`
+		}
+		// The conditions are checked again in case the sneaker or size was
+		// deleted in the meantime
+		err = db.QueryRow(`
+        WITH f AS (INSERT INTO favorite (owner, item_id, variant_id, position, wishlist_id)
+                   SELECT $3, $1, $2, (SELECT coalesce(max(position), 0) + 1 FROM favorite WHERE owner = $3), `+defaultWishlist(3)+`
+                   WHERE EXISTS (SELECT 1 FROM sneakers WHERE id = $1 AND `+itemVisible("")+`)
+                     AND ($2::int IS NULL OR EXISTS (SELECT 1 FROM item_variants WHERE id = $2 AND item_id = $1))
+                   ON CONFLICT ((coalesce(owner, '')), item_id) DO NOTHING
+                   RETURNING id, item_id, variant_id)
+        SELECT f.id, f.item_id, f.variant_id, s.id, s.title, s.price, s.imageUrl
+        FROM f
+        INNER JOIN sneakers s ON f.item_id = s.id`, data.ItemID, data.VariantID, owner).
+			Scan(&favorite.ID, &favorite.ItemID, &favorite.VariantID, &favorite.Item.ID, &favorite.Item.Title, &favorite.Item.Price, &favorite.Item.ImageURL)
+		if isForeignKeyViolation(err) {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			// Already a favorite: it is returned as is
+			err = db.QueryRow(`
+            SELECT f.id, f.item_id, f.variant_id, s.id, s.title, s.price, s.imageUrl
+            FROM favorite f
+            INNER JOIN sneakers s ON f.item_id = s.id
+            WHERE f.owner = $2 AND f.item_id = $1`, data.ItemID, owner).
+				Scan(&favorite.ID, &favorite.ItemID, &favorite.VariantID, &favorite.Item.ID, &favorite.Item.Title, &favorite.Item.Price, &favorite.Item.ImageURL)
+			if errors.Is(err, sql.ErrNoRows) {
+				writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+				return
+			}
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(favorite)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/favorites/%d", favorite.ID))
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(favorite)
 	}
 }
 
 func deleteFavorite(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
 		vars := mux.Vars(r)
 		favoriteId, err := strconv.Atoi(vars["favoriteId"])
 		if err != nil {
-			http.Error(w, "Invalid favorite ID", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidFavoriteID)
+			return
+		}
+
+		_, err = db.Exec("DELETE FROM favorite WHERE id = $1 AND owner = $2", favoriteId, owner)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deleteFavoriteByItem unfavorites a sneaker by its item ID, for clients that
+// do not know the favorite's ID.
+func deleteFavoriteByItem(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		itemID, err := strconv.Atoi(mux.Vars(r)["itemId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
 			return
 		}
 
-		_, err = db.Exec("DELETE FROM favorite WHERE id = $1", favoriteId)
+		_, err = db.Exec("DELETE FROM favorite WHERE item_id = $1 AND owner = $2", itemID, owner)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			serverError(w, r, err)
 			return
 		}
 
@@ -165,62 +567,121 @@ func getItems(db *sql.DB) http.HandlerFunc {
 		sortBy := params.Get("sortBy")
 		searchQuery := params.Get("title")
 
-		// Start with the base query
-		query := "SELECT * FROM sneakers"
+		var filters itemQuery
+		var err error
+		if filters.member, err = earlyAccess(w, r, db); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		filters.where(itemInMarket("", "%[1]s"), requestMarket(w, r))
 
-		// Add filtering by title if a search query is provided
-		if searchQuery != "" {
-			// Use parameterized SQL to safely add user input to the query
-			query += " WHERE title ILIKE $1"
+		// Filter by attribute values, e.g. ?attr=upper:suede&attr=colorway:bred
+		for _, attr := range params["attr"] {
+			key, value, ok := strings.Cut(attr, ":")
+			if !ok || key == "" {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidAttributeFilter)
+				return
+			}
+			filter, _ := json.Marshal(map[string]string{key: value})
+			filters.where("attributes @> %s::jsonb", string(filter))
 		}
 
-		// Add sorting if a sort parameter is provided
-		if sortBy != "" {
-			query += fmt.Sprintf(" ORDER BY %s", sortBy) // Note: Potential SQL injection vulnerability, see explanation below
+		// Filter by brand slugs; an item may be of any requested brand, e.g. ?brand=nike&brand=adidas
+		if brands := params["brand"]; len(brands) > 0 {
+			slugs := make([]string, len(brands))
+			for n, b := range brands {
+				slugs[n] = slugify(b)
+			}
+			filters.where("brand_id IN (SELECT id FROM brands WHERE slug = ANY(%s))", pq.Array(slugs))
 		}
 
-		var rows *sql.Rows
-		var err error
+		// Filter by category ID or slug, including its subcategories
+		if c := params.Get("category"); c != "" {
+			filters.where("category_id IN ("+categoryDescendants+")", c, c)
+		}
 
+		// Filter by tag slugs; an item must carry every requested tag
+		for _, t := range params["tag"] {
+			filters.where("id IN (SELECT it.item_id FROM item_tags it JOIN tags t ON t.id = it.tag_id WHERE t.slug = %s)", slugify(t))
+		}
+
+		// Add filtering by title, description, style code, materials and attributes if a search query is provided
+		query := filters.clone()
+		searchTerm := rewriteQuery(searchQuery)
 		if searchQuery != "" {
-			// Execute the query with the parameter for safety
-			rows, err = db.Query(query, "%"+searchQuery+"%")
-		} else {
-			// Execute the query without parameters if there is no search query
-			rows, err = db.Query(query)
+			// Use parameterized SQL to safely add user input to the query
+			query.where("(title ILIKE %s OR search_vector @@ plainto_tsquery('simple', %s))", "%"+searchTerm+"%", searchTerm)
 		}
 
+		// Add sorting if a sort parameter is provided, e.g. ?sortBy=popularity
+		orderBy := ""
+		if sortBy != "" {
+			var ok bool
+			if orderBy, ok = itemSorts[sortBy]; !ok {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidSort)
+				return
+			}
+		}
+
+		items, lastModified, err := queryItems(db, query, orderBy, 0)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			serverError(w, r, err)
 			return
 		}
-		defer rows.Close()
 
-		var items []struct {
-			ID         int    `json:"id"`
-			Title      string `json:"title"`
-			Price      int    `json:"price"`
-			ImageURL   string `json:"image_url"`
-			IsFavorite bool   `json:"is_favorite"`
-			FavoriteID *int   `json:"favorite_id"`
-			IsAdded    bool   `json:"is_added"`
+		if searchQuery != "" && r.Method == http.MethodGet {
+			// Record searches for analytics; clients report clicks back with the search ID
+			if searchID, ok := logSearch(db, searchQuery, len(items)); ok {
+				w.Header().Set("X-Search-ID", strconv.FormatInt(searchID, 10))
+			}
+			if owner := callerID(r); owner != "" {
+				recordSearchHistory(db, owner, searchQuery)
+			}
+
+			// Offer a spelling correction when the catalog vocabulary clearly suggests one
+			var suggested string
+			var hasSuggestion bool
+			if featureEnabled(featureSpelling) {
+				suggested, hasSuggestion = suggestQuery(searchTerm)
+			}
+			if hasSuggestion {
+				w.Header().Set("X-Suggested-Query", suggested)
+			}
+
+			// Rather than an empty page, offer corrected or relaxed matches, or popular items
+			if len(items) == 0 && featureEnabled(featureSearchFallback) {
+				var fallback string
+				items, lastModified, fallback, err = searchFallback(db, filters, searchTerm, suggested, orderBy)
+				if err != nil {
+					serverError(w, r, err)
+					return
+				}
+				w.Header().Set("X-Search-Fallback", fallback)
+			}
 		}
 
-		for rows.Next() {
-			var i struct {
-				ID         int    `json:"id"`
-				Title      string `json:"title"`
-				Price      int    `json:"price"`
-				ImageURL   string `json:"image_url"`
-				IsFavorite bool   `json:"is_favorite"`
-				FavoriteID *int   `json:"favorite_id"`
-				IsAdded    bool   `json:"is_added"`
+		// Re-rank for the caller; the result is then specific to them and must not be shared
+		if params.Get("personalized") == "true" && featureEnabled(featurePersonalization) {
+			if owner := callerID(r); owner != "" {
+				if items, err = personalize(r.Context(), owner, items); err != nil {
+					serverError(w, r, err)
+					return
+				}
+				w.Header().Set("Cache-Control", cachePrivate.header())
 			}
-			if err := rows.Scan(&i.ID, &i.Title, &i.Price, &i.ImageURL, &i.IsFavorite, &i.FavoriteID, &i.IsAdded); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		w.Header().Set("X-Total-Count", strconv.Itoa(len(items)))
+		if notModified(w, r, lastModified) || r.Method == http.MethodHead {
+			return
+		}
+
+		// Sizes are only included on request, e.g. ?embed=variants
+		if params.Get("embed") == "variants" {
+			if err := embedVariants(db, items); err != nil {
+				serverError(w, r, err)
 				return
 			}
-			items = append(items, i)
 		}
 
 		w.Header().Set("Content-Type", "application/json")
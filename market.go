@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// errNoMarketPrice is returned by a marketPriceProvider that does not know a
+// sneaker.
+var errNoMarketPrice = errors.New("no market price")
+
+// marketQuote is what a sneaker currently trades for on the resale market,
+// in minor units of Currency. Providers leave out the figures they lack.
+type marketQuote struct {
+	Currency   string `json:"currency"`
+	LowestAsk  *int   `json:"lowest_ask"`
+	HighestBid *int   `json:"highest_bid"`
+	LastSale   *int   `json:"last_sale"`
+}
+
+// marketPriceProvider looks up the market price of a sneaker by style code.
+type marketPriceProvider interface {
+	Quote(ctx context.Context, styleCode string) (marketQuote, error)
+}
+
+// newMarketPriceProvider returns the provider selected by
+// MARKET_PRICE_PROVIDER, or nil when market prices are not pulled:
+//
+//	http  a JSON API at MARKET_PRICE_URL answering GET /prices/{style code}
+//	      with a marketQuote; the market_price_api_key secret is sent as a
+//	      bearer token
+func newMarketPriceProvider(secrets secretsProvider) (marketPriceProvider, error) {
+	switch kind := os.Getenv("MARKET_PRICE_PROVIDER"); kind {
+	case "":
+		return nil, nil
+	case "http":
+		base := os.Getenv("MARKET_PRICE_URL")
+		if base == "" {
+			return nil, fmt.Errorf("MARKET_PRICE_URL is required for MARKET_PRICE_PROVIDER=http")
+		}
+		return &httpMarketPrices{
+			baseURL: strings.TrimSuffix(base, "/"),
+			secrets: secrets,
+			client:  &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown MARKET_PRICE_PROVIDER %q", kind)
+	}
+}
+
+type httpMarketPrices struct {
+	baseURL string
+	secrets secretsProvider
+	client  *http.Client
+}
+
+func (p *httpMarketPrices) Quote(ctx context.Context, styleCode string) (marketQuote, error) {
+	var q marketQuote
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/prices/"+url.PathEscape(styleCode), nil)
+	if err != nil {
+		return q, err
+	}
+	key, err := p.secrets.Secret(ctx, "market_price_api_key")
+	if err != nil {
+		return q, err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return q, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return q, errNoMarketPrice
+	case resp.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return q, fmt.Errorf("market price %s: %s %s", styleCode, resp.Status, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&q); err != nil {
+		return q, fmt.Errorf("market price %s: %w", styleCode, err)
+	}
+	q.Currency = strings.ToUpper(q.Currency)
+	if q.Currency == "" {
+		return q, fmt.Errorf("market price %s: no currency", styleCode)
+	}
+	return q, nil
+}
+
+// pullMarketPrices refreshes the market price of every sneaker model with a
+// style code, least recently refreshed first. A failure for one model is
+// logged and does not stop the others.
+func pullMarketPrices(db *sql.DB, provider marketPriceProvider) func(context.Context) error {
+	return func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `
+        SELECT m.id, m.style_code FROM models m
+        LEFT JOIN market_prices p ON p.model_id = m.id
+        WHERE m.style_code IS NOT NULL
+        ORDER BY p.fetched_at NULLS FIRST, m.id`)
+		if err != nil {
+			return err
+		}
+		type model struct {
+			id        int
+			styleCode string
+		}
+		var models []model
+		for rows.Next() {
+			var m model
+			if err := rows.Scan(&m.id, &m.styleCode); err != nil {
+				rows.Close()
+				return err
+			}
+			models = append(models, m)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, m := range models {
+			q, err := provider.Quote(ctx, m.styleCode)
+			if errors.Is(err, errNoMarketPrice) {
+				continue
+			}
+			if err == nil {
+				_, err = db.ExecContext(ctx, `
+                INSERT INTO market_prices (model_id, currency, lowest_ask, highest_bid, last_sale) VALUES ($1, $2, $3, $4, $5)
+                ON CONFLICT (model_id) DO UPDATE
+                SET currency = EXCLUDED.currency, lowest_ask = EXCLUDED.lowest_ask, highest_bid = EXCLUDED.highest_bid,
+                    last_sale = EXCLUDED.last_sale, fetched_at = now()`,
+					m.id, q.Currency, q.LowestAsk, q.HighestBid, q.LastSale)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				logAt(levelWarn, "market price of model %d: %v", m.id, err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d models failed", failed, len(models))
+		}
+		return nil
+	}
+}
+
+// getMarketPrice compares the retail price of a sneaker model, the lowest
+// price of its SKUs, with what it trades for on the resale market.
+func getMarketPrice(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modelID, err := strconv.Atoi(mux.Vars(r)["modelId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidModelID)
+			return
+		}
+
+		var retail *Money
+		var q marketQuote
+		var fetchedAt *time.Time
+		err = db.QueryRow(`
+        SELECT (SELECT min(price) FROM sneakers WHERE model_id = m.id AND `+itemVisible("")+`),
+               coalesce(p.currency, ''), p.lowest_ask, p.highest_bid, p.last_sale, p.fetched_at
+        FROM models m
+        LEFT JOIN market_prices p ON p.model_id = m.id
+        WHERE m.id = $1`, modelID).Scan(&retail, &q.Currency, &q.LowestAsk, &q.HighestBid, &q.LastSale, &fetchedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeModelNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if fetchedAt == nil {
+			writeError(w, r, http.StatusNotFound, errCodeMarketPriceNotFound)
+			return
+		}
+
+		amount := func(v *int) *Money {
+			if v == nil {
+				return nil
+			}
+			return &Money{*v, q.Currency}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			ModelID    int       `json:"model_id"`
+			Retail     *Money    `json:"retail"`
+			LowestAsk  *Money    `json:"lowest_ask"`
+			HighestBid *Money    `json:"highest_bid"`
+			LastSale   *Money    `json:"last_sale"`
+			FetchedAt  time.Time `json:"fetched_at"`
+		}{modelID, retail, amount(q.LowestAsk), amount(q.HighestBid), amount(q.LastSale), *fetchedAt})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// marketHeader is the request header in which the CDN passes the caller's
+// country, resolved from their IP address.
+var marketHeader = getenv("MARKET_HEADER", "CF-IPCountry")
+
+// requestMarket returns the country of the market the caller shops in: the
+// ?country= picked in the storefront's region selector, otherwise the one
+// resolved by the CDN. It returns "" when the country is unknown, in which
+// case sneakers restricted to some markets are hidden.
+func requestMarket(w http.ResponseWriter, r *http.Request) string {
+	w.Header().Add("Vary", marketHeader)
+	country := r.URL.Query().Get("country")
+	if country == "" {
+		country = r.Header.Get(marketHeader)
+	}
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if !countryCode.MatchString(country) {
+		return ""
+	}
+	return country
+}
+
+// itemInMarket returns the SQL condition selecting the sneakers sold in the
+// country in placeholder: sneakers without markets are sold everywhere, and
+// each tag with markets restricts its sneakers to them, so a collection can
+// be launched country by country. alias qualifies the columns as for
+// itemVisible.
+func itemInMarket(alias, placeholder string) string {
+	table := "sneakers"
+	if alias != "" {
+		table = alias
+		alias += "."
+	}
+	return "(" + alias + "markets IS NULL OR " + placeholder + " = ANY(" + alias + "markets)) AND NOT EXISTS (" +
+		"SELECT 1 FROM item_tags mt JOIN tags t ON t.id = mt.tag_id " +
+		"WHERE mt.item_id = " + table + ".id AND t.markets IS NOT NULL AND NOT " + placeholder + " = ANY(t.markets))"
+}
+
+// normalizeMarkets upper-cases a list of markets and reports whether they
+// are all ISO 3166-1 alpha-2 country codes. An empty list becomes nil, for
+// no restriction.
+func normalizeMarkets(markets []string) ([]string, bool) {
+	if len(markets) == 0 {
+		return nil, true
+	}
+	for n, c := range markets {
+		markets[n] = strings.ToUpper(strings.TrimSpace(c))
+		if !countryCode.MatchString(markets[n]) {
+			return nil, false
+		}
+	}
+	return markets, true
+}
+
+// putTagMarkets restricts the sneakers of a tag to some markets, e.g.
+// {"markets": ["US", "CA"]}, or lifts the restriction with null or [].
+func putTagMarkets(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tagID, err := strconv.Atoi(mux.Vars(r)["tagId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidTagID)
+			return
+		}
+		var data struct {
+			Markets []string `json:"markets"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		markets, ok := normalizeMarkets(data.Markets)
+		if !ok {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidMarkets)
+			return
+		}
+
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			res, err := tx.q.ExecContext(r.Context(), "UPDATE tags SET markets = $2 WHERE id = $1", tagID, pq.Array(markets))
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return &apiError{http.StatusNotFound, errCodeTagNotFound}
+			}
+			// The tag's sneakers appear in or leave markets, like an edit would
+			_, err = tx.q.ExecContext(r.Context(), "UPDATE sneakers SET updated_at = now() WHERE id IN (SELECT item_id FROM item_tags WHERE tag_id = $1)", tagID)
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"id": tagID, "markets": markets})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// testDB connects to the database in TEST_DATABASE_URL and brings its schema
+// up to date. Tests using it are skipped when it is not set; the database
+// is shared, so they clean up the rows they add.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// TestModelRoutesMarket checks that a sneaker restricted to the US is left
+// out of its model's page, availability and price history elsewhere.
+func TestModelRoutesMarket(t *testing.T) {
+	db := testDB(t)
+
+	var modelID, openID, usOnlyID int
+	if err := db.QueryRow("INSERT INTO models (silhouette) VALUES ('Market test') RETURNING id").Scan(&modelID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM sneakers WHERE model_id = $1", modelID)
+		db.Exec("DELETE FROM models WHERE id = $1", modelID)
+	})
+	insertItem := func(price int, markets any) int {
+		var id int
+		err := db.QueryRow(`
+        INSERT INTO sneakers (title, price, imageUrl, model_id, markets) VALUES ('Market test', $1, '', $2, $3)
+        RETURNING id`, price, modelID, markets).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec("INSERT INTO item_variants (item_id, us_size, eu_size, sku, stock) VALUES ($1, '9', '42.5', $2, 3)",
+			id, fmt.Sprintf("MARKET-TEST-%d", id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	openID = insertItem(12000, nil)
+	usOnlyID = insertItem(9000, "{US}")
+
+	router := mux.NewRouter()
+	router.HandleFunc("/models/{modelId:[0-9]+}", getModel(db)).Methods("GET")
+	router.HandleFunc("/models/{modelId:[0-9]+}/price-history", getModelPriceHistory(db)).Methods("GET")
+	router.HandleFunc("/models/{modelId:[0-9]+}/availability", getModelAvailability(db)).Methods("GET")
+	get := func(path string, body any) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", path, rec.Code, rec.Body)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), body); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		country string
+		items   []int
+		lowest  int
+	}{
+		{"FR", []int{openID}, 12000},
+		{"US", []int{usOnlyID, openID}, 9000},
+	} {
+		t.Run(tt.country, func(t *testing.T) {
+			var model struct {
+				LowestPrice *Money `json:"lowest_price"`
+				Items       []struct {
+					ID int `json:"id"`
+				} `json:"items"`
+			}
+			get(fmt.Sprintf("/models/%d?country=%s", modelID, tt.country), &model)
+			var items []int
+			for _, i := range model.Items {
+				items = append(items, i.ID)
+			}
+			if fmt.Sprint(items) != fmt.Sprint(tt.items) {
+				t.Errorf("model items %v, want %v", items, tt.items)
+			}
+			if model.LowestPrice == nil || model.LowestPrice.Amount != tt.lowest {
+				t.Errorf("lowest price %v, want %d", model.LowestPrice, tt.lowest)
+			}
+
+			var availability struct {
+				Sizes []struct {
+					Offers []struct {
+						ItemID int `json:"item_id"`
+					} `json:"offers"`
+				} `json:"sizes"`
+			}
+			get(fmt.Sprintf("/models/%d/availability?country=%s", modelID, tt.country), &availability)
+			offers := map[int]bool{}
+			for _, s := range availability.Sizes {
+				for _, o := range s.Offers {
+					offers[o.ItemID] = true
+				}
+			}
+			if offers[usOnlyID] != (tt.country == "US") || !offers[openID] {
+				t.Errorf("availability offers of items %v", offers)
+			}
+
+			var history []struct {
+				ItemID int `json:"item_id"`
+			}
+			get(fmt.Sprintf("/models/%d/price-history?country=%s", modelID, tt.country), &history)
+			priced := map[int]bool{}
+			for _, p := range history {
+				priced[p.ItemID] = true
+			}
+			if priced[usOnlyID] != (tt.country == "US") || !priced[openID] {
+				t.Errorf("price history of items %v", priced)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// mediaStore stores uploaded files such as product images under a key and
+// serves them from a public URL.
+type mediaStore interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+// media is where uploads go. It is configured at start-up by newMediaStore.
+var media mediaStore
+
+// newMediaStore returns the store selected by MEDIA_STORE:
+//
+//	dir  files under MEDIA_DIR, served by this API under /media/ (default)
+//	s3   the S3 bucket S3_BUCKET in S3_REGION, or an S3 compatible service
+//	     at S3_ENDPOINT; credentials are the aws_access_key_id and
+//	     aws_secret_access_key secrets
+//
+// Public URLs start with MEDIA_BASE_URL, typically the CDN in front of the
+// bucket.
+func newMediaStore(secrets secretsProvider) (mediaStore, error) {
+	switch kind := getenv("MEDIA_STORE", "dir"); kind {
+	case "dir":
+		return dirMedia{dir: getenv("MEDIA_DIR", "media"), baseURL: getenv("MEDIA_BASE_URL", "http://localhost:8080/media")}, nil
+	case "s3":
+		bucket, region := os.Getenv("S3_BUCKET"), getenv("S3_REGION", "us-east-1")
+		if bucket == "" {
+			return nil, fmt.Errorf("S3_BUCKET is required for MEDIA_STORE=s3")
+		}
+		endpoint := getenv("S3_ENDPOINT", fmt.Sprintf("https://s3.%s.amazonaws.com", region))
+		return &s3Media{
+			bucketURL: strings.TrimSuffix(endpoint, "/") + "/" + bucket,
+			region:    region,
+			baseURL:   getenv("MEDIA_BASE_URL", strings.TrimSuffix(endpoint, "/")+"/"+bucket),
+			secrets:   secrets,
+			client:    &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown MEDIA_STORE %q", kind)
+	}
+}
+
+type dirMedia struct {
+	dir     string
+	baseURL string
+}
+
+func (d dirMedia) Put(ctx context.Context, key, contentType string, data []byte) error {
+	path := filepath.Join(d.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (d dirMedia) Get(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(d.dir, filepath.FromSlash(key)))
+}
+
+func (d dirMedia) Delete(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(d.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (d dirMedia) URL(key string) string {
+	return strings.TrimSuffix(d.baseURL, "/") + "/" + key
+}
+
+// Handler serves the stored files; it is mounted under /media/.
+func (d dirMedia) Handler() http.Handler {
+	return http.StripPrefix("/media/", http.FileServer(http.Dir(d.dir)))
+}
+
+// s3Media stores objects in S3 using path-style requests signed with AWS
+// Signature Version 4.
+type s3Media struct {
+	bucketURL string
+	region    string
+	baseURL   string
+	secrets   secretsProvider
+	client    *http.Client
+}
+
+func (s *s3Media) Put(ctx context.Context, key, contentType string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.bucketURL+"/"+key, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	return s.do(req, data)
+}
+
+func (s *s3Media) Get(ctx context.Context, key string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.bucketURL+"/"+key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sign(req, nil); err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("s3 GET %s: %s", req.URL.Path, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (s *s3Media) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.bucketURL+"/"+key, nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req, nil)
+}
+
+func (s *s3Media) URL(key string) string {
+	return strings.TrimSuffix(s.baseURL, "/") + "/" + key
+}
+
+func (s *s3Media) do(req *http.Request, payload []byte) error {
+	if err := s.sign(req, payload); err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("s3 %s %s: %s %s", req.Method, req.URL.Path, resp.Status, body)
+	}
+	return nil
+}
+
+// sign adds the Signature Version 4 Authorization header. Object keys are
+// generated by the API and never need escaping.
+func (s *s3Media) sign(req *http.Request, payload []byte) error {
+	accessKey, err := s.secrets.Secret(req.Context(), "aws_access_key_id")
+	if err != nil {
+		return fmt.Errorf("aws_access_key_id: %w", err)
+	}
+	secretKey, err := s.secrets.Secret(req.Context(), "aws_secret_access_key")
+	if err != nil {
+		return fmt.Errorf("aws_secret_access_key: %w", err)
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256.Sum256(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		signed = []string{"cache-control", "content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	}
+	var headers strings.Builder
+	for _, h := range signed {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		headers.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonical := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		headers.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// memberEarlyAccess is how long before everyone else members can enter a
+// raffle.
+var memberEarlyAccess = getenvDuration("MEMBER_EARLY_ACCESS", 24*time.Hour)
+
+// membershipStatuses are the states of the recurring subscription behind a
+// membership. A past_due membership keeps its perks until the end of the
+// period so a failed renewal can still be retried.
+var membershipStatuses = map[string]bool{"active": true, "past_due": true, "canceled": true}
+
+// membership is the paid membership of a user.
+type membership struct {
+	Status           string    `json:"status"`
+	CurrentPeriodEnd time.Time `json:"current_period_end"`
+	ProviderRef      *string   `json:"provider_ref,omitempty"`
+}
+
+// membershipPerks are the entitlements of a membership.
+type membershipPerks struct {
+	FreeShipping bool `json:"free_shipping"`
+	EarlyAccess  bool `json:"early_access"`
+}
+
+// active reports whether m grants its perks at now. A canceled membership
+// runs until the end of the period that was paid for.
+func (m *membership) active(now time.Time) bool {
+	return m != nil && now.Before(m.CurrentPeriodEnd)
+}
+
+// Membership returns the membership of owner, or nil when they never had one.
+func (s Store) Membership(ctx context.Context, owner string) (*membership, error) {
+	var m membership
+	err := s.q.QueryRowContext(ctx, "SELECT status, current_period_end, provider_ref FROM memberships WHERE owner = $1", owner).
+		Scan(&m.Status, &m.CurrentPeriodEnd, &m.ProviderRef)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// memberPerks returns the perks of the caller; anonymous devices and users
+// without an active membership have none.
+func memberPerks(ctx context.Context, db *sql.DB, r *http.Request) (membershipPerks, error) {
+	owner := callerID(r)
+	if !strings.HasPrefix(owner, "user:") {
+		return membershipPerks{}, nil
+	}
+	m, err := NewStore(db).Membership(ctx, owner)
+	if err != nil || !m.active(time.Now()) {
+		return membershipPerks{}, err
+	}
+	return membershipPerks{FreeShipping: true, EarlyAccess: true}, nil
+}
+
+// earlyAccess reports whether the caller is a member who may see and buy
+// sneakers before their publication. Their responses then differ from the
+// public ones, so they are kept out of shared caches.
+func earlyAccess(w http.ResponseWriter, r *http.Request, db *sql.DB) (bool, error) {
+	w.Header().Add("Vary", "X-User-ID")
+	perks, err := memberPerks(r.Context(), db, r)
+	if err != nil {
+		return false, err
+	}
+	if perks.EarlyAccess {
+		w.Header().Set("Cache-Control", cachePrivate.header())
+	}
+	return perks.EarlyAccess, nil
+}
+
+// getMembership returns the caller's membership and the perks it currently
+// grants.
+func getMembership(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireUser(w, r)
+		if owner == "" {
+			return
+		}
+
+		m, err := NewStore(db).Membership(r.Context(), owner)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		body := struct {
+			Member     bool            `json:"member"`
+			Membership *membership     `json:"membership"`
+			Perks      membershipPerks `json:"perks"`
+		}{Member: m.active(time.Now()), Membership: m}
+		if body.Member {
+			body.Perks = membershipPerks{FreeShipping: true, EarlyAccess: true}
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+// putMembership records the state of a user's subscription, e.g.
+// {"status": "active", "current_period_end": "2025-07-01T00:00:00Z",
+// "provider_ref": "sub_123"}. The billing integration calls it whenever the
+// payment provider reports a renewal, a failed payment or a cancellation.
+func putMembership(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var m membership
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if !membershipStatuses[m.Status] || m.CurrentPeriodEnd.IsZero() {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidMembership)
+			return
+		}
+
+		_, err := db.Exec(`
+        INSERT INTO memberships (owner, status, current_period_end, provider_ref) VALUES ($1, $2, $3, $4)
+        ON CONFLICT (owner) DO UPDATE
+        SET status = EXCLUDED.status, current_period_end = EXCLUDED.current_period_end,
+            provider_ref = coalesce(EXCLUDED.provider_ref, memberships.provider_ref), updated_at = now()`,
+			"user:"+mux.Vars(r)["userId"], m.Status, m.CurrentPeriodEnd, m.ProviderRef)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
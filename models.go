@@ -0,0 +1,380 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sneakerModel is the canonical sneaker a retail SKU is a listing of, e.g.
+// the Air Jordan 1 High "Chicago" DZ5485-612. Prices, favorites and history
+// of every SKU of a model add up at the model level.
+type sneakerModel struct {
+	ID          int     `json:"id"`
+	BrandID     *int    `json:"brand_id"`
+	Brand       *string `json:"brand"`
+	Silhouette  string  `json:"silhouette"`
+	Colorway    string  `json:"colorway"`
+	StyleCode   *string `json:"style_code"`
+	ReleaseDate *string `json:"release_date"` // YYYY-MM-DD
+}
+
+// modelColumns is the select list matching scanModel.
+const modelColumns = `id, brand_id, (SELECT b.name FROM brands b WHERE b.id = models.brand_id), silhouette, colorway, style_code,
+	to_char(release_date, 'YYYY-MM-DD')`
+
+func scanModel(row rowScanner) (sneakerModel, error) {
+	var m sneakerModel
+	err := row.Scan(&m.ID, &m.BrandID, &m.Brand, &m.Silhouette, &m.Colorway, &m.StyleCode, &m.ReleaseDate)
+	return m, err
+}
+
+// getModels lists sneaker models alphabetically, optionally of a ?brand= slug.
+func getModels(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := queryLimit(r, 100, 500)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT `+modelColumns+` FROM models
+        WHERE $1 = '' OR brand_id = (SELECT id FROM brands WHERE slug = $1)
+        ORDER BY silhouette, colorway, id
+        LIMIT $2`, slugify(r.URL.Query().Get("brand")), limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		models := []sneakerModel{}
+		for rows.Next() {
+			m, err := scanModel(rows)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			models = append(models, m)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models)
+	}
+}
+
+// getModel returns a sneaker model with its retail SKUs, the lowest price
+// they sell for and how often they were favorited.
+func getModel(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modelID, err := strconv.Atoi(mux.Vars(r)["modelId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidModelID)
+			return
+		}
+
+		m, err := scanModel(db.QueryRow("SELECT "+modelColumns+" FROM models WHERE id = $1", modelID))
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeModelNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		market := requestMarket(w, r)
+		var q itemQuery
+		q.where("model_id = %s", modelID)
+		q.where(itemInMarket("", "%[1]s"), market)
+		items, _, err := queryItems(db, q, "price, id", 0)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		body := struct {
+			sneakerModel
+			LowestPrice *Money `json:"lowest_price"`
+			Favorites   int    `json:"favorites"`
+			Items       []item `json:"items"`
+		}{sneakerModel: m, Items: []item{}}
+		if len(items) > 0 {
+			body.Items = items
+			body.LowestPrice = &items[0].Price
+		}
+		err = db.QueryRow(`
+        SELECT count(*) FROM favorite f
+        INNER JOIN sneakers s ON s.id = f.item_id
+        WHERE s.model_id = $1 AND `+itemVisible("s")+` AND `+itemInMarket("s", "$2"), modelID, market).Scan(&body.Favorites)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+// getModelPriceHistory returns the price changes of every retail SKU of a
+// model over the last ?days= (365 by default), oldest first.
+func getModelPriceHistory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modelID, err := strconv.Atoi(mux.Vars(r)["modelId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidModelID)
+			return
+		}
+		days := 365
+		if raw := r.URL.Query().Get("days"); raw != "" {
+			if days, err = strconv.Atoi(raw); err != nil || days < 1 || days > 3650 {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidDays)
+				return
+			}
+		}
+
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM models WHERE id = $1)", modelID).Scan(&exists); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if !exists {
+			writeError(w, r, http.StatusNotFound, errCodeModelNotFound)
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT h.item_id, h.price, h.changed_at
+        FROM price_history h
+        INNER JOIN sneakers s ON s.id = h.item_id
+        WHERE s.model_id = $1 AND `+itemVisible("s")+` AND `+itemInMarket("s", "$3")+` AND h.changed_at > now() - make_interval(days => $2)
+        ORDER BY h.changed_at, h.id`, modelID, days, requestMarket(w, r))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type modelPricePoint struct {
+			ItemID int `json:"item_id"`
+			pricePoint
+		}
+		history := []modelPricePoint{}
+		for rows.Next() {
+			var p modelPricePoint
+			if err := rows.Scan(&p.ItemID, &p.Price, &p.ChangedAt); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			history = append(history, p)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
+// getModelAvailability answers the buy box of a model page: for each size,
+// the retail SKUs on sale in it with their price and stock. Only sneakers
+// that are released and visible count; sizes of sold out SKUs are listed
+// with no stock so the size chart stays complete.
+func getModelAvailability(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modelID, err := strconv.Atoi(mux.Vars(r)["modelId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidModelID)
+			return
+		}
+
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM models WHERE id = $1)", modelID).Scan(&exists); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if !exists {
+			writeError(w, r, http.StatusNotFound, errCodeModelNotFound)
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT v.us_size, v.eu_size, s.id, v.id, v.sku, s.price, v.stock
+        FROM item_variants v
+        INNER JOIN sneakers s ON s.id = v.item_id
+        WHERE s.model_id = $1 AND `+itemVisible("s")+` AND `+itemOnSale("s", false)+` AND `+itemInMarket("s", "$2")+`
+        ORDER BY substring(v.us_size from '[0-9]+(?:\.[0-9]+)?')::numeric NULLS LAST, v.us_size, s.price, v.id`, modelID, requestMarket(w, r))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type offer struct {
+			ItemID    int    `json:"item_id"`
+			VariantID int    `json:"variant_id"`
+			SKU       string `json:"sku"`
+			Price     Money  `json:"price"`
+			Stock     int    `json:"stock"`
+		}
+		type size struct {
+			USSize      string  `json:"us_size"`
+			EUSize      string  `json:"eu_size"`
+			Stock       int     `json:"stock"`
+			LowestPrice *Money  `json:"lowest_price"`
+			Offers      []offer `json:"offers"`
+		}
+		sizes := []*size{}
+		for rows.Next() {
+			var us, eu string
+			var o offer
+			if err := rows.Scan(&us, &eu, &o.ItemID, &o.VariantID, &o.SKU, &o.Price, &o.Stock); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			if len(sizes) == 0 || sizes[len(sizes)-1].USSize != us {
+				sizes = append(sizes, &size{USSize: us, EUSize: eu, Offers: []offer{}})
+			}
+			s := sizes[len(sizes)-1]
+			s.Offers = append(s.Offers, o)
+			s.Stock += o.Stock
+			// Offers come cheapest first within a size
+			if o.Stock > 0 && s.LowestPrice == nil {
+				s.LowestPrice = &o.Price
+			}
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			ModelID int     `json:"model_id"`
+			Sizes   []*size `json:"sizes"`
+		}{modelID, sizes})
+	}
+}
+
+// decodeModel reads and validates a sneaker model from the request body.
+func decodeModel(w http.ResponseWriter, r *http.Request) (sneakerModel, bool) {
+	var m sneakerModel
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+		return m, false
+	}
+	m.Silhouette = strings.TrimSpace(m.Silhouette)
+	m.Colorway = strings.TrimSpace(m.Colorway)
+	m.StyleCode = normalizeCode(m.StyleCode)
+	if m.Silhouette == "" {
+		writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidModel)
+		return m, false
+	}
+	if m.ReleaseDate != nil {
+		if _, err := time.Parse(time.DateOnly, *m.ReleaseDate); err != nil {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidModel)
+			return m, false
+		}
+	}
+	return m, true
+}
+
+// modelWriteError maps the errors of writing a model to responses.
+func modelWriteError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case isUniqueViolation(err):
+		writeError(w, r, http.StatusConflict, errCodeModelExists)
+	case isForeignKeyViolation(err):
+		writeError(w, r, http.StatusUnprocessableEntity, errCodeBrandNotFound)
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, r, http.StatusNotFound, errCodeModelNotFound)
+	default:
+		serverError(w, r, err)
+	}
+}
+
+func createModel(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, ok := decodeModel(w, r)
+		if !ok {
+			return
+		}
+
+		m, err := scanModel(db.QueryRow(`
+        INSERT INTO models (brand_id, silhouette, colorway, style_code, release_date) VALUES ($1, $2, $3, $4, $5)
+        RETURNING `+modelColumns, m.BrandID, m.Silhouette, m.Colorway, m.StyleCode, m.ReleaseDate))
+		if err != nil {
+			modelWriteError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/models/%d", m.ID))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(m)
+	}
+}
+
+func updateModel(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modelID, err := strconv.Atoi(mux.Vars(r)["modelId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidModelID)
+			return
+		}
+		m, ok := decodeModel(w, r)
+		if !ok {
+			return
+		}
+
+		m, err = scanModel(db.QueryRow(`
+        UPDATE models
+        SET brand_id = $2, silhouette = $3, colorway = $4, style_code = $5, release_date = $6, updated_at = now()
+        WHERE id = $1
+        RETURNING `+modelColumns, modelID, m.BrandID, m.Silhouette, m.Colorway, m.StyleCode, m.ReleaseDate))
+		if err != nil {
+			modelWriteError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m)
+	}
+}
+
+// deleteModel removes a sneaker model; its SKUs are kept without a model.
+func deleteModel(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modelID, err := strconv.Atoi(mux.Vars(r)["modelId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidModelID)
+			return
+		}
+
+		res, err := db.Exec("DELETE FROM models WHERE id = $1", modelID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeModelNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
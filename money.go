@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// shopCurrency is the ISO 4217 code of the currency catalog prices are in.
+var shopCurrency = getenv("SHOP_CURRENCY", "EUR")
+
+// Money is an amount in the minor unit of its currency, e.g. 12999 EUR is
+// €129.99. Amounts are stored in plain integer columns of the shop currency;
+// Money scans from and binds to them directly.
+type Money struct {
+	Amount   int    `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// money returns amount in the shop currency.
+func money(amount int) Money {
+	return Money{Amount: amount, Currency: shopCurrency}
+}
+
+// currencyExponents lists the currencies whose minor unit is not a hundredth.
+var currencyExponents = map[string]int{
+	"BHD": 3, "CLP": 0, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3, "OMR": 3, "TND": 3, "VND": 0,
+}
+
+// String formats m for people, e.g. "129.99 EUR".
+func (m Money) String() string {
+	exp, ok := currencyExponents[m.Currency]
+	if !ok {
+		exp = 2
+	}
+	amount, sign := m.Amount, ""
+	if amount < 0 {
+		amount, sign = -amount, "-"
+	}
+	if exp == 0 {
+		return fmt.Sprintf("%s%d %s", sign, amount, m.Currency)
+	}
+	unit := 1
+	for range exp {
+		unit *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/unit, exp, amount%unit, m.Currency)
+}
+
+// parseMoney parses a decimal amount in the shop currency as people write it,
+// e.g. "129.99" or "129", into minor units.
+func parseMoney(s string) (Money, error) {
+	exp, ok := currencyExponents[shopCurrency]
+	if !ok {
+		exp = 2
+	}
+	whole, frac, _ := strings.Cut(strings.TrimSpace(s), ".")
+	if whole == "" || len(frac) > exp || strings.Trim(whole+frac, "0123456789") != "" {
+		return Money{}, fmt.Errorf("money: invalid amount %q", s)
+	}
+	amount, err := strconv.Atoi(whole + frac + strings.Repeat("0", exp-len(frac)))
+	if err != nil {
+		return Money{}, fmt.Errorf("money: invalid amount %q", s)
+	}
+	return money(amount), nil
+}
+
+func (m *Money) Scan(src any) error {
+	amount, ok := src.(int64)
+	if !ok {
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+	*m = money(int(amount))
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return int64(m.Amount), nil
+}
+
+// UnmarshalJSON accepts {"amount": 12999, "currency": "EUR"}. Older clients
+// send a bare number of whole units in the shop currency, e.g. 129.99, as
+// prices were before they moved to minor units; it is still read as such,
+// and logged so the remaining clients can be found.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' {
+		amount, err := parseMoney(string(data))
+		if err != nil {
+			return err
+		}
+		logAt(levelWarn, "money: deprecated bare amount %s read as %s", data, amount)
+		*m = amount
+		return nil
+	}
+
+	type plain Money
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*m = Money(p)
+	m.Currency = strings.ToUpper(strings.TrimSpace(m.Currency))
+	if m.Currency == "" {
+		m.Currency = shopCurrency
+	}
+	return nil
+}
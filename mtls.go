@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// adminRequiresMTLS rejects admin requests arriving without a verified client
+// certificate, making the mTLS listener the only way into the admin surface.
+var adminRequiresMTLS = getenv("ADMIN_REQUIRE_MTLS", "") == "true"
+
+// adminListenAddr is the address of the mTLS listener, disabled when empty.
+var adminListenAddr = getenv("ADMIN_LISTEN_ADDR", "")
+
+// hasClientCert reports whether the request came over the mTLS listener with
+// a client certificate signed by the configured CA.
+func hasClientCert(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// serveAdminTLS serves handler, the admin routes, on ADMIN_LISTEN_ADDR over
+// TLS, requiring client certificates signed by ADMIN_CLIENT_CA. Warehouse and
+// ERP integrations use it with their own certificates instead of, or in
+// addition to, the admin bearer token. It does nothing when ADMIN_LISTEN_ADDR
+// is not set.
+func serveAdminTLS(handler http.Handler) {
+	if adminListenAddr == "" {
+		return
+	}
+
+	pem, err := os.ReadFile(getenv("ADMIN_CLIENT_CA", ""))
+	if err != nil {
+		log.Fatalf("admin listener: client CA: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		log.Fatal("admin listener: client CA: no certificates found")
+	}
+
+	server := &http.Server{
+		Addr:    adminListenAddr,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+			MinVersion: tls.VersionTLS12,
+		},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	err = server.ListenAndServeTLS(getenv("ADMIN_TLS_CERT", ""), getenv("ADMIN_TLS_KEY", ""))
+	if !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("admin listener: %v", err)
+	}
+}
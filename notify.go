@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// notification is a message for a single customer.
+type notification struct {
+	Owner  string
+	Kind   string // e.g. "price_drop"
+	Title  string
+	Body   string
+	ItemID *int
+}
+
+// notifier delivers notifications. Channels such as email or push wrap or
+// replace the in-app inbox without changing the jobs that send notifications.
+type notifier interface {
+	Notify(ctx context.Context, n notification) error
+}
+
+// notify is the notifier used by background jobs.
+var notify notifier
+
+// inboxNotifier stores notifications in the in-app notification center
+// served by GET /me/notifications.
+type inboxNotifier struct {
+	db *sql.DB
+}
+
+func (n inboxNotifier) Notify(ctx context.Context, msg notification) error {
+	_, err := n.db.ExecContext(ctx, `
+    INSERT INTO notifications (owner, kind, title, body, item_id) VALUES ($1, $2, $3, $4, $5)`,
+		msg.Owner, msg.Kind, msg.Title, msg.Body, msg.ItemID)
+	return err
+}
+
+// getNotifications lists the caller's most recent notifications.
+func getNotifications(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		limit, ok := queryLimit(r, 50, 200)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT id, kind, title, body, item_id, created_at
+        FROM notifications
+        WHERE owner = $1
+        ORDER BY created_at DESC, id DESC
+        LIMIT $2`, owner, limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type entry struct {
+			ID        int64     `json:"id"`
+			Kind      string    `json:"kind"`
+			Title     string    `json:"title"`
+			Body      string    `json:"body"`
+			ItemID    *int      `json:"item_id"`
+			CreatedAt time.Time `json:"created_at"`
+		}
+		notifications := []entry{}
+		for rows.Next() {
+			var e entry
+			if err := rows.Scan(&e.ID, &e.Kind, &e.Title, &e.Body, &e.ItemID, &e.CreatedAt); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			notifications = append(notifications, e)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(notifications)
+	}
+}
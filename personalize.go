@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"slices"
+)
+
+// itemScorer scores catalog items for a caller; higher scores rank first.
+// Implementations can evolve from simple affinities to a learned model without
+// touching the handlers.
+type itemScorer interface {
+	Score(ctx context.Context, owner string, items []item) ([]float64, error)
+}
+
+// scorer is the itemScorer used for ?personalized=true.
+var scorer itemScorer
+
+// affinityScorer favours items sharing tags with the caller's favorites and
+// priced close to what they usually favorite.
+type affinityScorer struct {
+	db *sql.DB
+}
+
+// priceAffinityWeight balances price similarity against tag overlap.
+const priceAffinityWeight = 0.5
+
+func (s affinityScorer) Score(ctx context.Context, owner string, items []item) ([]float64, error) {
+	tagWeights := map[string]float64{}
+	rows, err := s.db.QueryContext(ctx, `
+    SELECT t.name, count(*)::float / (SELECT greatest(count(*), 1) FROM favorite WHERE owner = $1)
+    FROM favorite f
+    JOIN item_tags it ON it.item_id = f.item_id
+    JOIN tags t ON t.id = it.tag_id
+    WHERE f.owner = $1
+    GROUP BY t.name`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var weight float64
+		if err := rows.Scan(&name, &weight); err != nil {
+			return nil, err
+		}
+		tagWeights[name] = weight
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var meanPrice sql.NullFloat64
+	err = s.db.QueryRowContext(ctx, `
+    SELECT avg(s.price) FROM favorite f JOIN sneakers s ON s.id = f.item_id WHERE f.owner = $1`, owner).Scan(&meanPrice)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]float64, len(items))
+	for n, i := range items {
+		for _, t := range i.Tags {
+			scores[n] += tagWeights[t]
+		}
+		if meanPrice.Valid && meanPrice.Float64 > 0 {
+			distance := math.Abs(float64(i.Price.Amount)-meanPrice.Float64) / meanPrice.Float64
+			scores[n] += priceAffinityWeight * math.Max(0, 1-distance)
+		}
+	}
+	return scores, nil
+}
+
+// personalize reorders items by the scorer for owner. Equal scores keep the
+// requested sort order.
+func personalize(ctx context.Context, owner string, items []item) ([]item, error) {
+	scores, err := scorer.Score(ctx, owner, items)
+	if err != nil {
+		return nil, err
+	}
+
+	order := make([]int, len(items))
+	for n := range order {
+		order[n] = n
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		switch {
+		case scores[a] > scores[b]:
+			return -1
+		case scores[a] < scores[b]:
+			return 1
+		}
+		return 0
+	})
+
+	ranked := make([]item, len(items))
+	for n, idx := range order {
+		ranked[n] = items[idx]
+	}
+	return ranked, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// piiCipher encrypts personal data such as phone numbers before it is stored, on top of disk encryption. It uses envelope encryption: every
+// value gets its own random data key, which is stored next to the value
+// wrapped with a key-encryption key (KEK). Values record the ID of their KEK,
+// so KEKs can be rotated: new values use the first key of PII_KEY_IDS while
+// values under older keys stay readable as long as those keys are listed.
+type piiCipher struct {
+	activeKID string
+	keks      map[string]cipher.AEAD
+}
+
+// pii is the cipher used by encryptedString. It is nil until keys are
+// configured, in which case storing personal data fails.
+var pii *piiCipher
+
+// newPIICipher loads the KEKs listed in PII_KEY_IDS, e.g. "2024-06,2024-01",
+// from the secrets "pii_key_<id>", each a base64 encoded 32 byte AES key. It
+// returns nil when no keys are configured.
+func newPIICipher(ctx context.Context, secrets secretsProvider) (*piiCipher, error) {
+	ids := strings.Split(getenv("PII_KEY_IDS", ""), ",")
+	if ids[0] == "" {
+		return nil, nil
+	}
+
+	c := &piiCipher{activeKID: ids[0], keks: map[string]cipher.AEAD{}}
+	for _, kid := range ids {
+		encoded, err := secrets.Secret(ctx, "pii_key_"+kid)
+		if err != nil {
+			return nil, fmt.Errorf("pii_key_%s: %w", kid, err)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("pii_key_%s: must be 32 base64 encoded bytes", kid)
+		}
+		if c.keks[kid], err = newGCM(key); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with aead under a random nonce, prefixing the nonce.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("pii: ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+// Encrypt returns "v1:<kid>:<wrapped data key>:<ciphertext>".
+func (c *piiCipher) Encrypt(plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	// The KEK ID is bound to the wrapped key so it cannot be swapped
+	wrapped, err := seal(c.keks[c.activeKID], dataKey, []byte(c.activeKID))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(aead, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawStdEncoding
+	return "v1:" + c.activeKID + ":" + enc.EncodeToString(wrapped) + ":" + enc.EncodeToString(ciphertext), nil
+}
+
+func (c *piiCipher) Decrypt(value string) (string, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 || parts[0] != "v1" {
+		return "", errors.New("pii: unknown format")
+	}
+	kek, ok := c.keks[parts[1]]
+	if !ok {
+		return "", fmt.Errorf("pii: key %q is not configured", parts[1])
+	}
+
+	enc := base64.RawStdEncoding
+	wrapped, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(kek, wrapped, []byte(parts[1]))
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, ciphertext, nil)
+	return string(plaintext), err
+}
+
+// NeedsRotation reports whether value was encrypted under a KEK other than
+// the active one and should be re-encrypted.
+func (c *piiCipher) NeedsRotation(value string) bool {
+	return !strings.HasPrefix(value, "v1:"+c.activeKID+":")
+}
+
+// piiColumns are the encrypted columns, with the key of their table.
+var piiColumns = []struct{ table, key, column string }{
+	{"phones", "owner", "phone"},
+	{"phone_verifications", "owner", "phone"},
+}
+
+// piiRotationBatchSize bounds the rows re-encrypted per query.
+const piiRotationBatchSize = 500
+
+// rotatePII is the background job re-encrypting, under the active KEK, the
+// values of piiColumns still under an older one. Once it logs nothing left
+// to rotate, the older KEKs can be removed from PII_KEY_IDS.
+func rotatePII(db *sql.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if pii == nil {
+			return nil
+		}
+		for _, c := range piiColumns {
+			total := 0
+			for {
+				n, err := rotatePIIBatch(ctx, db, c.table, c.key, c.column)
+				if err != nil {
+					return fmt.Errorf("%s.%s: %w", c.table, c.column, err)
+				}
+				total += n
+				if n < piiRotationBatchSize {
+					break
+				}
+			}
+			if total > 0 {
+				logAt(levelInfo, "pii: re-encrypted %d %s.%s under key %s", total, c.table, c.column, pii.activeKID)
+			}
+		}
+		return nil
+	}
+}
+
+// rotatePIIBatch re-encrypts up to piiRotationBatchSize values of column and
+// returns how many it found. A value changed in the meantime is left alone:
+// it was written under the active KEK.
+func rotatePIIBatch(ctx context.Context, db *sql.DB, table, key, column string) (int, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %[2]s, %[3]s FROM %[1]s WHERE %[3]s NOT LIKE $1 LIMIT %[4]d",
+		table, key, column, piiRotationBatchSize), "v1:"+pii.activeKID+":%")
+	if err != nil {
+		return 0, err
+	}
+	type stale struct{ key, value string }
+	var values []stale
+	for rows.Next() {
+		var s stale
+		if err := rows.Scan(&s.key, &s.value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if pii.NeedsRotation(s.value) {
+			values = append(values, s)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, s := range values {
+		plaintext, err := pii.Decrypt(s.value)
+		if err != nil {
+			return 0, fmt.Errorf("%s %s: %w", key, s.key, err)
+		}
+		_, err = db.ExecContext(ctx, fmt.Sprintf("UPDATE %[1]s SET %[3]s = $1 WHERE %[2]s = $2 AND %[3]s = $3", table, key, column),
+			encryptedString(plaintext), s.key, s.value)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(values), nil
+}
+
+// encryptedString is a string column encrypted with pii. Store methods use it
+// for personal data so encryption stays out of the handlers: it is encrypted
+// when passed as a query argument and decrypted when scanned.
+type encryptedString string
+
+func (s encryptedString) Value() (driver.Value, error) {
+	if pii == nil {
+		return nil, errors.New("pii: encryption keys are not configured")
+	}
+	return pii.Encrypt(string(s))
+}
+
+func (s *encryptedString) Scan(src any) error {
+	var value string
+	switch v := src.(type) {
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("pii: cannot scan %T", src)
+	}
+	if pii == nil {
+		return errors.New("pii: encryption keys are not configured")
+	}
+	plaintext, err := pii.Decrypt(value)
+	*s = encryptedString(plaintext)
+	return err
+}
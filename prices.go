@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// pricePoint is a price a sneaker had from ChangedAt until the next point.
+type pricePoint struct {
+	Price     Money     `json:"price"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// getPriceHistory returns the price changes of a sneaker over the last ?days=
+// (365 by default) for price charts, starting with the price in effect at the
+// beginning of the period. lowest_30_days is the lowest price of the last 30
+// days, which has to be shown next to a price reduction in the EU.
+func getPriceHistory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+		days := 365
+		if raw := r.URL.Query().Get("days"); raw != "" {
+			if days, err = strconv.Atoi(raw); err != nil || days < 1 || days > 3650 {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidDays)
+				return
+			}
+		}
+
+		history := struct {
+			ItemID       int          `json:"item_id"`
+			Current      Money        `json:"current"`
+			Lowest30Days Money        `json:"lowest_30_days"`
+			History      []pricePoint `json:"history"`
+		}{ItemID: itemID, History: []pricePoint{}}
+		err = db.QueryRow("SELECT price FROM sneakers WHERE id = $1 AND "+itemVisible(""), itemID).Scan(&history.Current)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		// The price in effect when a period starts is the last change before it
+		err = db.QueryRow(`
+        SELECT coalesce(min(price), $2) FROM price_history
+        WHERE item_id = $1 AND changed_at >= (
+            SELECT coalesce(max(changed_at), '-infinity') FROM price_history
+            WHERE item_id = $1 AND changed_at <= now() - interval '30 days')`, itemID, history.Current).
+			Scan(&history.Lowest30Days)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT price, changed_at FROM price_history
+        WHERE item_id = $1 AND changed_at >= (
+            SELECT coalesce(max(changed_at), '-infinity') FROM price_history
+            WHERE item_id = $1 AND changed_at <= now() - make_interval(days => $2))
+        ORDER BY changed_at, id`, itemID, days)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p pricePoint
+			if err := rows.Scan(&p.Price, &p.ChangedAt); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			history.History = append(history.History, p)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// All money math of carts and orders lives in this file so the total shown
+// in a cart preview is computed exactly like the one charged at checkout.
+// Amounts are Money in the shop currency, and every rounding happens here,
+// half away from zero.
+
+// orderLine is a line of a cart or an order. Quantity and UnitPrice are
+// inputs; the remaining fields are filled in by calculateOrder.
+type orderLine struct {
+	ItemID    int    `json:"item_id"`
+	VariantID *int   `json:"variant_id,omitempty"`
+	Title     string `json:"title"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice Money  `json:"unit_price"`
+	Subtotal  Money  `json:"subtotal"`
+	Discount  Money  `json:"discount"`
+	Tax       Money  `json:"tax"`
+	Total     Money  `json:"total"`
+}
+
+// orderDiscount reduces the subtotal of an order, either by a percentage in
+// basis points (1000 is 10%) or by a fixed amount. It never exceeds the
+// subtotal.
+type orderDiscount struct {
+	Code        string `json:"code,omitempty"`
+	BasisPoints int    `json:"basis_points,omitempty"`
+	Amount      *Money `json:"amount,omitempty"`
+}
+
+// pricingRules are the shop-wide settings the totals depend on.
+type pricingRules struct {
+	Currency string
+	// TaxBasisPoints is the sales tax or VAT rate, e.g. 2000 for 20%.
+	TaxBasisPoints int
+	// TaxIncluded is set when catalog prices already include tax, as in the
+	// EU; otherwise tax is added on top of the total.
+	TaxIncluded bool
+	ShippingFee int
+	// FreeShippingFrom waives the standard shipping fee for discounted
+	// subtotals of at least this amount; 0 never waives it.
+	FreeShippingFrom int
+	// ExpressShippingFee is the fee of express delivery; 0 does not offer it.
+	ExpressShippingFee int
+	// FreeShipping waives the standard shipping fee of every order, a
+	// membership perk.
+	FreeShipping bool
+	// DutyBasisPoints is the rate of the import duties and taxes of the
+	// delivery country. They are collected at checkout so customers have
+	// nothing left to pay on delivery.
+	DutyBasisPoints int
+	// DutyFreeUpTo is the value below which the delivery country charges no
+	// duties; discounted subtotals up to it are duty free.
+	DutyFreeUpTo int
+	// StandardDelivery and ExpressDelivery are the carrier lead times of the
+	// shipping methods, used to estimate delivery dates.
+	StandardDelivery deliveryDays
+	ExpressDelivery  deliveryDays
+}
+
+// currentPricingRules reads the pricing rules from SHOP_CURRENCY,
+// TAX_BASIS_POINTS, PRICES_INCLUDE_TAX, SHIPPING_FEE, FREE_SHIPPING_FROM,
+// EXPRESS_SHIPPING_FEE and the STANDARD_ and EXPRESS_DELIVERY_MIN_DAYS and
+// _MAX_DAYS lead times.
+func currentPricingRules() pricingRules {
+	return pricingRules{
+		Currency:           shopCurrency,
+		TaxBasisPoints:     getenvInt("TAX_BASIS_POINTS", 0),
+		TaxIncluded:        os.Getenv("PRICES_INCLUDE_TAX") != "false",
+		ShippingFee:        getenvInt("SHIPPING_FEE", 0),
+		FreeShippingFrom:   getenvInt("FREE_SHIPPING_FROM", 0),
+		ExpressShippingFee: getenvInt("EXPRESS_SHIPPING_FEE", 0),
+		StandardDelivery:   deliveryDays{getenvInt("STANDARD_DELIVERY_MIN_DAYS", 3), getenvInt("STANDARD_DELIVERY_MAX_DAYS", 5)},
+		ExpressDelivery:    deliveryDays{getenvInt("EXPRESS_DELIVERY_MIN_DAYS", 1), getenvInt("EXPRESS_DELIVERY_MAX_DAYS", 2)},
+	}
+}
+
+// shippingOption is a delivery method offered for an order.
+type shippingOption struct {
+	ID  string `json:"id"`
+	Fee Money  `json:"fee"`
+	// EstimatedDelivery is set by estimateDelivery.
+	EstimatedDelivery *deliveryEstimate `json:"estimated_delivery,omitempty"`
+}
+
+// shippingOptions returns the delivery methods offered for an order with the
+// given discounted subtotal, standard delivery first.
+func (r pricingRules) shippingOptions(discounted int) []shippingOption {
+	standard := shippingOption{ID: "standard", Fee: Money{r.ShippingFee, r.Currency}}
+	if r.FreeShipping || r.FreeShippingFrom > 0 && discounted >= r.FreeShippingFrom {
+		standard.Fee.Amount = 0
+	}
+	options := []shippingOption{standard}
+	if r.ExpressShippingFee > 0 {
+		options = append(options, shippingOption{ID: "express", Fee: Money{r.ExpressShippingFee, r.Currency}})
+	}
+	return options
+}
+
+// orderTotals is the breakdown of an order. Line amounts always add up to
+// the order amounts, so receipts and refunds per line stay consistent.
+type orderTotals struct {
+	Currency string         `json:"currency"`
+	Lines    []orderLine    `json:"lines"`
+	Discount *orderDiscount `json:"discount,omitempty"`
+	Subtotal Money          `json:"subtotal"`
+	// DiscountTotal is the amount taken off the subtotal.
+	DiscountTotal   Money            `json:"discount_total"`
+	ShippingOption  string           `json:"shipping_option"`
+	ShippingOptions []shippingOption `json:"shipping_options"`
+	Shipping        Money            `json:"shipping"`
+	// EstimatedDelivery is the delivery estimate of the chosen shipping
+	// option, set by estimateDelivery.
+	EstimatedDelivery *deliveryEstimate `json:"estimated_delivery,omitempty"`
+	// Duties are the estimated import duties of a cross-border order.
+	Duties Money `json:"duties"`
+	// Tax is the tax added on top of, or included in, the total.
+	Tax         Money `json:"tax"`
+	TaxIncluded bool  `json:"tax_included"`
+	Total       Money `json:"total"`
+	// StoreCredit is the part of the total paid with store credit, and
+	// AmountDue what is left to pay by card.
+	StoreCredit *Money `json:"store_credit,omitempty"`
+	AmountDue   Money  `json:"amount_due"`
+}
+
+// calculateOrder computes the totals of lines with an optional discount,
+// delivered with the shipping option of the given ID ("" for standard). The
+// discount is spread over the lines in proportion to their subtotal; tax is
+// computed per line and on shipping. Import duties are estimated on the
+// discounted subtotal. It reports false when the shipping option is not
+// offered.
+func calculateOrder(lines []orderLine, discount *orderDiscount, shipping string, rules pricingRules) (orderTotals, bool) {
+	t := orderTotals{
+		Currency:    rules.Currency,
+		Lines:       make([]orderLine, len(lines)),
+		Discount:    discount,
+		TaxIncluded: rules.TaxIncluded,
+	}
+	subtotal := 0
+	for n, l := range lines {
+		l.Subtotal = Money{l.UnitPrice.Amount * l.Quantity, rules.Currency}
+		subtotal += l.Subtotal.Amount
+		t.Lines[n] = l
+	}
+
+	discountTotal := 0
+	if discount != nil {
+		if discount.Amount != nil {
+			discountTotal = discount.Amount.Amount
+		}
+		discountTotal = min(discountTotal+roundDiv(subtotal*discount.BasisPoints, 10000), subtotal)
+	}
+	allocate(discountTotal, t.Lines)
+
+	discounted := subtotal - discountTotal
+	t.ShippingOptions = rules.shippingOptions(discounted)
+	t.ShippingOption = t.ShippingOptions[0].ID
+	if shipping != "" {
+		t.ShippingOption = shipping
+	}
+	found := false
+	for _, o := range t.ShippingOptions {
+		if o.ID == t.ShippingOption {
+			t.Shipping, found = o.Fee, true
+		}
+	}
+	if !found {
+		return t, false
+	}
+
+	tax := 0
+	for n := range t.Lines {
+		l := &t.Lines[n]
+		lineTax := taxOf(l.Subtotal.Amount-l.Discount.Amount, rules)
+		l.Tax = Money{lineTax, rules.Currency}
+		l.Total = Money{l.Subtotal.Amount - l.Discount.Amount, rules.Currency}
+		if !rules.TaxIncluded {
+			l.Total.Amount += lineTax
+		}
+		tax += lineTax
+	}
+	tax += taxOf(t.Shipping.Amount, rules)
+
+	duties := 0
+	if rules.DutyBasisPoints > 0 && discounted > rules.DutyFreeUpTo {
+		duties = roundDiv(discounted*rules.DutyBasisPoints, 10000)
+	}
+
+	total := discounted + t.Shipping.Amount + duties
+	if !rules.TaxIncluded {
+		total += tax
+	}
+	t.Subtotal = Money{subtotal, rules.Currency}
+	t.DiscountTotal = Money{discountTotal, rules.Currency}
+	t.Tax = Money{tax, rules.Currency}
+	t.Duties = Money{duties, rules.Currency}
+	t.Total = Money{total, rules.Currency}
+	t.AmountDue = t.Total
+	return t, true
+}
+
+// applyStoreCredit pays as much of the total as balance covers with store
+// credit.
+func (t *orderTotals) applyStoreCredit(balance int) {
+	credit := Money{min(balance, t.Total.Amount), t.Total.Currency}
+	t.StoreCredit = &credit
+	t.AmountDue.Amount = t.Total.Amount - credit.Amount
+}
+
+// taxOf returns the tax due on amount: the part of it that is tax when
+// prices include tax, the tax to add otherwise.
+func taxOf(amount int, rules pricingRules) int {
+	if rules.TaxIncluded {
+		return roundDiv(amount*rules.TaxBasisPoints, 10000+rules.TaxBasisPoints)
+	}
+	return roundDiv(amount*rules.TaxBasisPoints, 10000)
+}
+
+// allocate spreads amount over the lines in proportion to their subtotal.
+// Cents left over by rounding down go to the lines with the largest
+// remainders, earlier lines first, so the shares add up to amount exactly.
+func allocate(amount int, lines []orderLine) {
+	total := 0
+	for n, l := range lines {
+		lines[n].Discount = Money{0, l.Subtotal.Currency}
+		total += l.Subtotal.Amount
+	}
+	if amount == 0 || total == 0 {
+		return
+	}
+
+	remainders := make([]int, len(lines))
+	allocated := 0
+	for n := range lines {
+		lines[n].Discount.Amount = amount * lines[n].Subtotal.Amount / total
+		remainders[n] = amount * lines[n].Subtotal.Amount % total
+		allocated += lines[n].Discount.Amount
+	}
+
+	order := make([]int, len(lines))
+	for n := range order {
+		order[n] = n
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for _, n := range order[:amount-allocated] {
+		lines[n].Discount.Amount++
+	}
+}
+
+// roundDiv divides the non-negative a by b, rounding half away from zero.
+func roundDiv(a, b int) int {
+	return (2*a + b) / (2 * b)
+}
+
+// estimateDelivery sets the delivery dates of each shipping option, and of
+// the chosen one, for an order placed at now.
+func (t *orderTotals) estimateDelivery(rules pricingRules, now time.Time) {
+	for n := range t.ShippingOptions {
+		o := &t.ShippingOptions[n]
+		days := rules.StandardDelivery
+		if o.ID == "express" {
+			days = rules.ExpressDelivery
+		}
+		o.EstimatedDelivery = estimateDelivery(now, days)
+		if o.ID == t.ShippingOption {
+			t.EstimatedDelivery = o.EstimatedDelivery
+		}
+	}
+}
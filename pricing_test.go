@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the pricing tests")
+
+// pricingCase is the input of a calculateOrder golden test, read from
+// testdata/pricing/<name>.json.
+type pricingCase struct {
+	Lines    []orderLine    `json:"lines"`
+	Discount *orderDiscount `json:"discount"`
+	Shipping string         `json:"shipping"`
+	Rules    pricingRules   `json:"rules"`
+	// StoreCredit is the caller's balance, applied when set.
+	StoreCredit *int `json:"store_credit"`
+}
+
+// TestCalculateOrder compares the totals of each case in testdata/pricing
+// with its .golden file. Run with -update to rewrite them after a deliberate
+// change, and review the diff.
+func TestCalculateOrder(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "pricing", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no test cases in testdata/pricing")
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".json")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var c pricingCase
+			if err := json.Unmarshal(data, &c); err != nil {
+				t.Fatal(err)
+			}
+			if c.Rules.Currency == "" {
+				c.Rules.Currency = shopCurrency
+			}
+
+			totals, ok := calculateOrder(c.Lines, c.Discount, c.Shipping, c.Rules)
+			if ok && c.StoreCredit != nil {
+				totals.applyStoreCredit(*c.StoreCredit)
+			}
+			got, err := json.MarshalIndent(struct {
+				OK     bool        `json:"ok"`
+				Totals orderTotals `json:"totals"`
+			}{ok, totals}, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(input, ".json") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("totals differ from %s:\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    int
+		subtotals []int
+		want      []int
+	}{
+		{"nothing", 0, []int{1000, 2000}, []int{0, 0}},
+		{"proportional", 300, []int{1000, 2000}, []int{100, 200}},
+		// 1000 / 3 leaves a cent, which goes to the first line
+		{"equal remainders", 1000, []int{1000, 1000, 1000}, []int{334, 333, 333}},
+		// Remainders 2/7, 3/7 and 2/7 of a cent: the largest one is rounded up
+		{"largest remainder", 1, []int{2, 3, 2}, []int{0, 1, 0}},
+		{"two cents left", 5, []int{1, 1, 1}, []int{2, 2, 1}},
+		{"whole subtotal", 4999, []int{1999, 3000}, []int{1999, 3000}},
+		{"free lines", 500, []int{0, 1000}, []int{0, 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := make([]orderLine, len(tt.subtotals))
+			for n, s := range tt.subtotals {
+				lines[n].Subtotal = money(s)
+			}
+			allocate(tt.amount, lines)
+			sum := 0
+			for n, l := range lines {
+				if l.Discount.Amount != tt.want[n] {
+					t.Errorf("line %d: discount %d, want %d", n, l.Discount.Amount, tt.want[n])
+				}
+				sum += l.Discount.Amount
+			}
+			if sum != tt.amount {
+				t.Errorf("discounts add up to %d, want %d", sum, tt.amount)
+			}
+		})
+	}
+}
+
+func TestTaxOf(t *testing.T) {
+	tests := []struct {
+		name        string
+		amount      int
+		basisPoints int
+		included    bool
+		want        int
+	}{
+		{"included 20%", 12000, 2000, true, 2000},
+		// 12999 * 2000 / 12000 = 2166.5, rounded half away from zero
+		{"included rounds half up", 12999, 2000, true, 2167},
+		{"included 19%", 11900, 1900, true, 1900},
+		{"exclusive 8.25%", 10000, 825, false, 825},
+		// 1999 * 825 / 10000 = 164.9175
+		{"exclusive rounds", 1999, 825, false, 165},
+		// 10 * 2500 / 10000 = 2.5
+		{"exclusive rounds half up", 10, 2500, false, 3},
+		{"no tax", 5000, 0, false, 0},
+		{"nothing to tax", 0, 2000, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := taxOf(tt.amount, pricingRules{TaxBasisPoints: tt.basisPoints, TaxIncluded: tt.included})
+			if got != tt.want {
+				t.Errorf("taxOf(%d) = %d, want %d", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyStoreCredit(t *testing.T) {
+	tests := []struct {
+		name           string
+		total, balance int
+		credit, amtDue int
+	}{
+		{"partial", 10000, 2500, 2500, 7500},
+		{"covers the total", 10000, 15000, 10000, 0},
+		{"exact", 10000, 10000, 10000, 0},
+		{"no balance", 10000, 0, 0, 10000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totals := orderTotals{Total: money(tt.total), AmountDue: money(tt.total)}
+			totals.applyStoreCredit(tt.balance)
+			if totals.StoreCredit == nil || totals.StoreCredit.Amount != tt.credit {
+				t.Errorf("store credit %v, want %d", totals.StoreCredit, tt.credit)
+			}
+			if totals.AmountDue.Amount != tt.amtDue {
+				t.Errorf("amount due %d, want %d", totals.AmountDue.Amount, tt.amtDue)
+			}
+			if totals.StoreCredit != nil && totals.StoreCredit.Currency != totals.Total.Currency {
+				t.Errorf("store credit in %s, total in %s", totals.StoreCredit.Currency, totals.Total.Currency)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// rehostBatch is the number of images downloaded per run of the job.
+const rehostBatch = 10
+
+// rehostClient downloads externally hosted images. Its timeout covers the
+// whole download.
+var rehostClient = &http.Client{Timeout: 30 * time.Second}
+
+// startImageRehost queues every externally hosted gallery image, including
+// those that failed before, to be copied into the media store by the rehost
+// job, and returns the progress.
+func startImageRehost(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := db.ExecContext(r.Context(), `
+        UPDATE item_images SET rehost_status = 'queued', rehost_error = NULL
+        WHERE object_key IS NULL AND url ~* '^https?://' AND rehost_status IS DISTINCT FROM 'queued'`)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		writeRehostProgress(w, r, db, http.StatusAccepted)
+	}
+}
+
+// getImageRehost reports the progress of the rehost job: images queued,
+// rehosted and failed, how many rehosted images still wait for their
+// thumbnails, and the latest failures with their reason.
+func getImageRehost(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeRehostProgress(w, r, db, http.StatusOK)
+	}
+}
+
+func writeRehostProgress(w http.ResponseWriter, r *http.Request, db *sql.DB, status int) {
+	limit, ok := queryLimit(r, 50, 500)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+		return
+	}
+
+	type failure struct {
+		ImageID     int       `json:"image_id"`
+		ItemID      int       `json:"item_id"`
+		URL         string    `json:"url"`
+		Error       string    `json:"error"`
+		AttemptedAt time.Time `json:"attempted_at"`
+	}
+	progress := struct {
+		Queued            int       `json:"queued"`
+		Rehosted          int       `json:"rehosted"`
+		Failed            int       `json:"failed"`
+		ThumbnailsPending int       `json:"thumbnails_pending"`
+		Failures          []failure `json:"failures"`
+	}{Failures: []failure{}}
+	err := db.QueryRowContext(r.Context(), `
+    SELECT count(*) FILTER (WHERE rehost_status = 'queued'),
+           count(*) FILTER (WHERE rehost_status = 'done'),
+           count(*) FILTER (WHERE rehost_status = 'failed'),
+           count(*) FILTER (WHERE rehost_status = 'done' AND renditions IS NULL)
+    FROM item_images WHERE rehost_status IS NOT NULL`).
+		Scan(&progress.Queued, &progress.Rehosted, &progress.Failed, &progress.ThumbnailsPending)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), `
+    SELECT id, item_id, url, rehost_error, rehost_attempted_at FROM item_images
+    WHERE rehost_status = 'failed'
+    ORDER BY rehost_attempted_at DESC, id
+    LIMIT $1`, limit)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f failure
+		if err := rows.Scan(&f.ImageID, &f.ItemID, &f.URL, &f.Error, &f.AttemptedAt); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		progress.Failures = append(progress.Failures, f)
+	}
+	if err := rows.Err(); err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(progress)
+}
+
+// rehostImages copies queued images into the media store and points their
+// records at the copy, including sneakers.imageUrl for primary images.
+// Thumbnails are then made by the renditions job, like for uploads. An image
+// that cannot be downloaded is marked failed with the reason, and is only
+// retried when the rehost is started again.
+func rehostImages(db *sql.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `
+        SELECT id, item_id, url FROM item_images
+        WHERE rehost_status = 'queued' AND object_key IS NULL
+        ORDER BY id
+        LIMIT $1`, rehostBatch)
+		if err != nil {
+			return err
+		}
+		type pending struct {
+			id, itemID int
+			url        string
+		}
+		var images []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.itemID, &p.url); err != nil {
+				rows.Close()
+				return err
+			}
+			images = append(images, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, p := range images {
+			key, err := rehostImage(ctx, p.itemID, p.url)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("rehost image %d: %v", p.id, err)
+				_, err := db.ExecContext(ctx, `
+                UPDATE item_images SET rehost_status = 'failed', rehost_error = $3, rehost_attempted_at = now()
+                WHERE id = $1 AND url = $2`, p.id, p.url, err.Error())
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			stale := false
+			err = NewStore(db).WithTx(ctx, func(tx Store) error {
+				var primary bool
+				err := tx.q.QueryRowContext(ctx, `
+                UPDATE item_images
+                SET url = $3, object_key = $4, renditions = NULL,
+                    rehost_status = 'done', rehost_error = NULL, rehost_attempted_at = now()
+                WHERE id = $1 AND url = $2 AND object_key IS NULL
+                RETURNING is_primary`, p.id, p.url, media.URL(key), key).Scan(&primary)
+				if errors.Is(err, sql.ErrNoRows) {
+					stale = true
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if primary {
+					if _, err := tx.q.ExecContext(ctx, "UPDATE sneakers SET imageUrl = $2 WHERE id = $1", p.itemID, media.URL(key)); err != nil {
+						return err
+					}
+				}
+				_, err = tx.TouchItem(ctx, p.itemID)
+				return err
+			})
+			if err != nil || stale {
+				// The image was changed or deleted during the download; the copy
+				// is not used
+				if err := media.Delete(ctx, key); err != nil {
+					log.Printf("rehost image %d: %v", p.id, err)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// rehostImage downloads the image at url and stores it like an upload of
+// itemID, returning its key. Errors describe the failure for admins.
+func rehostImage(ctx context.Context, itemID int, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := rehostClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("download: %w", err)
+	}
+	if len(data) > maxImageBytes {
+		return "", fmt.Errorf("image larger than %d MB", maxImageBytes>>20)
+	}
+
+	// As for uploads, the type is sniffed rather than taken from the response
+	contentType := http.DetectContentType(data)
+	ext, ok := imageTypes[contentType]
+	if !ok {
+		return "", fmt.Errorf("unsupported image type %s", contentType)
+	}
+
+	name := make([]byte, 16)
+	rand.Read(name)
+	key := fmt.Sprintf("items/%d/%x%s", itemID, name, ext)
+	if err := media.Put(ctx, key, contentType, data); err != nil {
+		return "", fmt.Errorf("store: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// releaseReminderLead is how long before a drop reminders are sent.
+var releaseReminderLead = getenvDuration("RELEASE_REMINDER_LEAD", 30*time.Minute)
+
+// release is an upcoming sneaker drop on the release calendar.
+type release struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	ItemID      *int      `json:"item_id"`
+	ReleaseAt   time.Time `json:"release_at"`
+	ImageURL    string    `json:"image_url"`
+	RaffleURL   *string   `json:"raffle_url"`
+	Description string    `json:"description"`
+
+	RaffleOpensAt  *time.Time `json:"raffle_opens_at"`
+	RaffleClosesAt *time.Time `json:"raffle_closes_at"`
+	SoldOutAt      *time.Time `json:"sold_out_at"`
+}
+
+const releaseColumns = "id, title, item_id, release_at, image_url, raffle_url, description, raffle_opens_at, raffle_closes_at, sold_out_at"
+
+func scanRelease(row rowScanner) (release, error) {
+	var rel release
+	err := row.Scan(&rel.ID, &rel.Title, &rel.ItemID, &rel.ReleaseAt, &rel.ImageURL, &rel.RaffleURL, &rel.Description,
+		&rel.RaffleOpensAt, &rel.RaffleClosesAt, &rel.SoldOutAt)
+	return rel, err
+}
+
+// Drop phases reported by the status endpoint.
+const (
+	phaseUpcoming   = "upcoming"
+	phaseRaffleOpen = "raffle_open"
+	phaseLive       = "live"
+	phaseSoldOut    = "sold_out"
+)
+
+// phaseAt returns the phase of the drop at now and when that phase ends
+// (zero when it never does).
+func (rel release) phaseAt(now time.Time) (string, time.Time) {
+	switch {
+	case rel.SoldOutAt != nil && !now.Before(*rel.SoldOutAt):
+		return phaseSoldOut, time.Time{}
+	case !now.Before(rel.ReleaseAt):
+		if rel.SoldOutAt != nil {
+			return phaseLive, *rel.SoldOutAt
+		}
+		return phaseLive, time.Time{}
+	case rel.RaffleOpensAt != nil && !now.Before(*rel.RaffleOpensAt) &&
+		(rel.RaffleClosesAt == nil || now.Before(*rel.RaffleClosesAt)):
+		if rel.RaffleClosesAt != nil {
+			return phaseRaffleOpen, *rel.RaffleClosesAt
+		}
+		return phaseRaffleOpen, rel.ReleaseAt
+	case rel.RaffleOpensAt != nil && now.Before(*rel.RaffleOpensAt):
+		return phaseUpcoming, *rel.RaffleOpensAt
+	}
+	return phaseUpcoming, rel.ReleaseAt
+}
+
+// getReleases lists drops in ?month=YYYY-MM, or upcoming drops when no month is given.
+func getReleases(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to := time.Now(), time.Time{}
+		if raw := r.URL.Query().Get("month"); raw != "" {
+			month, err := time.Parse("2006-01", raw)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidMonth)
+				return
+			}
+			from, to = month, month.AddDate(0, 1, 0)
+		}
+
+		query := "SELECT " + releaseColumns + " FROM releases WHERE release_at >= $1"
+		args := []any{from}
+		if !to.IsZero() {
+			query += " AND release_at < $2"
+			args = append(args, to)
+		}
+		query += " ORDER BY release_at, id"
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		releases := []release{}
+		for rows.Next() {
+			rel, err := scanRelease(rows)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			releases = append(releases, rel)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(releases)
+	}
+}
+
+// getRelease returns a single release.
+func getRelease(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		releaseID, err := strconv.Atoi(mux.Vars(r)["releaseId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidReleaseID)
+			return
+		}
+
+		rel, err := scanRelease(db.QueryRow("SELECT "+releaseColumns+" FROM releases WHERE id = $1", releaseID))
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeReleaseNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rel)
+	}
+}
+
+// getReleaseStatus is polled by clients during a drop. It reports the phase and
+// the time remaining according to the server clock, so countdowns do not depend
+// on the device clock. For members the raffle opens memberEarlyAccess earlier.
+func getReleaseStatus(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		releaseID, err := strconv.Atoi(mux.Vars(r)["releaseId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidReleaseID)
+			return
+		}
+
+		rel, err := scanRelease(db.QueryRow("SELECT "+releaseColumns+" FROM releases WHERE id = $1", releaseID))
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeReleaseNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		perks, err := memberPerks(r.Context(), db, r)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if perks.EarlyAccess && rel.RaffleOpensAt != nil {
+			opensAt := rel.RaffleOpensAt.Add(-memberEarlyAccess)
+			rel.RaffleOpensAt = &opensAt
+		}
+
+		now := time.Now()
+		phase, phaseEndsAt := rel.phaseAt(now)
+		status := struct {
+			ReleaseID        int        `json:"release_id"`
+			Phase            string     `json:"phase"`
+			ServerTime       time.Time  `json:"server_time"`
+			ReleaseAt        time.Time  `json:"release_at"`
+			SecondsRemaining int64      `json:"seconds_remaining"`
+			PhaseEndsAt      *time.Time `json:"phase_ends_at"`
+		}{
+			ReleaseID:        rel.ID,
+			Phase:            phase,
+			ServerTime:       now.UTC(),
+			ReleaseAt:        rel.ReleaseAt,
+			SecondsRemaining: max(0, int64(rel.ReleaseAt.Sub(now).Seconds())),
+		}
+		if !phaseEndsAt.IsZero() {
+			status.PhaseEndsAt = &phaseEndsAt
+		}
+
+		if perks.EarlyAccess {
+			w.Header().Set("Cache-Control", cacheNoStore.header())
+		} else {
+			w.Header().Set("Cache-Control", cachePolling.header())
+		}
+		w.Header().Add("Vary", "X-User-ID")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// decodeRelease reads and validates a release from the request body.
+func decodeRelease(w http.ResponseWriter, r *http.Request) (release, bool) {
+	var rel release
+	if err := json.NewDecoder(r.Body).Decode(&rel); err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+		return rel, false
+	}
+	rel.Title = strings.TrimSpace(rel.Title)
+	if rel.Title == "" || rel.ReleaseAt.IsZero() ||
+		(rel.RaffleOpensAt != nil && rel.RaffleClosesAt != nil && !rel.RaffleClosesAt.After(*rel.RaffleOpensAt)) {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRelease)
+		return rel, false
+	}
+	return rel, true
+}
+
+func createRelease(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rel, ok := decodeRelease(w, r)
+		if !ok {
+			return
+		}
+
+		err := db.QueryRow(`
+        INSERT INTO releases (title, item_id, release_at, image_url, raffle_url, description, raffle_opens_at, raffle_closes_at, sold_out_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING id`, rel.Title, rel.ItemID, rel.ReleaseAt, rel.ImageURL, rel.RaffleURL, rel.Description,
+			rel.RaffleOpensAt, rel.RaffleClosesAt, rel.SoldOutAt).Scan(&rel.ID)
+		if isForeignKeyViolation(err) {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/releases/%d", rel.ID))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rel)
+	}
+}
+
+func updateRelease(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		releaseID, err := strconv.Atoi(mux.Vars(r)["releaseId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidReleaseID)
+			return
+		}
+		rel, ok := decodeRelease(w, r)
+		if !ok {
+			return
+		}
+		rel.ID = releaseID
+
+		// Moving the date re-arms reminders that were already sent
+		res, err := db.Exec(`
+        UPDATE releases
+        SET title = $2, item_id = $3, release_at = $4, image_url = $5, raffle_url = $6, description = $7,
+            raffle_opens_at = $8, raffle_closes_at = $9, sold_out_at = $10
+        WHERE id = $1`, rel.ID, rel.Title, rel.ItemID, rel.ReleaseAt, rel.ImageURL, rel.RaffleURL, rel.Description,
+			rel.RaffleOpensAt, rel.RaffleClosesAt, rel.SoldOutAt)
+		if isForeignKeyViolation(err) {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeReleaseNotFound)
+			return
+		}
+		if _, err := db.Exec("UPDATE release_reminders SET notified_at = NULL WHERE release_id = $1", rel.ID); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rel)
+	}
+}
+
+func deleteRelease(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		releaseID, err := strconv.Atoi(mux.Vars(r)["releaseId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidReleaseID)
+			return
+		}
+
+		res, err := db.Exec("DELETE FROM releases WHERE id = $1", releaseID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeReleaseNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// putReleaseReminder subscribes the caller to a reminder shortly before the drop.
+func putReleaseReminder(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		releaseID, err := strconv.Atoi(mux.Vars(r)["releaseId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidReleaseID)
+			return
+		}
+
+		_, err = db.Exec(`
+        INSERT INTO release_reminders (owner, release_id) VALUES ($1, $2)
+        ON CONFLICT DO NOTHING`, owner, releaseID)
+		if isForeignKeyViolation(err) {
+			writeError(w, r, http.StatusNotFound, errCodeReleaseNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func deleteReleaseReminder(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		releaseID, err := strconv.Atoi(mux.Vars(r)["releaseId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidReleaseID)
+			return
+		}
+
+		if _, err := db.Exec("DELETE FROM release_reminders WHERE owner = $1 AND release_id = $2", owner, releaseID); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// sendReleaseReminders notifies subscribers of drops starting within
+// releaseReminderLead. Each reminder is sent once.
+func sendReleaseReminders(db *sql.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `
+        SELECT rr.owner, rel.id, rel.title, rel.item_id, rel.release_at
+        FROM release_reminders rr
+        INNER JOIN releases rel ON rel.id = rr.release_id
+        WHERE rr.notified_at IS NULL
+          AND rel.release_at > now()
+          AND rel.release_at <= now() + $1 * interval '1 second'`, releaseReminderLead.Seconds())
+		if err != nil {
+			return err
+		}
+		type reminder struct {
+			owner     string
+			releaseID int
+			title     string
+			itemID    *int
+			releaseAt time.Time
+		}
+		var reminders []reminder
+		for rows.Next() {
+			var rm reminder
+			if err := rows.Scan(&rm.owner, &rm.releaseID, &rm.title, &rm.itemID, &rm.releaseAt); err != nil {
+				rows.Close()
+				return err
+			}
+			reminders = append(reminders, rm)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, rm := range reminders {
+			err := notify.Notify(ctx, notification{
+				Owner:  rm.owner,
+				Kind:   "release_reminder",
+				Title:  fmt.Sprintf("Dropping soon: %s", rm.title),
+				Body:   fmt.Sprintf("%s drops at %s.", rm.title, rm.releaseAt.UTC().Format("15:04 MST")),
+				ItemID: rm.itemID,
+			})
+			if err != nil {
+				return err
+			}
+			_, err = db.ExecContext(ctx, "UPDATE release_reminders SET notified_at = now() WHERE owner = $1 AND release_id = $2", rm.owner, rm.releaseID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"path"
+	"strings"
+)
+
+// renditionSizes are the widths generated for uploaded images, so clients can
+// pick a size instead of downscaling the original.
+var renditionSizes = []struct {
+	name  string
+	width int
+}{
+	{"thumb", 160},
+	{"card", 480},
+	{"zoom", 1200},
+}
+
+// renditionBatch is the number of images processed per run of the job.
+const renditionBatch = 20
+
+// generateRenditions resizes uploaded images that have no renditions yet and
+// records their URLs by size name. Sizes at least as wide as the original
+// point at the original. Only JPEG and PNG uploads can be decoded with the
+// standard library; other formats get an empty set so they are not retried.
+// Renditions are always JPEG, as there is no WebP or AVIF encoder available.
+func generateRenditions(db *sql.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `
+        SELECT id, item_id, url, object_key FROM item_images
+        WHERE object_key IS NOT NULL AND renditions IS NULL
+        ORDER BY id
+        LIMIT $1`, renditionBatch)
+		if err != nil {
+			return err
+		}
+		type pending struct {
+			id, itemID int
+			url, key   string
+		}
+		var images []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.itemID, &p.url, &p.key); err != nil {
+				rows.Close()
+				return err
+			}
+			images = append(images, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, p := range images {
+			srcset, err := renderImage(ctx, p.key, p.url)
+			if err != nil {
+				// Leave it for the next run; a broken image must not block the rest
+				log.Printf("renditions for image %d: %v", p.id, err)
+				continue
+			}
+			data, _ := json.Marshal(srcset)
+			err = NewStore(db).WithTx(ctx, func(tx Store) error {
+				if _, err := tx.q.ExecContext(ctx, "UPDATE item_images SET renditions = $2 WHERE id = $1", p.id, data); err != nil {
+					return err
+				}
+				_, err := tx.TouchItem(ctx, p.itemID)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// renderImage stores the renditions of the uploaded image at key and returns
+// their URLs by size name.
+func renderImage(ctx context.Context, key, originalURL string) (map[string]string, error) {
+	data, err := media.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return map[string]string{}, nil
+	}
+
+	srcset := map[string]string{}
+	base := strings.TrimSuffix(key, path.Ext(key))
+	for _, size := range renditionSizes {
+		if src.Bounds().Dx() <= size.width {
+			srcset[size.name] = originalURL
+			continue
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resize(src, size.width), &jpeg.Options{Quality: 82}); err != nil {
+			return nil, err
+		}
+		renditionKey := fmt.Sprintf("%s_%s.jpg", base, size.name)
+		if err := media.Put(ctx, renditionKey, "image/jpeg", buf.Bytes()); err != nil {
+			return nil, err
+		}
+		srcset[size.name] = media.URL(renditionKey)
+	}
+	return srcset, nil
+}
+
+// deleteUpload removes an uploaded image and its renditions from the media
+// store. Renditions that were never generated are ignored by the store.
+func deleteUpload(ctx context.Context, key string) error {
+	base := strings.TrimSuffix(key, path.Ext(key))
+	for _, size := range renditionSizes {
+		if err := media.Delete(ctx, fmt.Sprintf("%s_%s.jpg", base, size.name)); err != nil {
+			return err
+		}
+	}
+	return media.Delete(ctx, key)
+}
+
+// resize scales src down to width, keeping the aspect ratio, by averaging the
+// source pixels covered by each destination pixel. Transparent areas are
+// flattened onto white since the result is encoded as JPEG.
+func resize(src image.Image, width int) *image.RGBA {
+	b := src.Bounds()
+	height := max(1, b.Dy()*width/b.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/height, b.Min.Y+(y+1)*b.Dy()/height
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/width, b.Min.X+(x+1)*b.Dx()/width
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < max(y1, y0+1); sy++ {
+				for sx := x0; sx < max(x1, x0+1); sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			// Premultiplied colors over a white background
+			white := 0xffff*n - a
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + white) / n >> 8),
+				G: uint8((g + white) / n >> 8),
+				B: uint8((bl + white) / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// restockAlert is a customer's request to be told when a sold-out sneaker,
+// or one of its sizes, is back in stock.
+type restockAlert struct {
+	ID        int            `json:"id"`
+	Item      sneakerSummary `json:"item"`
+	VariantID *int           `json:"variant_id"`
+	USSize    *string        `json:"us_size"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+const restockAlertColumns = "a.id, s.id, s.title, s.price, s.imageUrl, a.variant_id, v.us_size, a.created_at"
+
+// restockAlertFrom joins the alerts with their sneaker and size; only alerts
+// on sneakers in the public catalog are listed.
+var restockAlertFrom = `
+    FROM restock_alerts a
+    INNER JOIN sneakers s ON s.id = a.item_id
+    LEFT JOIN item_variants v ON v.id = a.variant_id
+    WHERE ` + itemVisible("s")
+
+func scanRestockAlert(row rowScanner) (restockAlert, error) {
+	var a restockAlert
+	err := row.Scan(&a.ID, &a.Item.ID, &a.Item.Title, &a.Item.Price, &a.Item.ImageURL, &a.VariantID, &a.USSize, &a.CreatedAt)
+	return a, err
+}
+
+// putRestockAlert subscribes the caller to a back-in-stock alert on a
+// favorite, for any size or, with {"variant_id": 42}, for one size. The
+// alert fires once, the next time the stock goes from 0 to more, and only
+// reaches customers who granted marketing consent.
+func putRestockAlert(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		favoriteID, err := strconv.Atoi(mux.Vars(r)["favoriteId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidFavoriteID)
+			return
+		}
+		var data struct {
+			VariantID *int `json:"variant_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+
+		var itemID int
+		err = db.QueryRow(`
+        SELECT s.id FROM favorite f
+        INNER JOIN sneakers s ON s.id = f.item_id
+        WHERE f.id = $1 AND f.owner = $2 AND `+itemVisible("s"), favoriteID, owner).Scan(&itemID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeFavoriteNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if data.VariantID != nil {
+			var ok bool
+			err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM item_variants WHERE id = $1 AND item_id = $2)", *data.VariantID, itemID).Scan(&ok)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			if !ok {
+				writeError(w, r, http.StatusUnprocessableEntity, errCodeVariantNotFound)
+				return
+			}
+		}
+
+		var alertID int
+		err = db.QueryRow(`
+        INSERT INTO restock_alerts (owner, item_id, variant_id) VALUES ($1, $2, $3)
+        ON CONFLICT (owner, item_id, (coalesce(variant_id, 0))) DO UPDATE SET restocked_at = NULL
+        RETURNING id`, owner, itemID, data.VariantID).Scan(&alertID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		alert, err := scanRestockAlert(db.QueryRow("SELECT "+restockAlertColumns+restockAlertFrom+" AND a.id = $1", alertID))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(alert)
+	}
+}
+
+// getRestockAlerts lists the caller's pending back-in-stock alerts, newest
+// first.
+func getRestockAlerts(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+
+		rows, err := db.Query("SELECT "+restockAlertColumns+restockAlertFrom+" AND a.owner = $1 ORDER BY a.created_at DESC, a.id DESC", owner)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		alerts := []restockAlert{}
+		for rows.Next() {
+			a, err := scanRestockAlert(rows)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			alerts = append(alerts, a)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(alerts)
+	}
+}
+
+// deleteRestockAlert cancels one of the caller's back-in-stock alerts.
+func deleteRestockAlert(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		alertID, err := strconv.Atoi(mux.Vars(r)["alertId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidRestockAlertID)
+			return
+		}
+
+		res, err := db.Exec("DELETE FROM restock_alerts WHERE owner = $1 AND id = $2", owner, alertID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// sendRestockAlerts notifies the customers whose sneaker or size came back
+// in stock, as marked by the restock triggers, and removes their alerts.
+// Alerts on sneakers outside the public catalog wait until they return.
+func sendRestockAlerts(db *sql.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `
+        SELECT a.id, a.owner, s.id, s.title, v.us_size
+        FROM restock_alerts a
+        INNER JOIN sneakers s ON s.id = a.item_id
+        LEFT JOIN item_variants v ON v.id = a.variant_id
+        WHERE a.restocked_at IS NOT NULL AND `+itemVisible("s"))
+		if err != nil {
+			return err
+		}
+		type restock struct {
+			alertID, itemID int
+			owner, title    string
+			size            *string
+		}
+		var restocks []restock
+		for rows.Next() {
+			var re restock
+			if err := rows.Scan(&re.alertID, &re.owner, &re.itemID, &re.title, &re.size); err != nil {
+				rows.Close()
+				return err
+			}
+			restocks = append(restocks, re)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, re := range restocks {
+			body := fmt.Sprintf("%s is available again.", re.title)
+			if re.size != nil {
+				body = fmt.Sprintf("%s is available again in US %s.", re.title, *re.size)
+			}
+			err := notify.Notify(ctx, notification{
+				Owner:  re.owner,
+				Kind:   "back_in_stock",
+				Title:  fmt.Sprintf("Back in stock: %s", re.title),
+				Body:   body,
+				ItemID: &re.itemID,
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := db.ExecContext(ctx, "DELETE FROM restock_alerts WHERE id = $1", re.alertID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// retentionPolicy removes the rows of table whose column is older than days.
+type retentionPolicy struct {
+	Name   string `json:"name"`
+	Days   int    `json:"days"`
+	table  string
+	column string
+}
+
+// retentionPolicies returns the purge policies. The number of days each kind
+// of data is kept is set with RETENTION_<NAME>_DAYS, e.g.
+// RETENTION_ANALYTICS_EVENTS_DAYS=30; 0 keeps the data forever.
+func retentionPolicies() []retentionPolicy {
+	policies := []retentionPolicy{
+		{Name: "analytics_events", Days: 90, table: "analytics_events", column: "received_at"},
+		// Clicks go with their search
+		{Name: "search_queries", Days: 180, table: "search_queries", column: "searched_at"},
+		{Name: "search_history", Days: 90, table: "search_history", column: "searched_at"},
+		{Name: "recently_viewed", Days: 90, table: "recently_viewed", column: "viewed_at"},
+		{Name: "notifications", Days: 180, table: "notifications", column: "created_at"},
+		// Expired codes that were never confirmed
+		{Name: "phone_verifications", Days: 1, table: "phone_verifications", column: "expires_at"},
+		// Past days no longer count towards the daily caps
+		{Name: "phone_verification_usage", Days: 2, table: "phone_verification_usage", column: "day"},
+		{Name: "item_snapshots", Days: 730, table: "item_snapshots", column: "snapshot_date"},
+		// Longer than the SLO window
+		{Name: "slo_minutes", Days: 90, table: "slo_minutes", column: "minute"},
+		// Restorable until then; purging removes the sneaker for good
+		{Name: "deleted_items", Days: 90, table: "sneakers", column: "deleted_at"},
+	}
+	for n, p := range policies {
+		policies[n].Days = getenvInt("RETENTION_"+strings.ToUpper(p.Name)+"_DAYS", p.Days)
+	}
+	return policies
+}
+
+// retentionBatchSize bounds the rows deleted per statement so purges never
+// hold locks for long.
+const retentionBatchSize = 10000
+
+// purgeExpired is the background job applying the retention policies.
+func purgeExpired(db *sql.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		for _, p := range retentionPolicies() {
+			if p.Days == 0 {
+				continue
+			}
+			cutoff := time.Now().AddDate(0, 0, -p.Days)
+			total := 0
+			for {
+				res, err := db.ExecContext(ctx, fmt.Sprintf(`
+                DELETE FROM %[1]s WHERE ctid IN (SELECT ctid FROM %[1]s WHERE %[2]s < $1 LIMIT %[3]d)`,
+					p.table, p.column, retentionBatchSize), cutoff)
+				if err != nil {
+					return fmt.Errorf("%s: %w", p.Name, err)
+				}
+				n, _ := res.RowsAffected()
+				total += int(n)
+				if n < retentionBatchSize {
+					break
+				}
+			}
+			if total > 0 {
+				logAt(levelInfo, "retention: purged %d %s older than %d days", total, p.Name, p.Days)
+			}
+		}
+		return nil
+	}
+}
+
+// getRetentionReport is a dry run of the retention job: it returns every
+// policy with the number of rows the next run would remove.
+func getRetentionReport(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type entry struct {
+			retentionPolicy
+			Cutoff  *time.Time `json:"cutoff"`
+			Expired int        `json:"expired"`
+		}
+		report := []entry{}
+		for _, p := range retentionPolicies() {
+			e := entry{retentionPolicy: p}
+			if p.Days > 0 {
+				cutoff := time.Now().AddDate(0, 0, -p.Days)
+				e.Cutoff = &cutoff
+				err := db.QueryRowContext(r.Context(), fmt.Sprintf("SELECT count(*) FROM %s WHERE %s < $1", p.table, p.column), cutoff).Scan(&e.Expired)
+				if err != nil {
+					serverError(w, r, err)
+					return
+				}
+			}
+			report = append(report, e)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+)
+
+// Log levels, from most to least verbose.
+const (
+	levelDebug = "debug"
+	levelInfo  = "info"
+	levelWarn  = "warn"
+	levelError = "error"
+)
+
+var logLevels = []string{levelDebug, levelInfo, levelWarn, levelError}
+
+// runtimeConfig holds the settings that can change without a restart. It is
+// built from the environment, overlaid with the JSON file named by
+// CONFIG_FILE, and can be reloaded with SIGHUP or changed through
+// PATCH /admin/config.
+type runtimeConfig struct {
+	LogLevel string `json:"log_level"`
+	// CacheMaxAge and CacheStaleWhileRevalidate are the TTLs in seconds of
+	// the public catalog Cache-Control policy.
+	CacheMaxAge               int `json:"cache_max_age"`
+	CacheStaleWhileRevalidate int `json:"cache_stale_while_revalidate"`
+	// EventSampleRate is the fraction of anonymous clients whose events are
+	// kept. Sampling is per client rather than per event so kept sessions stay
+	// complete.
+	EventSampleRate float64 `json:"event_sample_rate"`
+	// DisabledFeatures lists features switched off, e.g. to shed load during
+	// a drop. See featureEnabled.
+	DisabledFeatures []string `json:"disabled_features"`
+	// RelatedLimit is how many related sneakers are suggested by default.
+	RelatedLimit int `json:"related_limit"`
+}
+
+// Features that can be switched off at runtime.
+const (
+	featureSearchFallback  = "search_fallback"
+	featureSpelling        = "spelling_suggestions"
+	featurePersonalization = "personalization"
+)
+
+var config atomic.Pointer[runtimeConfig]
+
+func init() {
+	c, err := loadRuntimeConfig()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	config.Store(c)
+}
+
+// currentConfig returns the active runtime configuration. Callers must not
+// modify it.
+func currentConfig() *runtimeConfig {
+	return config.Load()
+}
+
+func loadRuntimeConfig() (*runtimeConfig, error) {
+	c := &runtimeConfig{
+		LogLevel:                  getenv("LOG_LEVEL", levelInfo),
+		CacheMaxAge:               60,
+		CacheStaleWhileRevalidate: 300,
+		EventSampleRate:           1,
+		DisabledFeatures:          []string{},
+		RelatedLimit:              8,
+	}
+	if rate, err := strconv.ParseFloat(getenv("EVENTS_SAMPLE_RATE", "1"), 64); err == nil {
+		c.EventSampleRate = rate
+	}
+
+	if path := getenv("CONFIG_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return c, c.validate()
+}
+
+func (c *runtimeConfig) validate() error {
+	switch {
+	case !slices.Contains(logLevels, c.LogLevel):
+		return fmt.Errorf("log_level must be one of %v", logLevels)
+	case c.CacheMaxAge < 0 || c.CacheStaleWhileRevalidate < 0:
+		return errors.New("cache TTLs must not be negative")
+	case c.EventSampleRate < 0 || c.EventSampleRate > 1:
+		return errors.New("event_sample_rate must be between 0 and 1")
+	case c.RelatedLimit < 1 || c.RelatedLimit > 50:
+		return errors.New("related_limit must be between 1 and 50")
+	}
+	return nil
+}
+
+// logEnabled reports whether messages at level are logged.
+func logEnabled(level string) bool {
+	return slices.Index(logLevels, level) >= slices.Index(logLevels, currentConfig().LogLevel)
+}
+
+// logAt logs the message when level is enabled.
+func logAt(level, format string, args ...any) {
+	if logEnabled(level) {
+		log.Printf(level+": "+format, args...)
+	}
+}
+
+// featureEnabled reports whether the named feature is switched on.
+func featureEnabled(name string) bool {
+	return !slices.Contains(currentConfig().DisabledFeatures, name)
+}
+
+// reloadOnSIGHUP reloads the runtime configuration whenever the process
+// receives SIGHUP. An invalid configuration is logged and the previous one kept.
+func reloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		c, err := loadRuntimeConfig()
+		if err != nil {
+			log.Printf("config reload: %v", err)
+			continue
+		}
+		config.Store(c)
+		log.Printf("config reloaded: log_level=%s", c.LogLevel)
+	}
+}
+
+func getRuntimeConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentConfig())
+}
+
+// patchRuntimeConfig applies a JSON merge patch to the runtime configuration,
+// e.g. {"log_level": "debug"}. Changes last until the next reload or restart.
+func patchRuntimeConfig(w http.ResponseWriter, r *http.Request) {
+	c := *currentConfig()
+	c.DisabledFeatures = slices.Clone(c.DisabledFeatures)
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+		return
+	}
+	if c.DisabledFeatures == nil {
+		c.DisabledFeatures = []string{}
+	}
+	if err := c.validate(); err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidConfig)
+		return
+	}
+	config.Store(&c)
+	log.Printf("config changed by admin: log_level=%s", c.LogLevel)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
@@ -0,0 +1,748 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations are applied in order on every start-up, so each statement must be
+// idempotent. Append new statements at the end; never edit applied ones.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS sneakers (
+		id SERIAL PRIMARY KEY,
+		title TEXT NOT NULL,
+		price INTEGER NOT NULL,
+		imageUrl TEXT NOT NULL,
+		isFavorite BOOLEAN NOT NULL DEFAULT false,
+		favoriteId INTEGER,
+		isAdded BOOLEAN NOT NULL DEFAULT false
+	)`,
+	`CREATE TABLE IF NOT EXISTS favorite (
+		id SERIAL PRIMARY KEY,
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE
+	)`,
+
+	// Timestamps used for Last-Modified and conditional requests.
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+	`CREATE OR REPLACE FUNCTION touch_updated_at() RETURNS trigger AS $$
+	BEGIN
+		NEW.updated_at = now();
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS sneakers_touch_updated_at ON sneakers`,
+	`CREATE TRIGGER sneakers_touch_updated_at BEFORE UPDATE ON sneakers
+		FOR EACH ROW EXECUTE FUNCTION touch_updated_at()`,
+
+	// Rich product attributes and the full-text search vector covering them.
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS materials TEXT[] NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS release_year INTEGER CHECK (release_year BETWEEN 1900 AND 2100)`,
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS style_code TEXT`,
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS weight_grams INTEGER CHECK (weight_grams > 0)`,
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS search_vector TSVECTOR`,
+	`CREATE OR REPLACE FUNCTION sneakers_search_vector() RETURNS trigger AS $$
+	BEGIN
+		NEW.search_vector =
+			setweight(to_tsvector('simple', coalesce(NEW.title, '') || ' ' || coalesce(NEW.style_code, '')), 'A') ||
+			setweight(to_tsvector('simple', array_to_string(NEW.materials, ' ')), 'B') ||
+			setweight(to_tsvector('simple', coalesce(NEW.description, '')), 'C') ||
+			setweight(to_tsvector('simple', NEW.attributes), 'D');
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS sneakers_search_vector ON sneakers`,
+	`CREATE TRIGGER sneakers_search_vector BEFORE INSERT OR UPDATE ON sneakers
+		FOR EACH ROW EXECUTE FUNCTION sneakers_search_vector()`,
+	`UPDATE sneakers SET search_vector = NULL WHERE search_vector IS NULL`,
+	`CREATE INDEX IF NOT EXISTS sneakers_search_vector_idx ON sneakers USING GIN (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS sneakers_attributes_idx ON sneakers USING GIN (attributes jsonb_path_ops)`,
+
+	// Free-form product tags.
+	`CREATE TABLE IF NOT EXISTS tags (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		slug TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS item_tags (
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		tag_id INTEGER NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+		PRIMARY KEY (item_id, tag_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS item_tags_tag_id_idx ON item_tags (tag_id)`,
+
+	// User-defined favorites order.
+	`ALTER TABLE favorite ADD COLUMN IF NOT EXISTS position INTEGER`,
+	`UPDATE favorite SET position = id WHERE position IS NULL`,
+	`ALTER TABLE favorite ALTER COLUMN position SET NOT NULL`,
+
+	// Daily catalog snapshots for BI.
+	`CREATE TABLE IF NOT EXISTS item_snapshots (
+		snapshot_date DATE NOT NULL,
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		price INTEGER NOT NULL,
+		PRIMARY KEY (snapshot_date, item_id)
+	)`,
+
+	// Progress of the incremental data warehouse export, per dataset.
+	`CREATE TABLE IF NOT EXISTS export_watermarks (
+		dataset TEXT PRIMARY KEY,
+		watermark TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS sneakers_updated_at_idx ON sneakers (updated_at)`,
+
+	// Anonymized client analytics events.
+	`CREATE TABLE IF NOT EXISTS analytics_events (
+		id BIGSERIAL PRIMARY KEY,
+		type TEXT NOT NULL,
+		anonymous_id TEXT NOT NULL,
+		item_id INTEGER,
+		query TEXT,
+		properties JSONB NOT NULL DEFAULT '{}',
+		occurred_at TIMESTAMPTZ NOT NULL,
+		received_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS analytics_events_received_at_idx ON analytics_events (received_at)`,
+
+	// Search analytics.
+	`CREATE TABLE IF NOT EXISTS search_queries (
+		id BIGSERIAL PRIMARY KEY,
+		query TEXT NOT NULL,
+		result_count INTEGER NOT NULL,
+		searched_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS search_queries_searched_at_idx ON search_queries (searched_at)`,
+	`CREATE TABLE IF NOT EXISTS search_clicks (
+		search_id BIGINT NOT NULL REFERENCES search_queries (id) ON DELETE CASCADE,
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		clicked_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS search_clicks_search_id_idx ON search_clicks (search_id)`,
+
+	// Admin-managed search dictionary.
+	`CREATE TABLE IF NOT EXISTS search_synonyms (
+		term TEXT PRIMARY KEY,
+		replacement TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS search_stopwords (
+		word TEXT PRIMARY KEY
+	)`,
+
+	// Recent searches per caller.
+	`CREATE TABLE IF NOT EXISTS search_history (
+		id BIGSERIAL PRIMARY KEY,
+		owner TEXT NOT NULL,
+		query TEXT NOT NULL,
+		searched_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		UNIQUE (owner, query)
+	)`,
+	`CREATE INDEX IF NOT EXISTS search_history_owner_searched_at_idx ON search_history (owner, searched_at DESC)`,
+
+	// In-app notification center.
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id BIGSERIAL PRIMARY KEY,
+		owner TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		title TEXT NOT NULL,
+		body TEXT NOT NULL,
+		item_id INTEGER REFERENCES sneakers (id) ON DELETE SET NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS notifications_owner_created_at_idx ON notifications (owner, created_at DESC)`,
+
+	// Price-drop alert subscriptions on favorited sneakers.
+	`CREATE TABLE IF NOT EXISTS price_alerts (
+		id SERIAL PRIMARY KEY,
+		owner TEXT NOT NULL,
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		target_price INTEGER CHECK (target_price > 0),
+		reference_price INTEGER NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		UNIQUE (owner, item_id)
+	)`,
+
+	// Release calendar and drop reminders.
+	`CREATE TABLE IF NOT EXISTS releases (
+		id SERIAL PRIMARY KEY,
+		title TEXT NOT NULL,
+		item_id INTEGER REFERENCES sneakers (id) ON DELETE SET NULL,
+		release_at TIMESTAMPTZ NOT NULL,
+		image_url TEXT NOT NULL DEFAULT '',
+		raffle_url TEXT,
+		description TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS releases_release_at_idx ON releases (release_at)`,
+	`CREATE TABLE IF NOT EXISTS release_reminders (
+		owner TEXT NOT NULL,
+		release_id INTEGER NOT NULL REFERENCES releases (id) ON DELETE CASCADE,
+		notified_at TIMESTAMPTZ,
+		PRIMARY KEY (owner, release_id)
+	)`,
+	`ALTER TABLE releases ADD COLUMN IF NOT EXISTS raffle_opens_at TIMESTAMPTZ`,
+	`ALTER TABLE releases ADD COLUMN IF NOT EXISTS raffle_closes_at TIMESTAMPTZ`,
+	`ALTER TABLE releases ADD COLUMN IF NOT EXISTS sold_out_at TIMESTAMPTZ`,
+
+	// Stock on hand; the check constraint is the last line of defence against overselling.
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS stock INTEGER NOT NULL DEFAULT 0 CHECK (stock >= 0)`,
+	`ALTER TABLE item_snapshots ADD COLUMN IF NOT EXISTS stock INTEGER`,
+
+	// Sizes, each with its own SKU and stock, and optionally favorited on their own.
+	`CREATE TABLE IF NOT EXISTS item_variants (
+		id SERIAL PRIMARY KEY,
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		us_size TEXT NOT NULL,
+		eu_size TEXT NOT NULL,
+		sku TEXT NOT NULL UNIQUE,
+		stock INTEGER NOT NULL DEFAULT 0 CHECK (stock >= 0),
+		UNIQUE (item_id, us_size)
+	)`,
+	`ALTER TABLE favorite ADD COLUMN IF NOT EXISTS variant_id INTEGER REFERENCES item_variants (id) ON DELETE SET NULL`,
+
+	// Brands, previously only part of the title.
+	`CREATE TABLE IF NOT EXISTS brands (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		slug TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS brand_id INTEGER REFERENCES brands (id) ON DELETE SET NULL`,
+	`CREATE INDEX IF NOT EXISTS sneakers_brand_id_idx ON sneakers (brand_id)`,
+
+	// Category hierarchy; a category with subcategories cannot be deleted.
+	`CREATE TABLE IF NOT EXISTS categories (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		slug TEXT NOT NULL UNIQUE,
+		parent_id INTEGER REFERENCES categories (id) ON DELETE RESTRICT
+	)`,
+	`CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id)`,
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS category_id INTEGER REFERENCES categories (id) ON DELETE SET NULL`,
+	`CREATE INDEX IF NOT EXISTS sneakers_category_id_idx ON sneakers (category_id)`,
+
+	// Image galleries. sneakers.imageUrl stays as the URL of the primary image;
+	// the trigger keeps the primary gallery image in sync when it is written.
+	`CREATE TABLE IF NOT EXISTS item_images (
+		id SERIAL PRIMARY KEY,
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		url TEXT NOT NULL,
+		alt_text TEXT NOT NULL DEFAULT '',
+		position INTEGER NOT NULL DEFAULT 0,
+		is_primary BOOLEAN NOT NULL DEFAULT false
+	)`,
+	`CREATE INDEX IF NOT EXISTS item_images_item_id_idx ON item_images (item_id, position)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS item_images_primary_idx ON item_images (item_id) WHERE is_primary`,
+	`INSERT INTO item_images (item_id, url, position, is_primary)
+		SELECT id, imageUrl, 0, true FROM sneakers s
+		WHERE NOT EXISTS (SELECT 1 FROM item_images WHERE item_id = s.id)`,
+	`CREATE OR REPLACE FUNCTION sync_primary_image() RETURNS trigger AS $$
+	BEGIN
+		UPDATE item_images SET url = NEW.imageUrl WHERE item_id = NEW.id AND is_primary;
+		IF NOT FOUND THEN
+			INSERT INTO item_images (item_id, url, position, is_primary) VALUES (NEW.id, NEW.imageUrl, 0, true);
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS sneakers_sync_primary_image ON sneakers`,
+	`CREATE TRIGGER sneakers_sync_primary_image AFTER INSERT OR UPDATE OF imageUrl ON sneakers
+		FOR EACH ROW EXECUTE FUNCTION sync_primary_image()`,
+	`ALTER TABLE item_images ADD COLUMN IF NOT EXISTS object_key TEXT`,
+	`ALTER TABLE item_images ADD COLUMN IF NOT EXISTS renditions JSONB`,
+
+	// SKUs and barcodes (EAN/UPC) for warehouse scanners and POS integrations.
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS sku TEXT UNIQUE`,
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS barcode TEXT UNIQUE`,
+	`ALTER TABLE item_variants ADD COLUMN IF NOT EXISTS barcode TEXT UNIQUE`,
+
+	// URL slugs, e.g. "nike-air-max-90-white"; former slugs redirect to the current one.
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS slug TEXT`,
+	`UPDATE sneakers SET slug = trim(both '-' from regexp_replace(lower(title), '[^[:alnum:]]+', '-', 'g'))
+		WHERE slug IS NULL`,
+	`UPDATE sneakers s SET slug = s.slug || '-' || s.id
+		WHERE EXISTS (SELECT 1 FROM sneakers o WHERE o.slug = s.slug AND o.id < s.id)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS sneakers_slug_idx ON sneakers (slug)`,
+	`CREATE TABLE IF NOT EXISTS item_slug_history (
+		slug TEXT PRIMARY KEY,
+		item_id INTEGER NOT NULL REFERENCES sneakers(id) ON DELETE CASCADE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+
+	// Append-only log of consent decisions, the audit trail for marketing and tracking.
+	`CREATE TABLE IF NOT EXISTS consent_events (
+		id BIGSERIAL PRIMARY KEY,
+		owner TEXT NOT NULL,
+		purpose TEXT NOT NULL,
+		granted BOOLEAN NOT NULL,
+		source TEXT NOT NULL,
+		recorded_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS consent_events_owner_idx ON consent_events (owner, purpose, recorded_at DESC)`,
+	`INSERT INTO consent_events (owner, purpose, granted, source, recorded_at)
+		SELECT owner, 'marketing', true, 'price_alert', min(created_at) FROM price_alerts a
+		WHERE NOT EXISTS (SELECT 1 FROM consent_events WHERE owner = a.owner AND purpose = 'marketing')
+		GROUP BY owner`,
+
+	// Soft delete: deleted sneakers are hidden from the catalog until restored or purged.
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+	`CREATE INDEX IF NOT EXISTS sneakers_deleted_at_idx ON sneakers (deleted_at) WHERE deleted_at IS NOT NULL`,
+
+	// Colorways of the same model share a style group, e.g. "nike-air-max-90".
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS style_group TEXT`,
+	`CREATE INDEX IF NOT EXISTS sneakers_style_group_idx ON sneakers (style_group)`,
+
+	// Low-stock thresholds; an alert is recorded whenever stock drops to or below one.
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS low_stock_threshold INTEGER CHECK (low_stock_threshold >= 0)`,
+	`CREATE TABLE IF NOT EXISTS stock_alerts (
+		id BIGSERIAL PRIMARY KEY,
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		stock INTEGER NOT NULL,
+		threshold INTEGER NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS stock_alerts_item_id_idx ON stock_alerts (item_id, created_at DESC)`,
+	`CREATE OR REPLACE FUNCTION record_stock_alert() RETURNS trigger AS $$
+	BEGIN
+		IF NEW.low_stock_threshold IS NOT NULL AND NEW.stock <= NEW.low_stock_threshold
+			AND (TG_OP = 'INSERT' OR OLD.low_stock_threshold IS NULL OR OLD.stock > OLD.low_stock_threshold) THEN
+			INSERT INTO stock_alerts (item_id, stock, threshold) VALUES (NEW.id, NEW.stock, NEW.low_stock_threshold);
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS sneakers_record_stock_alert ON sneakers`,
+	`CREATE TRIGGER sneakers_record_stock_alert AFTER INSERT OR UPDATE OF stock, low_stock_threshold ON sneakers
+		FOR EACH ROW EXECUTE FUNCTION record_stock_alert()`,
+
+	// Prices were whole currency units and are now minor units (cents). The
+	// marker row makes the one-off conversion safe to run again.
+	`CREATE TABLE IF NOT EXISTS schema_markers (
+		name TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM schema_markers WHERE name = 'prices_in_minor_units') THEN
+			UPDATE sneakers SET price = price * 100;
+			UPDATE item_snapshots SET price = price * 100;
+			UPDATE price_alerts SET target_price = target_price * 100, reference_price = reference_price * 100;
+			INSERT INTO schema_markers (name) VALUES ('prices_in_minor_units');
+		END IF;
+	END
+	$$`,
+
+	// Store credit: the balance per user and its ledger.
+	`CREATE TABLE IF NOT EXISTS wallets (
+		owner TEXT PRIMARY KEY,
+		balance INTEGER NOT NULL CHECK (balance >= 0)
+	)`,
+	`CREATE TABLE IF NOT EXISTS wallet_entries (
+		id BIGSERIAL PRIMARY KEY,
+		owner TEXT NOT NULL,
+		amount INTEGER NOT NULL,
+		reason TEXT NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS wallet_entries_owner_idx ON wallet_entries (owner, created_at DESC)`,
+
+	// Every price a sneaker had, for price charts and "lowest price in 30 days".
+	`CREATE TABLE IF NOT EXISTS price_history (
+		id BIGSERIAL PRIMARY KEY,
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		price INTEGER NOT NULL,
+		changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS price_history_item_id_idx ON price_history (item_id, changed_at)`,
+	`INSERT INTO price_history (item_id, price, changed_at)
+		SELECT id, price, created_at FROM sneakers s
+		WHERE NOT EXISTS (SELECT 1 FROM price_history WHERE item_id = s.id)`,
+	`CREATE OR REPLACE FUNCTION record_price_change() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'INSERT' OR NEW.price <> OLD.price THEN
+			INSERT INTO price_history (item_id, price) VALUES (NEW.id, NEW.price);
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS sneakers_record_price_change ON sneakers`,
+	`CREATE TRIGGER sneakers_record_price_change AFTER INSERT OR UPDATE OF price ON sneakers
+		FOR EACH ROW EXECUTE FUNCTION record_price_change()`,
+
+	// Paid memberships, as last reported by the billing provider.
+	`CREATE TABLE IF NOT EXISTS memberships (
+		owner TEXT PRIMARY KEY,
+		status TEXT NOT NULL CHECK (status IN ('active', 'past_due', 'canceled')),
+		current_period_end TIMESTAMPTZ NOT NULL,
+		provider_ref TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+
+	// Publication state; a published sneaker with a future publish_at is scheduled.
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published' CHECK (status IN ('draft', 'published', 'archived'))`,
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS publish_at TIMESTAMPTZ`,
+	`CREATE INDEX IF NOT EXISTS sneakers_unpublished_idx ON sneakers (publish_at) WHERE status <> 'published' OR publish_at IS NOT NULL`,
+
+	// Early access of members to scheduled sneakers.
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS member_access_at TIMESTAMPTZ`,
+
+	// When a sneaker goes on sale.
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS release_at TIMESTAMPTZ`,
+	`CREATE INDEX IF NOT EXISTS sneakers_release_at_idx ON sneakers (release_at) WHERE release_at IS NOT NULL`,
+
+	// Canonical sneaker models the retail SKUs are listings of. Existing
+	// sneakers get a model per style code, once.
+	`CREATE TABLE IF NOT EXISTS models (
+		id SERIAL PRIMARY KEY,
+		brand_id INTEGER REFERENCES brands (id) ON DELETE SET NULL,
+		silhouette TEXT NOT NULL,
+		colorway TEXT NOT NULL DEFAULT '',
+		style_code TEXT UNIQUE,
+		release_date DATE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS model_id INTEGER REFERENCES models (id) ON DELETE SET NULL`,
+	`CREATE INDEX IF NOT EXISTS sneakers_model_id_idx ON sneakers (model_id)`,
+	`DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM schema_markers WHERE name = 'models_from_style_codes') THEN
+			INSERT INTO models (brand_id, silhouette, style_code)
+				SELECT DISTINCT ON (style_code) brand_id, title, style_code FROM sneakers
+				WHERE style_code IS NOT NULL
+				ORDER BY style_code, id
+				ON CONFLICT (style_code) DO NOTHING;
+			UPDATE sneakers s SET model_id = m.id FROM models m
+				WHERE s.model_id IS NULL AND s.style_code = m.style_code;
+			INSERT INTO schema_markers (name) VALUES ('models_from_style_codes');
+		END IF;
+	END
+	$$`,
+
+	// Resale market prices of sneaker models, as last pulled from the provider.
+	`CREATE TABLE IF NOT EXISTS market_prices (
+		model_id INTEGER PRIMARY KEY REFERENCES models (id) ON DELETE CASCADE,
+		currency TEXT NOT NULL,
+		lowest_ask INTEGER,
+		highest_bid INTEGER,
+		last_sale INTEGER,
+		fetched_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+
+	// The last sneakers each user or device viewed.
+	`CREATE TABLE IF NOT EXISTS recently_viewed (
+		owner TEXT NOT NULL,
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		viewed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (owner, item_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS recently_viewed_owner_viewed_at_idx ON recently_viewed (owner, viewed_at DESC)`,
+
+	// Shipping rates per group of delivery countries.
+	`CREATE TABLE IF NOT EXISTS shipping_zones (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		countries TEXT[] NOT NULL,
+		standard_fee INTEGER NOT NULL CHECK (standard_fee >= 0),
+		free_shipping_from INTEGER CHECK (free_shipping_from >= 0),
+		express_fee INTEGER CHECK (express_fee >= 0)
+	)`,
+	`CREATE INDEX IF NOT EXISTS shipping_zones_countries_idx ON shipping_zones USING gin (countries)`,
+
+	// Import duties of cross-border zones.
+	`ALTER TABLE shipping_zones ADD COLUMN IF NOT EXISTS duty_basis_points INTEGER NOT NULL DEFAULT 0 CHECK (duty_basis_points BETWEEN 0 AND 10000)`,
+	`ALTER TABLE shipping_zones ADD COLUMN IF NOT EXISTS duty_free_up_to INTEGER CHECK (duty_free_up_to >= 0)`,
+
+	// Verified phone numbers for SMS notifications, encrypted, and pending
+	// verification codes.
+	`CREATE TABLE IF NOT EXISTS phones (
+		owner TEXT PRIMARY KEY,
+		phone TEXT NOT NULL,
+		verified_at TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS phone_verifications (
+		owner TEXT PRIMARY KEY,
+		phone TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		sent_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		expires_at TIMESTAMPTZ NOT NULL
+	)`,
+
+	// Likely duplicate sneakers flagged on creation, for review.
+	`CREATE TABLE IF NOT EXISTS item_duplicates (
+		id SERIAL PRIMARY KEY,
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		duplicate_of INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		reason TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'dismissed', 'merged')),
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		resolved_at TIMESTAMPTZ,
+		UNIQUE (item_id, duplicate_of)
+	)`,
+	`CREATE INDEX IF NOT EXISTS item_duplicates_status_idx ON item_duplicates (status, created_at DESC)`,
+
+	// Favorites per customer; rows made before have no owner.
+	`ALTER TABLE favorite ADD COLUMN IF NOT EXISTS owner TEXT`,
+	`CREATE INDEX IF NOT EXISTS favorite_owner_position_idx ON favorite (owner, position)`,
+
+	// Copies of externally hosted images in the media store, made by the
+	// rehost job; the status is NULL until the rehost is started.
+	`ALTER TABLE item_images ADD COLUMN IF NOT EXISTS rehost_status TEXT CHECK (rehost_status IN ('queued', 'done', 'failed'))`,
+	`ALTER TABLE item_images ADD COLUMN IF NOT EXISTS rehost_error TEXT`,
+	`ALTER TABLE item_images ADD COLUMN IF NOT EXISTS rehost_attempted_at TIMESTAMPTZ`,
+	`CREATE INDEX IF NOT EXISTS item_images_rehost_idx ON item_images (rehost_status, id) WHERE rehost_status IS NOT NULL`,
+
+	// A sneaker is favorited once per customer; the oldest of repeated
+	// favorites is kept. Favorites without owner count as one customer's.
+	`DELETE FROM favorite f USING favorite o
+		WHERE o.owner IS NOT DISTINCT FROM f.owner AND o.item_id = f.item_id AND o.id < f.id`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS favorite_owner_item_idx ON favorite ((coalesce(owner, '')), item_id)`,
+
+	// Change log of the catalog for incremental sync. Rows keep the ID of the
+	// writing transaction so readers can skip those still in flight. The log
+	// starts with every sneaker already in the catalog as created.
+	`CREATE TABLE IF NOT EXISTS item_changes (
+		id BIGSERIAL PRIMARY KEY,
+		item_id INTEGER NOT NULL,
+		change TEXT NOT NULL CHECK (change IN ('created', 'updated', 'deleted')),
+		txid BIGINT NOT NULL DEFAULT txid_current(),
+		changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS item_changes_txid_idx ON item_changes (txid, id)`,
+	`CREATE INDEX IF NOT EXISTS item_changes_item_id_idx ON item_changes (item_id, changed_at)`,
+	`INSERT INTO item_changes (item_id, change)
+		SELECT id, 'created' FROM sneakers WHERE NOT EXISTS (SELECT 1 FROM item_changes)`,
+	`CREATE OR REPLACE FUNCTION log_item_change() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			INSERT INTO item_changes (item_id, change) VALUES (OLD.id, 'deleted');
+		ELSIF TG_OP = 'INSERT' THEN
+			INSERT INTO item_changes (item_id, change) VALUES (NEW.id, 'created');
+		ELSE
+			INSERT INTO item_changes (item_id, change) VALUES (NEW.id, 'updated');
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS sneakers_log_change ON sneakers`,
+	`CREATE TRIGGER sneakers_log_change AFTER INSERT OR UPDATE OR DELETE ON sneakers
+		FOR EACH ROW EXECUTE FUNCTION log_item_change()`,
+
+	// Named wishlists grouping a customer's favorites; a favorite is in one
+	// at most, and new favorites go into the default one.
+	`CREATE TABLE IF NOT EXISTS wishlists (
+		id SERIAL PRIMARY KEY,
+		owner TEXT NOT NULL,
+		name TEXT NOT NULL,
+		is_default BOOLEAN NOT NULL DEFAULT false,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS wishlists_owner_name_idx ON wishlists (owner, lower(name))`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS wishlists_owner_default_idx ON wishlists (owner) WHERE is_default`,
+	`ALTER TABLE favorite ADD COLUMN IF NOT EXISTS wishlist_id INTEGER REFERENCES wishlists (id) ON DELETE SET NULL`,
+	`CREATE INDEX IF NOT EXISTS favorite_wishlist_id_idx ON favorite (wishlist_id, position)`,
+
+	// Markets (ISO 3166-1 alpha-2 countries) sneakers and tagged collections
+	// are restricted to, for staggered launches; NULL is everywhere.
+	`ALTER TABLE sneakers ADD COLUMN IF NOT EXISTS markets TEXT[]`,
+	`ALTER TABLE tags ADD COLUMN IF NOT EXISTS markets TEXT[]`,
+
+	// Share tokens of public wishlists.
+	`ALTER TABLE wishlists ADD COLUMN IF NOT EXISTS share_token TEXT UNIQUE`,
+
+	// Favorite counts per sneaker, for popularity.
+	`CREATE INDEX IF NOT EXISTS favorite_item_id_idx ON favorite (item_id)`,
+
+	// When the favorites count of each sneaker last changed, for
+	// Last-Modified; counting favorites does not touch updated_at.
+	`CREATE TABLE IF NOT EXISTS item_favorites_changes (
+		item_id INTEGER PRIMARY KEY REFERENCES sneakers (id) ON DELETE CASCADE,
+		changed_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE OR REPLACE FUNCTION record_favorites_change() RETURNS trigger AS $$
+	DECLARE
+		changed INTEGER;
+	BEGIN
+		FOREACH changed IN ARRAY ARRAY[
+			CASE WHEN TG_OP <> 'INSERT' THEN OLD.item_id END,
+			CASE WHEN TG_OP <> 'DELETE' THEN NEW.item_id END]
+		LOOP
+			-- Favorites removed along with their sneaker have nothing to date
+			IF changed IS NOT NULL AND EXISTS (SELECT 1 FROM sneakers WHERE id = changed) THEN
+				INSERT INTO item_favorites_changes (item_id, changed_at) VALUES (changed, now())
+				ON CONFLICT (item_id) DO UPDATE SET changed_at = EXCLUDED.changed_at;
+			END IF;
+		END LOOP;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS favorite_record_change ON favorite`,
+	`CREATE TRIGGER favorite_record_change AFTER INSERT OR DELETE OR UPDATE OF item_id ON favorite
+		FOR EACH ROW EXECUTE FUNCTION record_favorites_change()`,
+
+	// Carrier lead times per shipping zone, for delivery estimates.
+	`ALTER TABLE shipping_zones ADD COLUMN IF NOT EXISTS standard_days_min INTEGER CHECK (standard_days_min >= 0)`,
+	`ALTER TABLE shipping_zones ADD COLUMN IF NOT EXISTS standard_days_max INTEGER CHECK (standard_days_max >= standard_days_min)`,
+	`ALTER TABLE shipping_zones ADD COLUMN IF NOT EXISTS express_days_min INTEGER CHECK (express_days_min >= 0)`,
+	`ALTER TABLE shipping_zones ADD COLUMN IF NOT EXISTS express_days_max INTEGER CHECK (express_days_max >= express_days_min)`,
+
+	// Price drops customers were alerted to, for GET /me/alerts.
+	`CREATE TABLE IF NOT EXISTS price_drops (
+		id SERIAL PRIMARY KEY,
+		owner TEXT NOT NULL,
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		previous_price INTEGER NOT NULL,
+		price INTEGER NOT NULL,
+		dropped_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS price_drops_owner_dropped_at_idx ON price_drops (owner, dropped_at DESC)`,
+
+	// Requests, errors and slow requests per route and minute, for SLOs.
+	`CREATE TABLE IF NOT EXISTS slo_minutes (
+		route TEXT NOT NULL,
+		minute TIMESTAMPTZ NOT NULL,
+		requests BIGINT NOT NULL,
+		errors BIGINT NOT NULL,
+		slow BIGINT NOT NULL,
+		PRIMARY KEY (route, minute)
+	)`,
+	`CREATE INDEX IF NOT EXISTS slo_minutes_minute_idx ON slo_minutes (minute)`,
+
+	// Back-in-stock alerts on favorited sneakers, for any size or one. The
+	// triggers mark them when the stock goes from 0 to more.
+	`CREATE TABLE IF NOT EXISTS restock_alerts (
+		id SERIAL PRIMARY KEY,
+		owner TEXT NOT NULL,
+		item_id INTEGER NOT NULL REFERENCES sneakers (id) ON DELETE CASCADE,
+		variant_id INTEGER REFERENCES item_variants (id) ON DELETE CASCADE,
+		restocked_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS restock_alerts_owner_item_idx ON restock_alerts (owner, item_id, (coalesce(variant_id, 0)))`,
+	`CREATE INDEX IF NOT EXISTS restock_alerts_item_id_idx ON restock_alerts (item_id)`,
+	`CREATE OR REPLACE FUNCTION mark_item_restock() RETURNS trigger AS $$
+	BEGIN
+		IF OLD.stock = 0 AND NEW.stock > 0 THEN
+			UPDATE restock_alerts SET restocked_at = now()
+			WHERE item_id = NEW.id AND variant_id IS NULL AND restocked_at IS NULL;
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS sneakers_mark_restock ON sneakers`,
+	`CREATE TRIGGER sneakers_mark_restock AFTER UPDATE OF stock ON sneakers
+		FOR EACH ROW EXECUTE FUNCTION mark_item_restock()`,
+	`CREATE OR REPLACE FUNCTION mark_variant_restock() RETURNS trigger AS $$
+	BEGIN
+		IF NEW.stock > 0 AND (TG_OP = 'INSERT' OR OLD.stock = 0) THEN
+			UPDATE restock_alerts SET restocked_at = now()
+			WHERE item_id = NEW.item_id AND (variant_id IS NULL OR variant_id = NEW.id) AND restocked_at IS NULL;
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS item_variants_mark_restock ON item_variants`,
+	`CREATE TRIGGER item_variants_mark_restock AFTER INSERT OR UPDATE OF stock ON item_variants
+		FOR EACH ROW EXECUTE FUNCTION mark_variant_restock()`,
+
+	// Discount codes customers enter at checkout, a percentage or a fixed amount off.
+	`CREATE TABLE IF NOT EXISTS discount_codes (
+		code TEXT PRIMARY KEY,
+		basis_points INTEGER CHECK (basis_points BETWEEN 1 AND 10000),
+		amount INTEGER CHECK (amount > 0),
+		starts_at TIMESTAMPTZ,
+		ends_at TIMESTAMPTZ,
+		CHECK ((basis_points IS NULL) <> (amount IS NULL))
+	)`,
+
+	// Verification codes sent and guesses made by each customer per day,
+	// capped regardless of how many codes they request.
+	`CREATE TABLE IF NOT EXISTS phone_verification_usage (
+		owner TEXT NOT NULL,
+		day DATE NOT NULL,
+		codes_sent INTEGER NOT NULL DEFAULT 0,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (owner, day)
+	)`,
+
+	// Setting an alert is not a marketing opt-in. The grants it implied are
+	// revoked with a new event where they are still the customer's current
+	// decision; consent_events stays append-only.
+	`DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM schema_markers WHERE name = 'revoke_implied_marketing') THEN
+			INSERT INTO consent_events (owner, purpose, granted, source)
+				SELECT owner, 'marketing', false, 'migration' FROM (
+					SELECT DISTINCT ON (owner) owner, granted, source FROM consent_events
+					WHERE purpose = 'marketing'
+					ORDER BY owner, recorded_at DESC, id DESC
+				) latest
+				WHERE latest.granted AND latest.source IN ('price_alert', 'restock_alert');
+			INSERT INTO schema_markers (name) VALUES ('revoke_implied_marketing');
+		END IF;
+	END
+	$$`,
+	// The price alert backfill of consent_events still runs on every
+	// start-up; revoke the grants it gives customers who never decided.
+	`INSERT INTO consent_events (owner, purpose, granted, source)
+		SELECT c.owner, 'marketing', false, 'migration' FROM consent_events c
+		WHERE c.purpose = 'marketing' AND c.granted AND c.source = 'price_alert'
+			AND c.recorded_at = (SELECT min(a.created_at) FROM price_alerts a WHERE a.owner = c.owner)
+			AND NOT EXISTS (SELECT 1 FROM consent_events o WHERE o.owner = c.owner AND o.purpose = 'marketing' AND o.id <> c.id)`,
+
+	// Low-stock alerts of sneakers sold in sizes, whose stock is the total of
+	// their sizes: the sneaker trigger compares that total, and changes to a
+	// size's stock record an alert when the total drops to the threshold.
+	`CREATE OR REPLACE FUNCTION record_stock_alert() RETURNS trigger AS $$
+	DECLARE
+		sized BIGINT;
+		stock BIGINT;
+	BEGIN
+		SELECT sum(v.stock) INTO sized FROM item_variants v WHERE v.item_id = NEW.id;
+		stock := coalesce(sized, NEW.stock);
+		IF NEW.low_stock_threshold IS NOT NULL AND stock <= NEW.low_stock_threshold
+			AND (TG_OP = 'INSERT' OR OLD.low_stock_threshold IS NULL OR coalesce(sized, OLD.stock) > OLD.low_stock_threshold) THEN
+			INSERT INTO stock_alerts (item_id, stock, threshold) VALUES (NEW.id, stock, NEW.low_stock_threshold);
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE FUNCTION record_variant_stock_alert() RETURNS trigger AS $$
+	DECLARE
+		threshold INTEGER;
+		total BIGINT;
+		previous BIGINT;
+	BEGIN
+		SELECT s.low_stock_threshold INTO threshold FROM sneakers s WHERE s.id = NEW.item_id AND s.deleted_at IS NULL;
+		IF threshold IS NULL THEN
+			RETURN NULL;
+		END IF;
+		SELECT sum(v.stock) INTO total FROM item_variants v WHERE v.item_id = NEW.item_id;
+		previous := total - NEW.stock;
+		IF TG_OP = 'UPDATE' THEN
+			previous := previous + OLD.stock;
+		END IF;
+		IF total <= threshold AND previous > threshold THEN
+			INSERT INTO stock_alerts (item_id, stock, threshold) VALUES (NEW.item_id, total, threshold);
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS item_variants_record_stock_alert ON item_variants`,
+	`CREATE TRIGGER item_variants_record_stock_alert AFTER INSERT OR UPDATE OF stock ON item_variants
+		FOR EACH ROW EXECUTE FUNCTION record_variant_stock_alert()`,
+}
+
+// migrate brings the database schema up to date.
+func migrate(db *sql.DB) error {
+	for i, stmt := range migrations {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// normalizeQuery folds case and whitespace so "Air  Max" and "air max" are
+// counted as the same search.
+func normalizeQuery(q string) string {
+	return strings.ToLower(strings.Join(strings.Fields(q), " "))
+}
+
+// fallbackLimit caps the number of popular items offered for a failed search.
+const fallbackLimit = 12
+
+// popularityOrder ranks sneakers by how many times they have been favorited.
+const popularityOrder = favoritesCount + " DESC, id"
+
+// searchTerms splits a query into its alphanumeric words.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchFallback is used when a search found nothing. It first retries with
+// the spelling suggestion, if any, then relaxes the search to match any word,
+// including as a prefix, and finally falls back to the most popular items,
+// restricted to tags named in the query when there are any. The returned kind
+// ("corrected", "relaxed" or "popular") tells the client which fallback
+// produced the results.
+func searchFallback(db *sql.DB, filters itemQuery, searchQuery, suggested, orderBy string) ([]item, time.Time, string, error) {
+	if suggested != "" {
+		corrected := filters.clone()
+		corrected.where("(title ILIKE %s OR search_vector @@ plainto_tsquery('simple', %s))", "%"+suggested+"%", suggested)
+		items, lastModified, err := queryItems(db, corrected, orderBy, 0)
+		if err != nil || len(items) > 0 {
+			return items, lastModified, "corrected", err
+		}
+	}
+
+	terms := searchTerms(searchQuery)
+	if len(terms) > 0 {
+		patterns := make([]string, len(terms))
+		prefixes := make([]string, len(terms))
+		for i, t := range terms {
+			patterns[i] = "%" + t + "%"
+			prefixes[i] = t + ":*"
+		}
+		relaxed := filters.clone()
+		relaxed.where("(title ILIKE ANY(%s) OR search_vector @@ to_tsquery('simple', %s))",
+			pq.Array(patterns), strings.Join(prefixes, " | "))
+		items, lastModified, err := queryItems(db, relaxed, orderBy, 0)
+		if err != nil || len(items) > 0 {
+			return items, lastModified, "relaxed", err
+		}
+	}
+
+	// Infer the category from tags mentioned in the query, e.g. "trail shoes"
+	popular := filters.clone()
+	popular.where(`(NOT EXISTS (SELECT 1 FROM tags WHERE slug = ANY(%[1]s))
+        OR id IN (SELECT it.item_id FROM item_tags it JOIN tags t ON t.id = it.tag_id WHERE t.slug = ANY(%[1]s)))`,
+		pq.Array(terms))
+	items, lastModified, err := queryItems(db, popular, popularityOrder, fallbackLimit)
+	return items, lastModified, "popular", err
+}
+
+// logSearch records a catalog search and its result count, returning the id
+// clients echo back when reporting a click. Failures are logged but never fail
+// the search itself.
+func logSearch(db *sql.DB, query string, results int) (int64, bool) {
+	var id int64
+	err := db.QueryRow("INSERT INTO search_queries (query, result_count) VALUES ($1, $2) RETURNING id",
+		normalizeQuery(query), results).Scan(&id)
+	if err != nil {
+		log.Printf("log search %q: %v", query, err)
+		return 0, false
+	}
+	return id, true
+}
+
+// postSearchClick records that the user opened item_id from a search result list.
+func postSearchClick(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		searchID, err := strconv.ParseInt(mux.Vars(r)["searchId"], 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidSearchID)
+			return
+		}
+		var data struct {
+			ItemID int `json:"item_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+
+		_, err = db.Exec("INSERT INTO search_clicks (search_id, item_id) VALUES ($1, $2)", searchID, data.ItemID)
+		if isForeignKeyViolation(err) {
+			writeError(w, r, http.StatusNotFound, errCodeSearchNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getSearchAnalytics reports the most frequent queries with their click-through
+// rate and the most frequent queries that found nothing, over the last ?days=.
+func getSearchAnalytics(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		days := 30
+		if raw := r.URL.Query().Get("days"); raw != "" {
+			var err error
+			if days, err = strconv.Atoi(raw); err != nil || days < 1 || days > 365 {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidDays)
+				return
+			}
+		}
+		limit, ok := queryLimit(r, 20, 200)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+
+		type topQuery struct {
+			Query            string  `json:"query"`
+			Searches         int     `json:"searches"`
+			AvgResults       float64 `json:"avg_results"`
+			Clicks           int     `json:"clicks"`
+			ClickThroughRate float64 `json:"click_through_rate"`
+		}
+		type zeroResultQuery struct {
+			Query    string `json:"query"`
+			Searches int    `json:"searches"`
+		}
+		report := struct {
+			Days              int               `json:"days"`
+			TopQueries        []topQuery        `json:"top_queries"`
+			ZeroResultQueries []zeroResultQuery `json:"zero_result_queries"`
+		}{Days: days, TopQueries: []topQuery{}, ZeroResultQueries: []zeroResultQuery{}}
+
+		rows, err := db.Query(`
+        SELECT q.query, count(*), avg(q.result_count),
+               count(*) FILTER (WHERE EXISTS (SELECT 1 FROM search_clicks c WHERE c.search_id = q.id))
+        FROM search_queries q
+        WHERE q.searched_at > now() - make_interval(days => $1)
+        GROUP BY q.query
+        ORDER BY count(*) DESC, q.query
+        LIMIT $2`, days, limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var q topQuery
+			if err := rows.Scan(&q.Query, &q.Searches, &q.AvgResults, &q.Clicks); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			q.ClickThroughRate = float64(q.Clicks) / float64(q.Searches)
+			report.TopQueries = append(report.TopQueries, q)
+		}
+
+		rows, err = db.Query(`
+        SELECT query, count(*)
+        FROM search_queries
+        WHERE searched_at > now() - make_interval(days => $1) AND result_count = 0
+        GROUP BY query
+        ORDER BY count(*) DESC, query
+        LIMIT $2`, days, limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var q zeroResultQuery
+			if err := rows.Scan(&q.Query, &q.Searches); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			report.ZeroResultQueries = append(report.ZeroResultQueries, q)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
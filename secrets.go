@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// errSecretNotFound is returned by a secretsProvider that has no value for a
+// secret.
+var errSecretNotFound = errors.New("secret not found")
+
+// secretsProvider looks up secrets such as the database password by name,
+// e.g. "db_password". Implementations must be safe for concurrent use.
+type secretsProvider interface {
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// newSecretsProvider returns the provider selected by SECRETS_PROVIDER:
+//
+//	env    DB_PASSWORD style environment variables (default)
+//	file   one file per secret in SECRETS_DIR, as mounted by Docker, Kubernetes
+//	       or the cloud secret manager CSI drivers (AWS, GCP, Azure)
+//	vault  the KV v2 secret at VAULT_SECRET_PATH, e.g. "secret/data/sneakers"
+//
+// Values are cached for SECRETS_TTL so rotated secrets are picked up without
+// a restart.
+func newSecretsProvider() (secretsProvider, error) {
+	var p secretsProvider
+	switch kind := getenv("SECRETS_PROVIDER", "env"); kind {
+	case "env":
+		p = envSecrets{}
+	case "file":
+		p = fileSecrets{dir: getenv("SECRETS_DIR", "/run/secrets")}
+	case "vault":
+		token := os.Getenv("VAULT_TOKEN")
+		if path := os.Getenv("VAULT_TOKEN_FILE"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		p = &vaultSecrets{
+			addr:   strings.TrimSuffix(getenv("VAULT_ADDR", "http://127.0.0.1:8200"), "/"),
+			token:  token,
+			path:   getenv("VAULT_SECRET_PATH", "secret/data/sneakers"),
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+	default:
+		return nil, fmt.Errorf("unknown SECRETS_PROVIDER %q", kind)
+	}
+	return &cachedSecrets{provider: p, ttl: getenvDuration("SECRETS_TTL", 5*time.Minute)}, nil
+}
+
+type envSecrets struct{}
+
+func (envSecrets) Secret(ctx context.Context, name string) (string, error) {
+	if v := os.Getenv(strings.ToUpper(name)); v != "" {
+		return v, nil
+	}
+	return "", errSecretNotFound
+}
+
+type fileSecrets struct {
+	dir string
+}
+
+func (f fileSecrets) Secret(ctx context.Context, name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(f.dir, filepath.Base(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", errSecretNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// vaultSecrets reads the keys of a single Vault KV v2 secret.
+type vaultSecrets struct {
+	addr   string
+	token  string
+	path   string
+	client *http.Client
+}
+
+func (v *vaultSecrets) Secret(ctx context.Context, name string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+v.path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault %s: %s", v.path, resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("vault %s: %w", v.path, err)
+	}
+	value, ok := body.Data.Data[name]
+	if !ok {
+		return "", errSecretNotFound
+	}
+	return value, nil
+}
+
+// cachedSecrets caches the values of another provider for ttl. When a refresh
+// fails the previous value is kept, so a secret manager outage does not take
+// the shop down.
+type cachedSecrets struct {
+	provider secretsProvider
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value     string
+	fetchedAt time.Time
+}
+
+func (c *cachedSecrets) Secret(ctx context.Context, name string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[name]
+	if ok && time.Since(entry.fetchedAt) < c.ttl {
+		return entry.value, nil
+	}
+	value, err := c.provider.Secret(ctx, name)
+	if err != nil {
+		if ok && !errors.Is(err, errSecretNotFound) {
+			return entry.value, nil
+		}
+		return "", err
+	}
+	if c.entries == nil {
+		c.entries = map[string]cachedSecret{}
+	}
+	c.entries[name] = cachedSecret{value, time.Now()}
+	return value, nil
+}
+
+// dbConnector opens database connections with the current db_password, so a
+// rotated password is used by every connection opened after the rotation.
+// Without a db_password secret it falls back to the built-in development
+// password.
+type dbConnector struct {
+	secrets secretsProvider
+}
+
+func (c dbConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	pass, err := c.secrets.Secret(ctx, "db_password")
+	if errors.Is(err, errSecretNotFound) {
+		pass = password
+	} else if err != nil {
+		return nil, fmt.Errorf("db_password: %w", err)
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password='%s' dbname=%s sslmode=disable",
+		host, port, user, strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(pass), dbname)
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c dbConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// shippingZone groups the countries shipped to at the same rates. Without a
+// zone for the delivery country, orders cannot be shipped there.
+type shippingZone struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Countries []string `json:"countries"` // ISO 3166-1 alpha-2 codes
+	// StandardFee is the fee of standard delivery, waived for discounted
+	// subtotals of at least FreeShippingFrom when set.
+	StandardFee      Money  `json:"standard_fee"`
+	FreeShippingFrom *Money `json:"free_shipping_from"`
+	// ExpressFee is the fee of express delivery; without it express is not
+	// offered in the zone.
+	ExpressFee *Money `json:"express_fee"`
+	// DutyBasisPoints is the import duty rate of the zone's countries,
+	// charged on orders worth more than DutyFreeUpTo; 0 for domestic zones
+	// and countries of a customs union with the shop.
+	DutyBasisPoints int    `json:"duty_basis_points"`
+	DutyFreeUpTo    *Money `json:"duty_free_up_to"`
+	// StandardDelivery and ExpressDelivery are the carrier lead times to the
+	// zone's countries, in business days; unset, the shop-wide ones apply.
+	StandardDelivery *deliveryDays `json:"standard_delivery"`
+	ExpressDelivery  *deliveryDays `json:"express_delivery"`
+}
+
+const shippingZoneColumns = "id, name, countries, standard_fee, free_shipping_from, express_fee, duty_basis_points, duty_free_up_to, " +
+	"standard_days_min, standard_days_max, express_days_min, express_days_max"
+
+func scanShippingZone(row rowScanner) (shippingZone, error) {
+	var z shippingZone
+	var standardMin, standardMax, expressMin, expressMax sql.NullInt32
+	err := row.Scan(&z.ID, &z.Name, pq.Array(&z.Countries), &z.StandardFee, &z.FreeShippingFrom, &z.ExpressFee, &z.DutyBasisPoints, &z.DutyFreeUpTo,
+		&standardMin, &standardMax, &expressMin, &expressMax)
+	if standardMin.Valid && standardMax.Valid {
+		z.StandardDelivery = &deliveryDays{int(standardMin.Int32), int(standardMax.Int32)}
+	}
+	if expressMin.Valid && expressMax.Valid {
+		z.ExpressDelivery = &deliveryDays{int(expressMin.Int32), int(expressMax.Int32)}
+	}
+	return z, err
+}
+
+// deliveryDayColumns returns the values of the min and max columns of an
+// optional lead time.
+func deliveryDayColumns(d *deliveryDays) (min, max *int) {
+	if d == nil {
+		return nil, nil
+	}
+	return &d.Min, &d.Max
+}
+
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// validate normalizes the zone and returns the error code of the first
+// invalid field, or "" when it is valid.
+func (z *shippingZone) validate() string {
+	z.Name = strings.TrimSpace(z.Name)
+	for n, c := range z.Countries {
+		z.Countries[n] = strings.ToUpper(strings.TrimSpace(c))
+	}
+	if z.Name == "" || len(z.Countries) == 0 {
+		return errCodeInvalidShippingZone
+	}
+	for _, c := range z.Countries {
+		if !countryCode.MatchString(c) {
+			return errCodeInvalidShippingZone
+		}
+	}
+	if z.DutyBasisPoints < 0 || z.DutyBasisPoints > 10000 {
+		return errCodeInvalidShippingZone
+	}
+	for _, m := range []*Money{&z.StandardFee, z.FreeShippingFrom, z.ExpressFee, z.DutyFreeUpTo} {
+		if m != nil && (m.Amount < 0 || m.Currency != shopCurrency) {
+			return errCodeInvalidShippingZone
+		}
+	}
+	for _, d := range []*deliveryDays{z.StandardDelivery, z.ExpressDelivery} {
+		if d != nil && !d.valid() {
+			return errCodeInvalidShippingZone
+		}
+	}
+	return ""
+}
+
+// pricingRulesFor returns the pricing rules for delivery to country, with
+// the shipping fees and duty rates of its zone. An empty country keeps the shop-wide
+// defaults. It reports false when no zone ships to the country.
+func pricingRulesFor(ctx context.Context, db *sql.DB, country string) (pricingRules, bool, error) {
+	rules := currentPricingRules()
+	if country == "" {
+		return rules, true, nil
+	}
+	z, err := scanShippingZone(db.QueryRowContext(ctx, "SELECT "+shippingZoneColumns+" FROM shipping_zones WHERE countries @> ARRAY[$1::text]",
+		strings.ToUpper(strings.TrimSpace(country))))
+	if errors.Is(err, sql.ErrNoRows) {
+		return rules, false, nil
+	}
+	if err != nil {
+		return rules, false, err
+	}
+	rules.ShippingFee = z.StandardFee.Amount
+	rules.FreeShippingFrom = 0
+	if z.FreeShippingFrom != nil {
+		rules.FreeShippingFrom = z.FreeShippingFrom.Amount
+	}
+	rules.ExpressShippingFee = 0
+	if z.ExpressFee != nil {
+		rules.ExpressShippingFee = z.ExpressFee.Amount
+	}
+	rules.DutyBasisPoints = z.DutyBasisPoints
+	if z.DutyFreeUpTo != nil {
+		rules.DutyFreeUpTo = z.DutyFreeUpTo.Amount
+	}
+	if z.StandardDelivery != nil {
+		rules.StandardDelivery = *z.StandardDelivery
+	}
+	if z.ExpressDelivery != nil {
+		rules.ExpressDelivery = *z.ExpressDelivery
+	}
+	return rules, true, nil
+}
+
+// zoneConflict returns the name of another zone already covering one of
+// countries, or "".
+func zoneConflict(db *sql.DB, zoneID int, countries []string) (string, error) {
+	var name string
+	err := db.QueryRow("SELECT name FROM shipping_zones WHERE countries && $1 AND id <> $2 LIMIT 1", pq.Array(countries), zoneID).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return name, err
+}
+
+func getShippingZones(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.Query("SELECT " + shippingZoneColumns + " FROM shipping_zones ORDER BY name")
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		zones := []shippingZone{}
+		for rows.Next() {
+			z, err := scanShippingZone(rows)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			zones = append(zones, z)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(zones)
+	}
+}
+
+// putShippingZone creates a zone (POST) or replaces one (PUT), e.g.
+// {"name": "EU", "countries": ["FR", "DE"], "standard_fee": {"amount": 495,
+// "currency": "EUR"}, "free_shipping_from": {"amount": 10000, "currency":
+// "EUR"}}. Zones outside the shop's customs territory add the duty rate of
+// their countries, e.g. "duty_basis_points": 1700, "duty_free_up_to":
+// {"amount": 15000, "currency": "EUR"}, and carriers' lead
+// times may differ from the shop-wide ones, e.g. "standard_delivery":
+// {"min": 5, "max": 8}. A country belongs to one zone at most.
+func putShippingZone(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zoneID := 0
+		if raw, ok := mux.Vars(r)["zoneId"]; ok {
+			var err error
+			if zoneID, err = strconv.Atoi(raw); err != nil {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidShippingZoneID)
+				return
+			}
+		}
+		var z shippingZone
+		if err := json.NewDecoder(r.Body).Decode(&z); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if code := z.validate(); code != "" {
+			writeError(w, r, http.StatusUnprocessableEntity, code)
+			return
+		}
+
+		if other, err := zoneConflict(db, zoneID, z.Countries); err != nil {
+			serverError(w, r, err)
+			return
+		} else if other != "" {
+			writeError(w, r, http.StatusConflict, errCodeShippingZoneConflict)
+			return
+		}
+
+		standardMin, standardMax := deliveryDayColumns(z.StandardDelivery)
+		expressMin, expressMax := deliveryDayColumns(z.ExpressDelivery)
+		var err error
+		if zoneID == 0 {
+			z, err = scanShippingZone(db.QueryRow(`
+            INSERT INTO shipping_zones (name, countries, standard_fee, free_shipping_from, express_fee, duty_basis_points, duty_free_up_to,
+                                        standard_days_min, standard_days_max, express_days_min, express_days_max)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+            RETURNING `+shippingZoneColumns, z.Name, pq.Array(z.Countries), z.StandardFee, z.FreeShippingFrom, z.ExpressFee, z.DutyBasisPoints, z.DutyFreeUpTo,
+				standardMin, standardMax, expressMin, expressMax))
+		} else {
+			z, err = scanShippingZone(db.QueryRow(`
+            UPDATE shipping_zones
+            SET name = $2, countries = $3, standard_fee = $4, free_shipping_from = $5, express_fee = $6,
+                duty_basis_points = $7, duty_free_up_to = $8,
+                standard_days_min = $9, standard_days_max = $10, express_days_min = $11, express_days_max = $12
+            WHERE id = $1
+            RETURNING `+shippingZoneColumns, zoneID, z.Name, pq.Array(z.Countries), z.StandardFee, z.FreeShippingFrom, z.ExpressFee, z.DutyBasisPoints, z.DutyFreeUpTo,
+				standardMin, standardMax, expressMin, expressMax))
+		}
+		if isUniqueViolation(err) {
+			writeError(w, r, http.StatusConflict, errCodeShippingZoneConflict)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeShippingZoneNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if zoneID == 0 {
+			w.Header().Set("Location", fmt.Sprintf("/admin/shipping-zones/%d", z.ID))
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(z)
+	}
+}
+
+func deleteShippingZone(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zoneID, err := strconv.Atoi(mux.Vars(r)["zoneId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidShippingZoneID)
+			return
+		}
+
+		res, err := db.Exec("DELETE FROM shipping_zones WHERE id = $1", zoneID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeShippingZoneNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sitemapChunkSize is the maximum number of URLs per sitemap file allowed by
+// the sitemaps.org protocol.
+const sitemapChunkSize = 50000
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+type sitemapChunk struct {
+	urls    []sitemapURL
+	lastMod time.Time
+}
+
+// sitemaps holds the most recently generated sitemap chunks. It is rebuilt by
+// a scheduled job so requests never hit the database.
+var sitemaps struct {
+	sync.RWMutex
+	chunks []sitemapChunk
+}
+
+// generateSitemaps rebuilds the sitemap chunks from the catalog: product pages
+// and tag collection pages.
+func generateSitemaps(db *sql.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		type entry struct {
+			loc     string
+			lastMod time.Time
+		}
+		var entries []entry
+
+		// Crawlers have no market: sneakers restricted to some markets are
+		// left out, as for callers whose country is unknown
+		rows, err := db.QueryContext(ctx, "SELECT id, updated_at FROM sneakers WHERE "+itemVisible("")+" AND "+itemInMarket("", "$1")+" ORDER BY id", "")
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int
+			var e entry
+			if err := rows.Scan(&id, &e.lastMod); err != nil {
+				rows.Close()
+				return err
+			}
+			e.loc = fmt.Sprintf("%s/items/%d", storefrontURL, id)
+			entries = append(entries, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows, err = db.QueryContext(ctx, `
+        SELECT t.slug, coalesce(max(s.updated_at), t.created_at)
+        FROM tags t
+        LEFT JOIN item_tags it ON it.tag_id = t.id
+        LEFT JOIN sneakers s ON s.id = it.item_id AND `+itemVisible("s")+` AND `+itemInMarket("s", "$1")+`
+        GROUP BY t.id
+        ORDER BY t.slug`, "")
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var slug string
+			var e entry
+			if err := rows.Scan(&slug, &e.lastMod); err != nil {
+				rows.Close()
+				return err
+			}
+			e.loc = fmt.Sprintf("%s/tags/%s", storefrontURL, slug)
+			entries = append(entries, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		var chunks []sitemapChunk
+		for start := 0; start < len(entries); start += sitemapChunkSize {
+			var chunk sitemapChunk
+			for _, e := range entries[start:min(start+sitemapChunkSize, len(entries))] {
+				chunk.urls = append(chunk.urls, sitemapURL{Loc: e.loc, LastMod: e.lastMod.UTC().Format(time.RFC3339)})
+				if e.lastMod.After(chunk.lastMod) {
+					chunk.lastMod = e.lastMod
+				}
+			}
+			chunks = append(chunks, chunk)
+		}
+
+		sitemaps.Lock()
+		sitemaps.chunks = chunks
+		sitemaps.Unlock()
+		return nil
+	}
+}
+
+// getSitemapIndex serves /sitemap.xml, a sitemap index pointing at every chunk.
+func getSitemapIndex(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	index := sitemapIndex{Xmlns: sitemapNamespace}
+	sitemaps.RLock()
+	for n, chunk := range sitemaps.chunks {
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{
+			Loc:     fmt.Sprintf("%s://%s/sitemaps/%d.xml", scheme, r.Host, n+1),
+			LastMod: chunk.lastMod.UTC().Format(time.RFC3339),
+		})
+	}
+	sitemaps.RUnlock()
+
+	writeXML(w, index)
+}
+
+// getSitemapChunk serves /sitemaps/{n}.xml, numbered from 1.
+func getSitemapChunk(w http.ResponseWriter, r *http.Request) {
+	n, _ := strconv.Atoi(mux.Vars(r)["n"])
+
+	sitemaps.RLock()
+	defer sitemaps.RUnlock()
+	if n < 1 || n > len(sitemaps.chunks) {
+		writeError(w, r, http.StatusNotFound, errCodeNotFound)
+		return
+	}
+	chunk := sitemaps.chunks[n-1]
+	if notModified(w, r, chunk.lastMod) {
+		return
+	}
+
+	writeXML(w, sitemapURLSet{Xmlns: sitemapNamespace, URLs: chunk.urls})
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
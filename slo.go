@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sloObjective is the service-level objective of a route: the share of
+// requests, over the SLO window, that must succeed, and that must be served
+// within Latency. 5xx responses count against availability, slower ones
+// against latency.
+type sloObjective struct {
+	Target  float64
+	Latency time.Duration
+}
+
+// defaultSLO applies to routes without their own objective. SLO_TARGET is a
+// percentage, e.g. 99.5, and SLO_LATENCY a duration.
+var defaultSLO = sloObjective{
+	Target:  getenvPercent("SLO_TARGET", 99.5),
+	Latency: getenvDuration("SLO_LATENCY", 500*time.Millisecond),
+}
+
+// sloObjectives are the objectives of routes held to a different standard,
+// keyed by method and route template. Checkout is set with
+// CHECKOUT_SLO_TARGET and CHECKOUT_SLO_LATENCY.
+var sloObjectives = map[string]sloObjective{
+	"POST /cart/preview": {
+		Target:  getenvPercent("CHECKOUT_SLO_TARGET", 99.9),
+		Latency: getenvDuration("CHECKOUT_SLO_LATENCY", 800*time.Millisecond),
+	},
+}
+
+// sloWindow is the period over which the error budget is spent.
+var sloWindow = getenvDuration("SLO_WINDOW", 30*24*time.Hour)
+
+func objectiveFor(route string) sloObjective {
+	if o, ok := sloObjectives[route]; ok {
+		return o
+	}
+	return defaultSLO
+}
+
+// getenvPercent parses the environment variable key as a percentage between
+// 0 and 100 exclusive, returning it as a fraction, or def percent when it is
+// unset or invalid.
+func getenvPercent(key string, def float64) float64 {
+	if p, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && p > 0 && p < 100 {
+		return p / 100
+	}
+	return def / 100
+}
+
+// sloCounts are the requests served by a route, and how many failed or were
+// too slow.
+type sloCounts struct {
+	Requests, Errors, Slow int64
+}
+
+// sloPending holds the counts of each route since the last flush, keyed like
+// sloObjectives.
+var sloPending = struct {
+	sync.Mutex
+	routes map[string]*sloCounts
+}{routes: map[string]*sloCounts{}}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// trackSLO is router middleware counting, per route, the requests served,
+// the 5xx responses and those slower than the route's objective. Requests
+// matching no route are not counted.
+func trackSLO(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, _ := route.GetPathTemplate()
+		key := r.Method + " " + template
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		elapsed := time.Since(start)
+
+		sloPending.Lock()
+		defer sloPending.Unlock()
+		c, ok := sloPending.routes[key]
+		if !ok {
+			c = &sloCounts{}
+			sloPending.routes[key] = c
+		}
+		c.Requests++
+		if rec.status >= 500 {
+			c.Errors++
+		}
+		if elapsed > objectiveFor(key).Latency {
+			c.Slow++
+		}
+	})
+}
+
+// flushSLO adds the counts since the last run to the per-minute totals
+// shared by all instances.
+func flushSLO(db *sql.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		sloPending.Lock()
+		routes := sloPending.routes
+		sloPending.routes = map[string]*sloCounts{}
+		sloPending.Unlock()
+
+		for route, c := range routes {
+			_, err := db.ExecContext(ctx, `
+            INSERT INTO slo_minutes (route, minute, requests, errors, slow)
+            VALUES ($1, date_trunc('minute', now()), $2, $3, $4)
+            ON CONFLICT (route, minute) DO UPDATE
+                SET requests = slo_minutes.requests + EXCLUDED.requests,
+                    errors = slo_minutes.errors + EXCLUDED.errors,
+                    slow = slo_minutes.slow + EXCLUDED.slow`, route, c.Requests, c.Errors, c.Slow)
+			if err != nil {
+				// Put the counts back so they are flushed next time
+				sloPending.Lock()
+				for route, c := range routes {
+					p, ok := sloPending.routes[route]
+					if !ok {
+						p = &sloCounts{}
+						sloPending.routes[route] = p
+					}
+					p.Requests += c.Requests
+					p.Errors += c.Errors
+					p.Slow += c.Slow
+				}
+				sloPending.Unlock()
+				return err
+			}
+			delete(routes, route)
+		}
+		return nil
+	}
+}
+
+// sloIndicator is how a route fares against one side of its objective.
+// BudgetRemaining is the share of the window's error budget left, negative
+// once overspent. A burn rate of 1 spends the budget exactly over the window;
+// above it the budget runs out early.
+type sloIndicator struct {
+	Ratio           float64 `json:"ratio"`
+	BudgetRemaining float64 `json:"budget_remaining"`
+	BurnRate1h      float64 `json:"burn_rate_1h"`
+	BurnRate5m      float64 `json:"burn_rate_5m"`
+}
+
+// newSLOIndicator computes an indicator from the bad requests over the window,
+// the last hour and the last 5 minutes.
+func newSLOIndicator(target float64, window, hour, fiveMinutes [2]int64) sloIndicator {
+	budget := 1 - target
+	badRatio := func(c [2]int64) float64 {
+		if c[0] == 0 {
+			return 0
+		}
+		return float64(c[1]) / float64(c[0])
+	}
+	return sloIndicator{
+		Ratio:           1 - badRatio(window),
+		BudgetRemaining: 1 - badRatio(window)/budget,
+		BurnRate1h:      badRatio(hour) / budget,
+		BurnRate5m:      badRatio(fiveMinutes) / budget,
+	}
+}
+
+// getSLO reports each route's availability and latency against its
+// objective over the SLO window, with the error budget left and the burn
+// rates of the last hour and 5 minutes, fastest burning first. Counts reach
+// the report within a minute.
+func getSLO(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := db.QueryContext(r.Context(), `
+        SELECT route,
+               sum(requests), sum(errors), sum(slow),
+               coalesce(sum(requests) FILTER (WHERE minute >= now() - interval '1 hour'), 0),
+               coalesce(sum(errors) FILTER (WHERE minute >= now() - interval '1 hour'), 0),
+               coalesce(sum(slow) FILTER (WHERE minute >= now() - interval '1 hour'), 0),
+               coalesce(sum(requests) FILTER (WHERE minute >= now() - interval '5 minutes'), 0),
+               coalesce(sum(errors) FILTER (WHERE minute >= now() - interval '5 minutes'), 0),
+               coalesce(sum(slow) FILTER (WHERE minute >= now() - interval '5 minutes'), 0)
+        FROM slo_minutes
+        WHERE minute >= now() - make_interval(secs => $1)
+        GROUP BY route`, sloWindow.Seconds())
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type routeSLO struct {
+			Route        string       `json:"route"`
+			Target       float64      `json:"target"`
+			LatencyMS    int64        `json:"latency_ms"`
+			Requests     int64        `json:"requests"`
+			Availability sloIndicator `json:"availability"`
+			Latency      sloIndicator `json:"latency"`
+		}
+		routes := []routeSLO{}
+		for rows.Next() {
+			var s routeSLO
+			var window, hour, fiveMinutes sloCounts
+			err := rows.Scan(&s.Route, &window.Requests, &window.Errors, &window.Slow,
+				&hour.Requests, &hour.Errors, &hour.Slow, &fiveMinutes.Requests, &fiveMinutes.Errors, &fiveMinutes.Slow)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			o := objectiveFor(s.Route)
+			s.Target = o.Target
+			s.LatencyMS = o.Latency.Milliseconds()
+			s.Requests = window.Requests
+			s.Availability = newSLOIndicator(o.Target,
+				[2]int64{window.Requests, window.Errors}, [2]int64{hour.Requests, hour.Errors}, [2]int64{fiveMinutes.Requests, fiveMinutes.Errors})
+			s.Latency = newSLOIndicator(o.Target,
+				[2]int64{window.Requests, window.Slow}, [2]int64{hour.Requests, hour.Slow}, [2]int64{fiveMinutes.Requests, fiveMinutes.Slow})
+			routes = append(routes, s)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		sort.Slice(routes, func(i, j int) bool {
+			bi := max(routes[i].Availability.BurnRate1h, routes[i].Latency.BurnRate1h)
+			bj := max(routes[j].Availability.BurnRate1h, routes[j].Latency.BurnRate1h)
+			if bi != bj {
+				return bi > bj
+			}
+			return routes[i].Route < routes[j].Route
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"window_days": sloWindow.Hours() / 24,
+			"routes":      routes,
+		})
+	}
+}
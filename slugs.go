@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// itemSlug returns a URL slug for a sneaker titled title that is not used by
+// any other sneaker, now or in the past, e.g. "nike-air-max-90-white" or
+// "nike-air-max-90-white-2".
+func itemSlug(ctx context.Context, tx *sql.Tx, itemID int, title string) (string, error) {
+	base := slugify(title)
+	if base == "" {
+		base = "sneaker"
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT slug FROM sneakers WHERE (slug = $1 OR slug LIKE $1 || '-%') AND id <> $2
+        UNION
+        SELECT slug FROM item_slug_history WHERE (slug = $1 OR slug LIKE $1 || '-%') AND item_id <> $2`, base, itemID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	taken := map[string]bool{}
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return "", err
+		}
+		taken[slug] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	slug := base
+	for n := 2; taken[slug]; n++ {
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+	return slug, nil
+}
+
+// setItemSlug gives a sneaker the slug of its current title. The previous
+// slug is kept in item_slug_history so old links keep working.
+func setItemSlug(ctx context.Context, tx *sql.Tx, itemID int, title string) error {
+	slug, err := itemSlug(ctx, tx, itemID, title)
+	if err != nil {
+		return err
+	}
+
+	var previous sql.NullString
+	if err := tx.QueryRowContext(ctx, "SELECT slug FROM sneakers WHERE id = $1", itemID).Scan(&previous); err != nil {
+		return err
+	}
+	if previous.String == slug {
+		return nil
+	}
+	if previous.Valid {
+		if _, err := tx.ExecContext(ctx, `
+            INSERT INTO item_slug_history (slug, item_id) VALUES ($1, $2)
+            ON CONFLICT (slug) DO UPDATE SET item_id = EXCLUDED.item_id, created_at = now()`, previous.String, itemID); err != nil {
+			return err
+		}
+	}
+	// A sneaker renamed back to an earlier title takes its old slug back
+	if _, err := tx.ExecContext(ctx, "DELETE FROM item_slug_history WHERE slug = $1", slug); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE sneakers SET slug = $2 WHERE id = $1", itemID, slug)
+	return err
+}
+
+// getItemBySlug returns the sneaker with the given slug. Slugs the sneaker had
+// before its title changed answer 301 Moved Permanently, with the current slug
+// in the Location header and the body, so storefronts can update their URL.
+func getItemBySlug(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slug := strings.ToLower(mux.Vars(r)["slug"])
+
+		member, err := earlyAccess(w, r, db)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		market := requestMarket(w, r)
+		i, err := scanItem(db.QueryRow("SELECT "+itemColumnsFor(member)+" FROM sneakers WHERE slug = $1 AND "+itemVisibleTo("", member)+" AND "+itemInMarket("", "$2"), slug, market))
+		if errors.Is(err, sql.ErrNoRows) {
+			var current string
+			err = db.QueryRow(`
+            SELECT s.slug FROM item_slug_history h JOIN sneakers s ON s.id = h.item_id
+            WHERE h.slug = $1 AND `+itemVisibleTo("s", member)+` AND `+itemInMarket("s", "$2"), slug, market).Scan(&current)
+			if err == nil {
+				location := "/items/slug/" + current
+				w.Header().Set("Location", location)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusMovedPermanently)
+				json.NewEncoder(w).Encode(map[string]string{"slug": current, "location": location})
+				return
+			}
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(i)
+	}
+}
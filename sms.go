@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Phone verification codes expire after phoneCodeTTL and allow
+// phoneCodeAttempts guesses; a new one can be requested every
+// phoneCodeInterval. Whatever the number of codes, a customer is sent at
+// most phoneCodesPerDay codes and may guess at most phoneAttemptsPerDay
+// times a day.
+const (
+	phoneCodeTTL        = 10 * time.Minute
+	phoneCodeAttempts   = 5
+	phoneCodeInterval   = time.Minute
+	phoneCodesPerDay    = 5
+	phoneAttemptsPerDay = 15
+)
+
+// smsNotificationKinds are the kinds of notifications also sent by SMS to
+// customers with a verified phone number: time-critical messages only, never
+// marketing.
+var smsNotificationKinds = map[string]bool{
+	"release_reminder": true,
+	"raffle_result":    true,
+	"delivery_update":  true,
+}
+
+// smsSender sends text messages to phone numbers in E.164 format.
+type smsSender interface {
+	SendSMS(ctx context.Context, to, body string) error
+}
+
+// sms is the sender of verification codes and SMS notifications. It is nil
+// when SMS_PROVIDER is not set, in which case phone numbers cannot be
+// verified.
+var sms smsSender
+
+// newSMSSender returns the sender selected by SMS_PROVIDER, or nil:
+//
+//	twilio  the Twilio Messages API, sending from SMS_FROM with the account
+//	        TWILIO_ACCOUNT_SID and the twilio_auth_token secret
+func newSMSSender(secrets secretsProvider) (smsSender, error) {
+	switch kind := os.Getenv("SMS_PROVIDER"); kind {
+	case "":
+		return nil, nil
+	case "twilio":
+		s := &twilioSMS{
+			accountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+			from:       os.Getenv("SMS_FROM"),
+			secrets:    secrets,
+			client:     &http.Client{Timeout: 10 * time.Second},
+		}
+		if s.accountSID == "" || s.from == "" {
+			return nil, fmt.Errorf("TWILIO_ACCOUNT_SID and SMS_FROM are required for SMS_PROVIDER=twilio")
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_PROVIDER %q", kind)
+	}
+}
+
+type twilioSMS struct {
+	accountSID string
+	from       string
+	secrets    secretsProvider
+	client     *http.Client
+}
+
+func (s *twilioSMS) SendSMS(ctx context.Context, to, body string) error {
+	form := url.Values{"To": {to}, "From": {s.from}, "Body": {body}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		"https://api.twilio.com/2010-04-01/Accounts/"+url.PathEscape(s.accountSID)+"/Messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	token, err := s.secrets.Secret(ctx, "twilio_auth_token")
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.accountSID, token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("twilio: %s %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// smsNotifier passes notifications to next and also texts the ones of
+// smsNotificationKinds to recipients with a verified phone number. A failed
+// SMS is logged rather than returned, as the notification was delivered.
+type smsNotifier struct {
+	store  Store
+	sender smsSender
+	next   notifier
+}
+
+func (n smsNotifier) Notify(ctx context.Context, msg notification) error {
+	if err := n.next.Notify(ctx, msg); err != nil {
+		return err
+	}
+	if !smsNotificationKinds[msg.Kind] {
+		return nil
+	}
+	p, err := n.store.Phone(ctx, msg.Owner)
+	if err != nil || p == nil || p.VerifiedAt == nil {
+		return err
+	}
+	if err := n.sender.SendSMS(ctx, string(p.Number), msg.Title+": "+msg.Body); err != nil {
+		logAt(levelWarn, "notify: sms %s to %s: %v", msg.Kind, msg.Owner, err)
+	}
+	return nil
+}
+
+// phone is the phone number of a user. Numbers are personal data and stored
+// encrypted.
+type phone struct {
+	Number     encryptedString `json:"-"`
+	VerifiedAt *time.Time      `json:"verified_at"`
+}
+
+// masked returns the number with all but its last digits hidden, for
+// display.
+func (p phone) masked() string {
+	n := string(p.Number)
+	if len(n) <= 4 {
+		return n
+	}
+	return strings.Repeat("•", len(n)-4) + n[len(n)-4:]
+}
+
+// Phone returns the phone number of owner, or nil when they have none.
+func (s Store) Phone(ctx context.Context, owner string) (*phone, error) {
+	var p phone
+	err := s.q.QueryRowContext(ctx, "SELECT phone, verified_at FROM phones WHERE owner = $1", owner).Scan(&p.Number, &p.VerifiedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// phoneCodeMessages are the texts carrying a verification code, by
+// language.
+var phoneCodeMessages = map[string]string{
+	"en": "Your verification code is %s. It expires in 10 minutes.",
+	"fr": "Votre code de vérification est %s. Il expire dans 10 minutes.",
+	"de": "Ihr Bestätigungscode lautet %s. Er läuft in 10 Minuten ab.",
+}
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// normalizePhone returns number in E.164 format without the spaces, dashes,
+// dots and parentheses people type, or "" when it is not an international
+// number.
+func normalizePhone(number string) string {
+	number = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" -.()", r) {
+			return -1
+		}
+		return r
+	}, number)
+	if !e164.MatchString(number) {
+		return ""
+	}
+	return number
+}
+
+// hashPhoneCode hashes a verification code with its owner, so stored codes
+// cannot be replayed for another user.
+func hashPhoneCode(owner, code string) string {
+	sum := sha256.Sum256([]byte(owner + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// getPhone returns the caller's phone number, masked, and whether it is
+// verified.
+func getPhone(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireUser(w, r)
+		if owner == "" {
+			return
+		}
+
+		p, err := NewStore(db).Phone(r.Context(), owner)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if p == nil {
+			writeError(w, r, http.StatusNotFound, errCodePhoneNotFound)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Phone      string     `json:"phone"`
+			VerifiedAt *time.Time `json:"verified_at"`
+		}{p.masked(), p.VerifiedAt})
+	}
+}
+
+// postPhone starts the verification of the caller's phone number, e.g.
+// {"phone": "+33 6 12 34 56 78"}, by texting it a six digit code to confirm
+// with POST /me/phone/verify. The number replaces the caller's current one
+// only once verified. A new code replaces the pending one, within the
+// interval and daily caps above.
+func postPhone(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireUser(w, r)
+		if owner == "" {
+			return
+		}
+		if sms == nil {
+			writeError(w, r, http.StatusServiceUnavailable, errCodeSMSUnavailable)
+			return
+		}
+		var data struct {
+			Phone string `json:"phone"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		number := normalizePhone(data.Phone)
+		if number == "" {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidPhone)
+			return
+		}
+
+		var throttled bool
+		err := db.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM phone_verifications WHERE owner = $1 AND sent_at > now() - $2 * interval '1 second')`,
+			owner, phoneCodeInterval.Seconds()).Scan(&throttled)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if throttled {
+			w.Header().Set("Retry-After", fmt.Sprint(int(phoneCodeInterval.Seconds())))
+			writeError(w, r, http.StatusTooManyRequests, errCodePhoneCodeThrottled)
+			return
+		}
+		// The code is counted before it is sent, so racing requests cannot
+		// exceed the daily cap, and handed back if sending fails
+		res, err := db.Exec(`
+        INSERT INTO phone_verification_usage (owner, day, codes_sent) VALUES ($1, current_date, 1)
+        ON CONFLICT (owner, day) DO UPDATE SET codes_sent = phone_verification_usage.codes_sent + 1
+        WHERE phone_verification_usage.codes_sent < $2`, owner, phoneCodesPerDay)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusTooManyRequests, errCodePhoneDailyLimit)
+			return
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		code := fmt.Sprintf("%06d", n)
+		body, ok := phoneCodeMessages[negotiateLanguage(r)]
+		if !ok {
+			body = phoneCodeMessages[defaultLanguage]
+		}
+		if err := sms.SendSMS(r.Context(), number, fmt.Sprintf(body, code)); err != nil {
+			if _, err := db.Exec(`
+            UPDATE phone_verification_usage SET codes_sent = codes_sent - 1
+            WHERE owner = $1 AND day = current_date AND codes_sent > 0`, owner); err != nil {
+				logAt(levelWarn, "sms: handing back the code of %s: %v", owner, err)
+			}
+			serverError(w, r, err)
+			return
+		}
+
+		// Only a code actually sent replaces the pending one and starts the
+		// throttle interval
+		_, err = db.Exec(`
+        INSERT INTO phone_verifications (owner, phone, code_hash, expires_at) VALUES ($1, $2, $3, now() + $4 * interval '1 second')
+        ON CONFLICT (owner) DO UPDATE
+        SET phone = EXCLUDED.phone, code_hash = EXCLUDED.code_hash, expires_at = EXCLUDED.expires_at, attempts = 0, sent_at = now()`,
+			owner, encryptedString(number), hashPhoneCode(owner, code), phoneCodeTTL.Seconds())
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// verifyPhone confirms the caller's phone number with the code texted by
+// postPhone, e.g. {"code": "042817"}.
+func verifyPhone(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireUser(w, r)
+		if owner == "" {
+			return
+		}
+		var data struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+
+		var verifiedAt time.Time
+		wrong := false
+		err := NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			wrong = false
+			var number encryptedString
+			var codeHash string
+			// Counting the attempt first limits guesses even when requests
+			// race; the daily count survives new codes
+			res, err := tx.q.ExecContext(r.Context(), `
+            INSERT INTO phone_verification_usage (owner, day, attempts) VALUES ($1, current_date, 1)
+            ON CONFLICT (owner, day) DO UPDATE SET attempts = phone_verification_usage.attempts + 1
+            WHERE phone_verification_usage.attempts < $2`, owner, phoneAttemptsPerDay)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return &apiError{http.StatusTooManyRequests, errCodePhoneDailyLimit}
+			}
+			err = tx.q.QueryRowContext(r.Context(), `
+            UPDATE phone_verifications SET attempts = attempts + 1
+            WHERE owner = $1 AND expires_at > now() AND attempts < $2
+            RETURNING phone, code_hash`, owner, phoneCodeAttempts).Scan(&number, &codeHash)
+			if errors.Is(err, sql.ErrNoRows) {
+				return &apiError{http.StatusUnprocessableEntity, errCodeInvalidPhoneCode}
+			}
+			if err != nil {
+				return err
+			}
+			if subtle.ConstantTimeCompare([]byte(codeHash), []byte(hashPhoneCode(owner, strings.TrimSpace(data.Code)))) != 1 {
+				// Returning nil commits the counted attempt
+				wrong = true
+				return nil
+			}
+
+			if _, err := tx.q.ExecContext(r.Context(), "DELETE FROM phone_verifications WHERE owner = $1", owner); err != nil {
+				return err
+			}
+			return tx.q.QueryRowContext(r.Context(), `
+            INSERT INTO phones (owner, phone, verified_at) VALUES ($1, $2, now())
+            ON CONFLICT (owner) DO UPDATE SET phone = EXCLUDED.phone, verified_at = EXCLUDED.verified_at
+            RETURNING verified_at`, owner, number).Scan(&verifiedAt)
+		})
+		if err == nil && wrong {
+			err = &apiError{http.StatusUnprocessableEntity, errCodeInvalidPhoneCode}
+		}
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			VerifiedAt time.Time `json:"verified_at"`
+		}{verifiedAt})
+	}
+}
+
+// deletePhone removes the caller's phone number; SMS notifications stop.
+func deletePhone(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireUser(w, r)
+		if owner == "" {
+			return
+		}
+
+		err := NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			if _, err := tx.q.ExecContext(r.Context(), "DELETE FROM phone_verifications WHERE owner = $1", owner); err != nil {
+				return err
+			}
+			_, err := tx.q.ExecContext(r.Context(), "DELETE FROM phones WHERE owner = $1", owner)
+			return err
+		})
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// takeSnapshot records today's stock and price of every sneaker. Only the first run of
+// each day is kept, so the snapshot reflects the state at the start of the day
+// no matter how often the job runs.
+func takeSnapshot(db *sql.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, `
+        INSERT INTO item_snapshots (snapshot_date, item_id, stock, price)
+        SELECT current_date, id, stock, price FROM sneakers WHERE deleted_at IS NULL
+        ON CONFLICT (snapshot_date, item_id) DO NOTHING`)
+		return err
+	}
+}
+
+// getInventorySnapshot returns the snapshot for ?date=YYYY-MM-DD, today by default.
+func getInventorySnapshot(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		date := time.Now()
+		if raw := r.URL.Query().Get("date"); raw != "" {
+			var err error
+			if date, err = time.Parse(time.DateOnly, raw); err != nil {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidDate)
+				return
+			}
+		}
+
+		rows, err := db.Query(`
+        SELECT n.item_id, s.title, n.stock, n.price
+        FROM item_snapshots n
+        INNER JOIN sneakers s ON s.id = n.item_id
+        WHERE n.snapshot_date = $1
+        ORDER BY n.item_id`, date.Format(time.DateOnly))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type line struct {
+			ItemID int    `json:"item_id"`
+			Title  string `json:"title"`
+			Stock  *int   `json:"stock"`
+			Price  Money  `json:"price"`
+		}
+		snapshot := struct {
+			Date  string `json:"date"`
+			Items []line `json:"items"`
+		}{Date: date.Format(time.DateOnly), Items: []line{}}
+		for rows.Next() {
+			var l line
+			if err := rows.Scan(&l.ItemID, &l.Title, &l.Stock, &l.Price); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			snapshot.Items = append(snapshot.Items, l)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snapshot)
+	}
+}
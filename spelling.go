@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// vocabulary maps every word used in the catalog to its number of occurrences.
+// It is rebuilt periodically by a job and swapped atomically.
+var vocabulary atomic.Pointer[map[string]int]
+
+// buildVocabulary collects the words of titles, style codes, materials and tag
+// names across the catalog.
+func buildVocabulary(db *sql.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		rows, err := db.QueryContext(ctx, `
+        SELECT title || ' ' || coalesce(style_code, '') || ' ' || array_to_string(materials, ' ') FROM sneakers WHERE `+itemVisible("")+`
+        UNION ALL
+        SELECT name FROM tags`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		words := map[string]int{}
+		for rows.Next() {
+			var text string
+			if err := rows.Scan(&text); err != nil {
+				return err
+			}
+			for _, w := range searchTerms(text) {
+				words[w]++
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		vocabulary.Store(&words)
+		return nil
+	}
+}
+
+// suggestQuery proposes a spelling correction for q. It only answers when every
+// unknown word has a single clear best match in the catalog vocabulary, so a
+// suggestion is never a guess between equally plausible words.
+func suggestQuery(q string) (string, bool) {
+	vocab := vocabulary.Load()
+	if vocab == nil {
+		return "", false
+	}
+
+	terms := searchTerms(q)
+	changed := false
+	for i, term := range terms {
+		if _, known := (*vocab)[term]; known || isNumber(term) {
+			continue
+		}
+		correction, ok := closestWord(*vocab, term)
+		if !ok {
+			return "", false
+		}
+		terms[i] = correction
+		changed = true
+	}
+	if !changed {
+		return "", false
+	}
+	return strings.Join(terms, " "), true
+}
+
+// closestWord finds the vocabulary word nearest to term. Short words tolerate a
+// single edit, longer ones two; ties on distance are broken by frequency and
+// a remaining tie means there is no confident answer.
+func closestWord(vocab map[string]int, term string) (string, bool) {
+	maxDistance := 1
+	if len([]rune(term)) > 4 {
+		maxDistance = 2
+	}
+
+	best, bestDistance, bestCount, tied := "", maxDistance+1, 0, false
+	for word, count := range vocab {
+		d := editDistance(term, word)
+		switch {
+		case d < bestDistance || (d == bestDistance && count > bestCount):
+			best, bestDistance, bestCount, tied = word, d, count, false
+		case d == bestDistance && count == bestCount:
+			tied = true
+		}
+	}
+	return best, best != "" && !tied
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// insertions, deletions, substitutions and adjacent transpositions ("jordna"
+// -> "jordan") each cost one.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+func isNumber(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// loggingQuerier logs every statement run through it at debug level. Parameter values are never logged, only their shapes, and string
+// literals in the statement text are masked, so customer data stays out of
+// the logs.
+type loggingQuerier struct {
+	q querier
+}
+
+func (l loggingQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	res, err := l.q.ExecContext(ctx, query, args...)
+	if logEnabled(levelDebug) {
+		rows := "?"
+		if err == nil {
+			if n, err := res.RowsAffected(); err == nil {
+				rows = fmt.Sprint(n)
+			}
+		}
+		logQuery(query, args, rows, time.Since(start), err)
+	}
+	return res, err
+}
+
+// QueryContext logs the time until the first result; the number of rows is
+// not known until the caller has read them.
+func (l loggingQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := l.q.QueryContext(ctx, query, args...)
+	if logEnabled(levelDebug) {
+		logQuery(query, args, "?", time.Since(start), err)
+	}
+	return rows, err
+}
+
+func (l loggingQuerier) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	start := time.Now()
+	row := l.q.QueryRowContext(ctx, query, args...)
+	if logEnabled(levelDebug) {
+		logQuery(query, args, "1", time.Since(start), row.Err())
+	}
+	return row
+}
+
+var (
+	sqlStringLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlWhitespace    = regexp.MustCompile(`\s+`)
+)
+
+func logQuery(query string, args []any, rows string, elapsed time.Duration, err error) {
+	query = sqlStringLiteral.ReplaceAllString(query, "'?'")
+	query = strings.TrimSpace(sqlWhitespace.ReplaceAllString(query, " "))
+
+	shapes := make([]string, len(args))
+	for n, arg := range args {
+		shapes[n] = argShape(arg)
+	}
+
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	logAt(levelDebug, "sql: %s args=[%s] rows=%s elapsed=%s status=%q", query, strings.Join(shapes, " "), rows, elapsed.Round(time.Microsecond), status)
+}
+
+// argShape describes a query parameter without revealing its value.
+func argShape(arg any) string {
+	switch v := arg.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("string(%d)", len(v))
+	case []byte:
+		return fmt.Sprintf("bytes(%d)", len(v))
+	case *string:
+		if v == nil {
+			return "null"
+		}
+		return fmt.Sprintf("string(%d)", len(*v))
+	case *int:
+		if v == nil {
+			return "null"
+		}
+		return "int"
+	default:
+		return fmt.Sprintf("%T", arg)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Store holds the repository operations shared between handlers. A Store
+// created by NewStore runs each call on its own; the Store passed to a
+// WithTx callback runs them all in one transaction.
+type Store struct {
+	db   *sql.DB
+	q    querier
+	inTx bool
+}
+
+func NewStore(db *sql.DB) Store {
+	return Store{db: db, q: loggingQuerier{db}}
+}
+
+// WithTx runs fn with a Store bound to a new transaction, committing when fn
+// returns nil and rolling back otherwise. Serialization failures and deadlocks
+// are retried as described for withTx. Calling WithTx on a transactional
+// Store joins the surrounding transaction.
+func (s Store) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	return withTx(ctx, s.db, nil, func(tx *sql.Tx) error {
+		return fn(Store{db: s.db, q: loggingQuerier{tx}, inTx: true})
+	})
+}
+
+// TouchItem bumps the Last-Modified of a sneaker after a change to data
+// embedded in it. It reports false when the sneaker does not exist.
+func (s Store) TouchItem(ctx context.Context, itemID int) (bool, error) {
+	res, err := s.q.ExecContext(ctx, "UPDATE sneakers SET updated_at = now() WHERE id = $1", itemID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// AttachTag adds a tag to a sneaker, creating the tag if it does not exist yet.
+// The name must already be validated with slugify.
+func (s Store) AttachTag(ctx context.Context, itemID int, name string) error {
+	var tagID int
+	err := s.q.QueryRowContext(ctx, `
+    INSERT INTO tags (name, slug) VALUES ($1, $2)
+    ON CONFLICT (slug) DO UPDATE SET slug = EXCLUDED.slug
+    RETURNING id`, strings.TrimSpace(name), slugify(name)).Scan(&tagID)
+	if err != nil {
+		return err
+	}
+	_, err = s.q.ExecContext(ctx, "INSERT INTO item_tags (item_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", itemID, tagID)
+	return err
+}
+
+// DetachTag removes the tag with the given slug from a sneaker. It reports
+// false when the sneaker did not carry the tag.
+func (s Store) DetachTag(ctx context.Context, itemID int, slug string) (bool, error) {
+	res, err := s.q.ExecContext(ctx, `
+    DELETE FROM item_tags
+    WHERE item_id = $1 AND tag_id = (SELECT id FROM tags WHERE slug = $2)`, itemID, slug)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetItemTags replaces the tags of a sneaker, creating tags that do not exist
+// yet. Names must already be validated with slugify.
+func (s Store) SetItemTags(ctx context.Context, itemID int, names []string) error {
+	if _, err := s.q.ExecContext(ctx, "DELETE FROM item_tags WHERE item_id = $1", itemID); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := s.AttachTag(ctx, itemID, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
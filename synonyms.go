@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+)
+
+// searchDictionary rewrites search queries the way sneakerheads actually type
+// them: synonyms ("aj1" -> "air jordan 1") are expanded and stop words dropped.
+type searchDictionary struct {
+	synonyms     map[string][]string // term words joined by a space -> replacement words
+	maxTermWords int
+	stopwords    map[string]bool
+}
+
+// dictionary is the dictionary in use. It is swapped atomically on reload.
+var dictionary atomic.Pointer[searchDictionary]
+
+// rewriteQuery applies the current dictionary to q, preferring the longest
+// matching term. Queries the dictionary does not touch, and queries made only
+// of stop words, are returned unchanged.
+func rewriteQuery(q string) string {
+	d := dictionary.Load()
+	if d == nil {
+		return q
+	}
+
+	words := searchTerms(q)
+	var out []string
+	changed := false
+	for i := 0; i < len(words); {
+		matched := false
+		for n := min(d.maxTermWords, len(words)-i); n > 0; n-- {
+			if replacement, ok := d.synonyms[strings.Join(words[i:i+n], " ")]; ok {
+				out = append(out, replacement...)
+				i += n
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			if !d.stopwords[words[i]] {
+				out = append(out, words[i])
+			}
+			i++
+		}
+		changed = changed || matched || len(out) < i
+	}
+	if !changed || len(out) == 0 {
+		return q
+	}
+	return strings.Join(out, " ")
+}
+
+// loadDictionary reads synonyms and stop words from the database and makes
+// them the active dictionary.
+func loadDictionary(ctx context.Context, db *sql.DB) error {
+	d := &searchDictionary{synonyms: map[string][]string{}, stopwords: map[string]bool{}}
+
+	rows, err := db.QueryContext(ctx, "SELECT term, replacement FROM search_synonyms")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var term, replacement string
+		if err := rows.Scan(&term, &replacement); err != nil {
+			return err
+		}
+		words := searchTerms(term)
+		d.synonyms[strings.Join(words, " ")] = searchTerms(replacement)
+		d.maxTermWords = max(d.maxTermWords, len(words))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.QueryContext(ctx, "SELECT word FROM search_stopwords")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var word string
+		if err := rows.Scan(&word); err != nil {
+			return err
+		}
+		d.stopwords[word] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	dictionary.Store(d)
+	return nil
+}
+
+// getSearchDictionary lists the configured synonyms and stop words.
+func getSearchDictionary(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type synonym struct {
+			Term        string `json:"term"`
+			Replacement string `json:"replacement"`
+		}
+		result := struct {
+			Synonyms  []synonym `json:"synonyms"`
+			Stopwords []string  `json:"stopwords"`
+		}{Synonyms: []synonym{}, Stopwords: []string{}}
+
+		rows, err := db.Query("SELECT term, replacement FROM search_synonyms ORDER BY term")
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var s synonym
+			if err := rows.Scan(&s.Term, &s.Replacement); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			result.Synonyms = append(result.Synonyms, s)
+		}
+
+		rows, err = db.Query("SELECT word FROM search_stopwords ORDER BY word")
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var word string
+			if err := rows.Scan(&word); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			result.Stopwords = append(result.Stopwords, word)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// putSynonym creates or replaces the synonym for the {term} path variable.
+func putSynonym(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data struct {
+			Replacement string `json:"replacement"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		term := strings.Join(searchTerms(mux.Vars(r)["term"]), " ")
+		replacement := strings.Join(searchTerms(data.Replacement), " ")
+		if term == "" || replacement == "" {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidSynonym)
+			return
+		}
+
+		_, err := db.Exec(`
+        INSERT INTO search_synonyms (term, replacement) VALUES ($1, $2)
+        ON CONFLICT (term) DO UPDATE SET replacement = EXCLUDED.replacement`, term, replacement)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if err := loadDictionary(r.Context(), db); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func deleteSynonym(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		term := strings.Join(searchTerms(mux.Vars(r)["term"]), " ")
+		res, err := db.Exec("DELETE FROM search_synonyms WHERE term = $1", term)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeSynonymNotFound)
+			return
+		}
+		if err := loadDictionary(r.Context(), db); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func putStopword(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		words := searchTerms(mux.Vars(r)["word"])
+		if len(words) != 1 {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidSynonym)
+			return
+		}
+
+		if _, err := db.Exec("INSERT INTO search_stopwords (word) VALUES ($1) ON CONFLICT DO NOTHING", words[0]); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if err := loadDictionary(r.Context(), db); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func deleteStopword(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := db.Exec("DELETE FROM search_stopwords WHERE word = $1", strings.ToLower(mux.Vars(r)["word"]))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeSynonymNotFound)
+			return
+		}
+		if err := loadDictionary(r.Context(), db); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// reloadSearchDictionary re-reads the dictionary, e.g. after it was edited
+// directly in the database or on another instance.
+func reloadSearchDictionary(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := loadDictionary(r.Context(), db); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
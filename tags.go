@@ -0,0 +1,356 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// tag is a free-form merchandising label such as "retro" or "limited".
+type tag struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int    `json:"count"`
+}
+
+// slugify lowercases s and collapses every run of non-alphanumeric characters
+// into a single dash, e.g. "Air Max '90" becomes "air-max-90".
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// queryLimit parses the "limit" query parameter, defaulting to def and capping
+// at max. It reports false when the parameter is present but not a positive number.
+func queryLimit(r *http.Request, def, max int) (int, bool) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return def, true
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 {
+		return 0, false
+	}
+	return min(limit, max), true
+}
+
+// queryOffset parses the "offset" query parameter, defaulting to 0. It
+// reports false when the parameter is present but not a number of at least 0.
+func queryOffset(r *http.Request) (int, bool) {
+	raw := r.URL.Query().Get("offset")
+	if raw == "" {
+		return 0, true
+	}
+	offset, err := strconv.Atoi(raw)
+	if err != nil || offset < 0 {
+		return 0, false
+	}
+	return offset, true
+}
+
+// getTagCloud lists tags with the number of sneakers carrying each one in the
+// caller's market, most used first.
+func getTagCloud(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := queryLimit(r, 50, 500)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT t.id, t.name, t.slug, count(it.item_id)
+        FROM tags t
+        LEFT JOIN item_tags it ON it.tag_id = t.id AND it.item_id IN (SELECT id FROM sneakers WHERE `+itemVisible("")+` AND `+itemInMarket("", "$2")+`)
+        GROUP BY t.id
+        ORDER BY count(it.item_id) DESC, t.name
+        LIMIT $1`, limit, requestMarket(w, r))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		tags := []tag{}
+		for rows.Next() {
+			var t tag
+			if err := rows.Scan(&t.ID, &t.Name, &t.Slug, &t.Count); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			tags = append(tags, t)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tags)
+	}
+}
+
+// getTagFeed lists the newest sneakers carrying the tag identified by slug.
+func getTagFeed(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := queryLimit(r, 20, 100)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+
+		var tagID int
+		err := db.QueryRow("SELECT id FROM tags WHERE slug = $1", mux.Vars(r)["slug"]).Scan(&tagID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeTagNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT `+itemColumns+`
+        FROM sneakers
+        WHERE id IN (SELECT item_id FROM item_tags WHERE tag_id = $1) AND `+itemVisible("")+` AND `+itemInMarket("", "$3")+`
+        ORDER BY created_at DESC, id DESC
+        LIMIT $2`, tagID, limit, requestMarket(w, r))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		items := []item{}
+		for rows.Next() {
+			i, err := scanItem(rows)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			items = append(items, i)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+func createTag(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		t := tag{Name: strings.TrimSpace(data.Name), Slug: slugify(data.Name)}
+		if t.Slug == "" {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidTagName)
+			return
+		}
+
+		err := db.QueryRow("INSERT INTO tags (name, slug) VALUES ($1, $2) RETURNING id", t.Name, t.Slug).Scan(&t.ID)
+		if isUniqueViolation(err) {
+			writeError(w, r, http.StatusConflict, errCodeTagExists)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/tags/%s/items", t.Slug))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+	}
+}
+
+func renameTag(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tagID, err := strconv.Atoi(mux.Vars(r)["tagId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidTagID)
+			return
+		}
+
+		var data struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		t := tag{ID: tagID, Name: strings.TrimSpace(data.Name), Slug: slugify(data.Name)}
+		if t.Slug == "" {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidTagName)
+			return
+		}
+
+		err = db.QueryRow(`
+        UPDATE tags SET name = $2, slug = $3 WHERE id = $1
+        RETURNING (SELECT count(*) FROM item_tags WHERE tag_id = $1)`, t.ID, t.Name, t.Slug).Scan(&t.Count)
+		if isUniqueViolation(err) {
+			writeError(w, r, http.StatusConflict, errCodeTagExists)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeTagNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t)
+	}
+}
+
+func deleteTag(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tagID, err := strconv.Atoi(mux.Vars(r)["tagId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidTagID)
+			return
+		}
+
+		res, err := db.Exec("DELETE FROM tags WHERE id = $1", tagID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeTagNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// putItemTags replaces the tags of a sneaker, creating tags that do not exist yet.
+func putItemTags(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		var data struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		for _, name := range data.Tags {
+			if slugify(name) == "" {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidTagName)
+				return
+			}
+		}
+
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			// Touching the sneaker both checks it exists and bumps its Last-Modified
+			found, err := tx.TouchItem(r.Context(), itemID)
+			if err != nil {
+				return err
+			}
+			if !found {
+				return &apiError{http.StatusNotFound, errCodeItemNotFound}
+			}
+			return tx.SetItemTags(r.Context(), itemID, data.Tags)
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// attachItemTag adds the tag named in the URL to a sneaker, creating the tag
+// if needed. Attaching a tag the sneaker already carries is a no-op.
+func attachItemTag(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+		name := mux.Vars(r)["tag"]
+		if slugify(name) == "" {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidTagName)
+			return
+		}
+
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			found, err := tx.TouchItem(r.Context(), itemID)
+			if err != nil {
+				return err
+			}
+			if !found {
+				return &apiError{http.StatusNotFound, errCodeItemNotFound}
+			}
+			return tx.AttachTag(r.Context(), itemID, name)
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// detachItemTag removes a tag, identified by name or slug, from a sneaker.
+func detachItemTag(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			detached, err := tx.DetachTag(r.Context(), itemID, slugify(mux.Vars(r)["tag"]))
+			if err != nil {
+				return err
+			}
+			if !detached {
+				return &apiError{http.StatusNotFound, errCodeTagNotFound}
+			}
+			_, err = tx.TouchItem(r.Context(), itemID)
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
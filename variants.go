@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// variant is one size of a sneaker, stocked and sold under its own SKU.
+type variant struct {
+	ID      int     `json:"id"`
+	ItemID  int     `json:"item_id"`
+	USSize  string  `json:"us_size"`
+	EUSize  string  `json:"eu_size"`
+	SKU     string  `json:"sku"`
+	Barcode *string `json:"barcode"`
+	Stock   int     `json:"stock"`
+}
+
+const variantColumns = "id, item_id, us_size, eu_size, sku, barcode, stock"
+
+func scanVariant(row rowScanner) (variant, error) {
+	var v variant
+	err := row.Scan(&v.ID, &v.ItemID, &v.USSize, &v.EUSize, &v.SKU, &v.Barcode, &v.Stock)
+	return v, err
+}
+
+// validate normalizes the input and returns the error code describing the
+// first invalid field, or "" when it is valid.
+func (v *variant) validate() string {
+	v.USSize = strings.TrimSpace(v.USSize)
+	v.EUSize = strings.TrimSpace(v.EUSize)
+	v.SKU = strings.TrimSpace(v.SKU)
+	v.Barcode = normalizeCode(v.Barcode)
+	if v.USSize == "" || v.EUSize == "" || v.SKU == "" {
+		return errCodeInvalidVariant
+	}
+	if v.Barcode != nil && !validGTIN(*v.Barcode) {
+		return errCodeInvalidBarcode
+	}
+	if v.Stock < 0 {
+		return errCodeInvalidStock
+	}
+	return ""
+}
+
+// embedVariants loads the variants of items in one query.
+func embedVariants(db *sql.DB, items []item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	index := make(map[int]*item, len(items))
+	ids := make([]int, len(items))
+	for n := range items {
+		items[n].Variants = []variant{}
+		index[items[n].ID] = &items[n]
+		ids[n] = items[n].ID
+	}
+
+	rows, err := db.Query(`
+    SELECT `+variantColumns+` FROM item_variants
+    WHERE item_id = ANY($1)
+    ORDER BY item_id, id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v, err := scanVariant(rows)
+		if err != nil {
+			return err
+		}
+		index[v.ItemID].Variants = append(index[v.ItemID].Variants, v)
+	}
+	return rows.Err()
+}
+
+func getVariants(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		var updatedAt time.Time
+		err = db.QueryRow("SELECT "+itemModified+" FROM sneakers WHERE id = $1 AND "+itemVisible("")+" AND "+itemInMarket("", "$2"), itemID, requestMarket(w, r)).Scan(&updatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeItemNotFound)
+			return
+		} else if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if notModified(w, r, updatedAt) {
+			return
+		}
+
+		rows, err := db.Query("SELECT "+variantColumns+" FROM item_variants WHERE item_id = $1 ORDER BY id", itemID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		variants := []variant{}
+		for rows.Next() {
+			v, err := scanVariant(rows)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			variants = append(variants, v)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(variants)
+	}
+}
+
+// createVariant adds a size to a sneaker. Variant changes bump the sneaker's
+// updated_at so cached item responses embedding variants are revalidated.
+func createVariant(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		var v variant
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if code := v.validate(); code != "" {
+			writeError(w, r, http.StatusUnprocessableEntity, code)
+			return
+		}
+
+		var created variant
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			var err error
+			created, err = scanVariant(tx.q.QueryRowContext(r.Context(), `
+            INSERT INTO item_variants (item_id, us_size, eu_size, sku, barcode, stock)
+            VALUES ($1, $2, $3, $4, $5, $6)
+            RETURNING `+variantColumns, itemID, v.USSize, v.EUSize, v.SKU, v.Barcode, v.Stock))
+			if isForeignKeyViolation(err) {
+				return &apiError{http.StatusNotFound, errCodeItemNotFound}
+			}
+			if isUniqueViolation(err) {
+				if code := uniqueCode(err); code == errCodeBarcodeExists {
+					return &apiError{http.StatusConflict, code}
+				}
+				return &apiError{http.StatusConflict, errCodeVariantExists}
+			}
+			if err != nil {
+				return err
+			}
+			_, err = tx.TouchItem(r.Context(), itemID)
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/items/%d/variants", itemID))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	}
+}
+
+// updateVariant replaces the sizes, SKU and stock of a variant.
+func updateVariant(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+		variantID, err := strconv.Atoi(mux.Vars(r)["variantId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidVariantID)
+			return
+		}
+
+		var v variant
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if code := v.validate(); code != "" {
+			writeError(w, r, http.StatusUnprocessableEntity, code)
+			return
+		}
+
+		var updated variant
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			var err error
+			updated, err = scanVariant(tx.q.QueryRowContext(r.Context(), `
+            UPDATE item_variants SET us_size = $3, eu_size = $4, sku = $5, barcode = $6, stock = $7
+            WHERE id = $1 AND item_id = $2
+            RETURNING `+variantColumns, variantID, itemID, v.USSize, v.EUSize, v.SKU, v.Barcode, v.Stock))
+			if errors.Is(err, sql.ErrNoRows) {
+				return &apiError{http.StatusNotFound, errCodeVariantNotFound}
+			}
+			if isUniqueViolation(err) {
+				if code := uniqueCode(err); code == errCodeBarcodeExists {
+					return &apiError{http.StatusConflict, code}
+				}
+				return &apiError{http.StatusConflict, errCodeVariantExists}
+			}
+			if err != nil {
+				return err
+			}
+			_, err = tx.TouchItem(r.Context(), itemID)
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(updated)
+	}
+}
+
+// deleteVariant removes a size. Favorites pointing at it fall back to the
+// sneaker as a whole.
+func deleteVariant(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+		variantID, err := strconv.Atoi(mux.Vars(r)["variantId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidVariantID)
+			return
+		}
+
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			res, err := tx.q.ExecContext(r.Context(), "DELETE FROM item_variants WHERE id = $1 AND item_id = $2", variantID, itemID)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return &apiError{http.StatusNotFound, errCodeVariantNotFound}
+			}
+			_, err = tx.TouchItem(r.Context(), itemID)
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
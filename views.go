@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// recentlyViewedLimit is how many sneakers a caller's recently viewed list
+// keeps; older views are dropped as new ones come in.
+const recentlyViewedLimit = 30
+
+// postItemView records that the caller opened a sneaker. Viewing it again
+// moves it back to the front of their recently viewed list.
+func postItemView(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		market := requestMarket(w, r)
+		err = NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			res, err := tx.q.ExecContext(r.Context(), `
+            INSERT INTO recently_viewed (owner, item_id)
+            SELECT $1, id FROM sneakers WHERE id = $2 AND `+itemVisible("")+` AND `+itemInMarket("", "$3")+`
+            ON CONFLICT (owner, item_id) DO UPDATE SET viewed_at = now()`, owner, itemID, market)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return &apiError{http.StatusNotFound, errCodeItemNotFound}
+			}
+			_, err = tx.q.ExecContext(r.Context(), `
+            DELETE FROM recently_viewed
+            WHERE owner = $1 AND item_id NOT IN (
+                SELECT item_id FROM recently_viewed WHERE owner = $1 ORDER BY viewed_at DESC LIMIT $2
+            )`, owner, recentlyViewedLimit)
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getRecentlyViewed returns the sneakers the caller viewed last, most recent
+// first, for the recently viewed carousel.
+func getRecentlyViewed(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT `+itemColumns+`, v.viewed_at
+        FROM recently_viewed v
+        INNER JOIN sneakers ON sneakers.id = v.item_id
+        WHERE v.owner = $1 AND `+itemVisible("sneakers")+` AND `+itemInMarket("sneakers", "$3")+`
+        ORDER BY v.viewed_at DESC
+        LIMIT $2`, owner, recentlyViewedLimit, requestMarket(w, r))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		type viewedItem struct {
+			item
+			ViewedAt time.Time `json:"viewed_at"`
+		}
+		items := []viewedItem{}
+		for rows.Next() {
+			var v viewedItem
+			var err error
+			v.item, err = scanItem(scannerFunc(func(dest ...any) error {
+				return rows.Scan(append(dest, &v.ViewedAt)...)
+			}))
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			items = append(items, v)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+// deleteRecentlyViewed clears the caller's recently viewed list.
+func deleteRecentlyViewed(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+
+		if _, err := db.Exec("DELETE FROM recently_viewed WHERE owner = $1", owner); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
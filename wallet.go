@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// errInsufficientCredit is returned by DebitWallet when the balance does not
+// cover the amount.
+var errInsufficientCredit = errors.New("insufficient store credit")
+
+// walletEntry is a line of a store credit ledger. Credits are positive,
+// spending is negative.
+type walletEntry struct {
+	ID        int64     `json:"id"`
+	Amount    Money     `json:"amount"`
+	Reason    string    `json:"reason"` // goodwill, refund or checkout
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// walletReasons are the reasons support can grant credit for.
+var walletReasons = map[string]bool{"goodwill": true, "refund": true}
+
+// CreditWallet adds amount to the store credit of owner and records it in
+// their ledger.
+func (s Store) CreditWallet(ctx context.Context, owner string, amount int, reason, note string) (walletEntry, error) {
+	var e walletEntry
+	err := s.WithTx(ctx, func(tx Store) error {
+		_, err := tx.q.ExecContext(ctx, `
+        INSERT INTO wallets (owner, balance) VALUES ($1, $2)
+        ON CONFLICT (owner) DO UPDATE SET balance = wallets.balance + EXCLUDED.balance`, owner, amount)
+		if err != nil {
+			return err
+		}
+		return tx.q.QueryRowContext(ctx, `
+        INSERT INTO wallet_entries (owner, amount, reason, note) VALUES ($1, $2, $3, $4)
+        RETURNING id, amount, reason, note, created_at`, owner, amount, reason, note).
+			Scan(&e.ID, &e.Amount, &e.Reason, &e.Note, &e.CreatedAt)
+	})
+	return e, err
+}
+
+// DebitWallet spends amount of the store credit of owner, e.g. at checkout
+// with the order number as note. Like DecrementStock it must run on the
+// Store of the order transaction; the conditional update keeps concurrent
+// checkouts from spending the same credit twice.
+func (s Store) DebitWallet(ctx context.Context, owner string, amount int, note string) error {
+	res, err := s.q.ExecContext(ctx, "UPDATE wallets SET balance = balance - $2 WHERE owner = $1 AND balance >= $2", owner, amount)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errInsufficientCredit
+	}
+	_, err = s.q.ExecContext(ctx, `
+    INSERT INTO wallet_entries (owner, amount, reason, note) VALUES ($1, $2, 'checkout', $3)`, owner, -amount, note)
+	return err
+}
+
+// WalletBalance returns the store credit of owner.
+func (s Store) WalletBalance(ctx context.Context, owner string) (int, error) {
+	var balance int
+	err := s.q.QueryRowContext(ctx, "SELECT balance FROM wallets WHERE owner = $1", owner).Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return balance, err
+}
+
+// requireUser answers 401 and returns "" unless the caller is a signed-in
+// user; store credit is never held by an anonymous device.
+func requireUser(w http.ResponseWriter, r *http.Request) string {
+	owner := requireCaller(w, r)
+	if owner != "" && !strings.HasPrefix(owner, "user:") {
+		writeError(w, r, http.StatusUnauthorized, errCodeSignInRequired)
+		return ""
+	}
+	return owner
+}
+
+// getWallet returns the caller's store credit balance and their most recent
+// ledger entries.
+func getWallet(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireUser(w, r)
+		if owner == "" {
+			return
+		}
+		limit, ok := queryLimit(r, 50, 200)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidLimit)
+			return
+		}
+
+		balance, err := NewStore(db).WalletBalance(r.Context(), owner)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		rows, err := db.Query(`
+        SELECT id, amount, reason, note, created_at FROM wallet_entries
+        WHERE owner = $1
+        ORDER BY created_at DESC, id DESC
+        LIMIT $2`, owner, limit)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		wallet := struct {
+			Balance Money         `json:"balance"`
+			Entries []walletEntry `json:"entries"`
+		}{Balance: money(balance), Entries: []walletEntry{}}
+		for rows.Next() {
+			var e walletEntry
+			if err := rows.Scan(&e.ID, &e.Amount, &e.Reason, &e.Note, &e.CreatedAt); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			wallet.Entries = append(wallet.Entries, e)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(wallet)
+	}
+}
+
+// creditWallet lets support grant store credit to a user, as goodwill or
+// instead of a card refund, e.g. {"amount": {"amount": 2000, "currency":
+// "EUR"}, "reason": "goodwill", "note": "late delivery"}.
+func creditWallet(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data struct {
+			Amount Money  `json:"amount"`
+			Reason string `json:"reason"`
+			Note   string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if data.Amount.Amount <= 0 || data.Amount.Currency != shopCurrency || !walletReasons[data.Reason] {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidCredit)
+			return
+		}
+
+		e, err := NewStore(db).CreditWallet(r.Context(), "user:"+mux.Vars(r)["userId"], data.Amount.Amount, data.Reason, strings.TrimSpace(data.Note))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(e)
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// wishlist is a named list of the caller's favorites, e.g. "Gifts". A
+// favorite is in one wishlist at most; favorites made while the caller has a
+// default wishlist go into it.
+type wishlist struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	IsDefault bool      `json:"is_default"`
+	ItemCount int       `json:"item_count"`
+	CreatedAt time.Time `json:"created_at"`
+	// ShareToken is set while the wishlist is public: anyone with
+	// /shared/wishlists/{token} can see it.
+	ShareToken *string `json:"share_token"`
+}
+
+// wishlistColumns is the select list matching scanWishlist; the item count
+// only includes sneakers sold in the market in placeholder.
+func wishlistColumns(placeholder string) string {
+	return `id, name, is_default,
+	(SELECT count(*) FROM favorite f INNER JOIN sneakers s ON s.id = f.item_id WHERE f.wishlist_id = wishlists.id AND ` + itemVisible("s") + ` AND ` + itemInMarket("s", placeholder) + `),
+	created_at, share_token`
+}
+
+func scanWishlist(row rowScanner) (wishlist, error) {
+	var l wishlist
+	err := row.Scan(&l.ID, &l.Name, &l.IsDefault, &l.ItemCount, &l.CreatedAt, &l.ShareToken)
+	return l, err
+}
+
+// maxWishlistName is the longest wishlist name, in characters.
+const maxWishlistName = 60
+
+// defaultWishlist is the SQL expression of the default wishlist of the owner
+// in parameter n, NULL when there is none.
+func defaultWishlist(n int) string {
+	return fmt.Sprintf("(SELECT id FROM wishlists WHERE owner = $%d AND is_default)", n)
+}
+
+// SetDefaultWishlist makes wishlistID the owner's default wishlist.
+func (s Store) SetDefaultWishlist(ctx context.Context, owner string, wishlistID int) error {
+	// Cleared first: the unique index on the default is checked row by row
+	if _, err := s.q.ExecContext(ctx, "UPDATE wishlists SET is_default = false WHERE owner = $1 AND is_default AND id <> $2", owner, wishlistID); err != nil {
+		return err
+	}
+	_, err := s.q.ExecContext(ctx, "UPDATE wishlists SET is_default = true WHERE id = $1", wishlistID)
+	return err
+}
+
+// wishlistID parses the wishlist ID of the request path.
+func wishlistID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["wishlistId"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidWishlistID)
+		return 0, false
+	}
+	return id, true
+}
+
+func getWishlists(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+
+		rows, err := db.Query("SELECT "+wishlistColumns("$2")+" FROM wishlists WHERE owner = $1 ORDER BY created_at, id", owner, requestMarket(w, r))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		wishlists := []wishlist{}
+		for rows.Next() {
+			l, err := scanWishlist(rows)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			wishlists = append(wishlists, l)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(wishlists)
+	}
+}
+
+// putWishlist creates a wishlist (POST) or renames one (PATCH), e.g.
+// {"name": "Gifts", "is_default": true}. Making a wishlist the default
+// replaces the previous default; omitted fields are left unchanged. With
+// "public": true the wishlist gets a share token; making it private again
+// revokes the token, so links shared before stop working.
+func putWishlist(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		listID := 0
+		if _, ok := mux.Vars(r)["wishlistId"]; ok {
+			if listID, ok = wishlistID(w, r); !ok {
+				return
+			}
+		}
+		var data struct {
+			Name      *string `json:"name"`
+			IsDefault *bool   `json:"is_default"`
+			Public    *bool   `json:"public"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if data.Name != nil {
+			*data.Name = strings.TrimSpace(*data.Name)
+		}
+		if (listID == 0 && data.Name == nil) || (data.Name != nil && (*data.Name == "" || utf8.RuneCountInString(*data.Name) > maxWishlistName)) {
+			writeError(w, r, http.StatusUnprocessableEntity, errCodeInvalidWishlistName)
+			return
+		}
+
+		market := requestMarket(w, r)
+		var l wishlist
+		err := NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			var err error
+			if listID == 0 {
+				err = tx.q.QueryRowContext(r.Context(), "INSERT INTO wishlists (owner, name) VALUES ($1, $2) RETURNING id", owner, *data.Name).Scan(&listID)
+			} else {
+				err = tx.q.QueryRowContext(r.Context(), `
+                UPDATE wishlists SET name = coalesce($3, name)
+                WHERE id = $1 AND owner = $2
+                RETURNING id`, listID, owner, data.Name).Scan(&listID)
+			}
+			if isUniqueViolation(err) {
+				return &apiError{http.StatusConflict, errCodeWishlistNameTaken}
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				return &apiError{http.StatusNotFound, errCodeWishlistNotFound}
+			}
+			if err != nil {
+				return err
+			}
+
+			if data.IsDefault != nil && *data.IsDefault {
+				err = tx.SetDefaultWishlist(r.Context(), owner, listID)
+			} else if data.IsDefault != nil {
+				_, err = tx.q.ExecContext(r.Context(), "UPDATE wishlists SET is_default = false WHERE id = $1", listID)
+			}
+			if err != nil {
+				return err
+			}
+			if data.Public != nil && *data.Public {
+				_, err = tx.q.ExecContext(r.Context(), "UPDATE wishlists SET share_token = coalesce(share_token, $2) WHERE id = $1", listID, newShareToken())
+			} else if data.Public != nil {
+				_, err = tx.q.ExecContext(r.Context(), "UPDATE wishlists SET share_token = NULL WHERE id = $1", listID)
+			}
+			if err != nil {
+				return err
+			}
+			l, err = scanWishlist(tx.q.QueryRowContext(r.Context(), "SELECT "+wishlistColumns("$2")+" FROM wishlists WHERE id = $1", listID, market))
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.Header().Set("Location", fmt.Sprintf("/wishlists/%d", l.ID))
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(l)
+	}
+}
+
+// deleteWishlist deletes a wishlist. Its sneakers stay favorited, outside
+// any wishlist.
+func deleteWishlist(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		listID, ok := wishlistID(w, r)
+		if !ok {
+			return
+		}
+
+		res, err := db.Exec("DELETE FROM wishlists WHERE id = $1 AND owner = $2", listID, owner)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, errCodeWishlistNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// wishlistItem is a favorite as listed in a wishlist.
+type wishlistItem struct {
+	ID         int            `json:"id"`
+	ItemID     int            `json:"item_id"`
+	VariantID  *int           `json:"variant_id"`
+	WishlistID int            `json:"wishlist_id"`
+	Item       sneakerSummary `json:"item"`
+}
+
+const wishlistItemColumns = "f.id, f.item_id, f.variant_id, f.wishlist_id, s.id, s.title, s.price, s.imageUrl"
+
+func scanWishlistItem(row rowScanner) (wishlistItem, error) {
+	var i wishlistItem
+	err := row.Scan(&i.ID, &i.ItemID, &i.VariantID, &i.WishlistID, &i.Item.ID, &i.Item.Title, &i.Item.Price, &i.Item.ImageURL)
+	return i, err
+}
+
+// getWishlistItems lists the sneakers of a wishlist in the favorites order.
+func getWishlistItems(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		listID, ok := wishlistID(w, r)
+		if !ok {
+			return
+		}
+
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM wishlists WHERE id = $1 AND owner = $2)", listID, owner).Scan(&exists); err != nil {
+			serverError(w, r, err)
+			return
+		}
+		if !exists {
+			writeError(w, r, http.StatusNotFound, errCodeWishlistNotFound)
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT `+wishlistItemColumns+`
+        FROM favorite f
+        INNER JOIN sneakers s ON f.item_id = s.id
+        WHERE f.wishlist_id = $1 AND `+itemVisible("s")+` AND `+itemInMarket("s", "$2")+`
+        ORDER BY f.position, f.id`, listID, requestMarket(w, r))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		items := []wishlistItem{}
+		for rows.Next() {
+			i, err := scanWishlistItem(rows)
+			if err != nil {
+				serverError(w, r, err)
+				return
+			}
+			items = append(items, i)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+// postWishlistItem puts a sneaker in a wishlist, {"item_id": 12}. A sneaker
+// not yet favorited is favorited (201); one already favorited moves from its
+// current wishlist (200).
+func postWishlistItem(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		listID, ok := wishlistID(w, r)
+		if !ok {
+			return
+		}
+		var data struct {
+			ItemID int `json:"item_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidBody)
+			return
+		}
+		if data.ItemID <= 0 {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		var item wishlistItem
+		created := false
+		err := NewStore(db).WithTx(r.Context(), func(tx Store) error {
+			var exists bool
+			err := tx.q.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM wishlists WHERE id = $1 AND owner = $2)", listID, owner).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return &apiError{http.StatusNotFound, errCodeWishlistNotFound}
+			}
+
+			res, err := tx.q.ExecContext(r.Context(), `
+            INSERT INTO favorite (owner, item_id, wishlist_id, position)
+            SELECT $1, $2, $3, (SELECT coalesce(max(position), 0) + 1 FROM favorite WHERE owner = $1)
+            WHERE EXISTS (SELECT 1 FROM sneakers WHERE id = $2 AND `+itemVisible("")+`)
+            ON CONFLICT ((coalesce(owner, '')), item_id) DO NOTHING`, owner, data.ItemID, listID)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 1 {
+				created = true
+			} else {
+				res, err := tx.q.ExecContext(r.Context(), `
+                UPDATE favorite SET wishlist_id = $3
+                WHERE owner = $1 AND item_id = $2 AND EXISTS (SELECT 1 FROM sneakers WHERE id = $2 AND `+itemVisible("")+`)`, owner, data.ItemID, listID)
+				if err != nil {
+					return err
+				}
+				if n, _ := res.RowsAffected(); n == 0 {
+					return &apiError{http.StatusNotFound, errCodeItemNotFound}
+				}
+			}
+
+			item, err = scanWishlistItem(tx.q.QueryRowContext(r.Context(), `
+            SELECT `+wishlistItemColumns+`
+            FROM favorite f
+            INNER JOIN sneakers s ON f.item_id = s.id
+            WHERE f.owner = $1 AND f.item_id = $2`, owner, data.ItemID))
+			return err
+		})
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(item)
+	}
+}
+
+// deleteWishlistItem removes a sneaker from a wishlist, which unfavorites it.
+func deleteWishlistItem(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := requireCaller(w, r)
+		if owner == "" {
+			return
+		}
+		listID, ok := wishlistID(w, r)
+		if !ok {
+			return
+		}
+		itemID, err := strconv.Atoi(mux.Vars(r)["itemId"])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidItemID)
+			return
+		}
+
+		_, err = db.Exec(`
+        DELETE FROM favorite
+        WHERE owner = $1 AND item_id = $2 AND wishlist_id = (SELECT id FROM wishlists WHERE id = $3 AND owner = $1)`, owner, itemID, listID)
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// newShareToken returns a random, unguessable share token.
+func newShareToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// getSharedWishlist returns the read-only view of a public wishlist, for the
+// friends its link was sent to: its name and sneakers, without anything
+// about its owner.
+func getSharedWishlist(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var shared struct {
+			Name  string           `json:"name"`
+			Items []sneakerSummary `json:"items"`
+		}
+		var listID int
+		err := db.QueryRow("SELECT id, name FROM wishlists WHERE share_token = $1", mux.Vars(r)["token"]).Scan(&listID, &shared.Name)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, errCodeWishlistNotFound)
+			return
+		}
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		rows, err := db.Query(`
+        SELECT s.id, s.title, s.price, s.imageUrl
+        FROM favorite f
+        INNER JOIN sneakers s ON f.item_id = s.id
+        WHERE f.wishlist_id = $1 AND `+itemVisible("s")+` AND `+itemInMarket("s", "$2")+`
+        ORDER BY f.position, f.id`, listID, requestMarket(w, r))
+		if err != nil {
+			serverError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		shared.Items = []sneakerSummary{}
+		for rows.Next() {
+			var s sneakerSummary
+			if err := rows.Scan(&s.ID, &s.Title, &s.Price, &s.ImageURL); err != nil {
+				serverError(w, r, err)
+				return
+			}
+			shared.Items = append(shared.Items, s)
+		}
+		if err := rows.Err(); err != nil {
+			serverError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(shared)
+	}
+}